@@ -0,0 +1,266 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+)
+
+const (
+	auditTable = "migration_audit_log"
+	// createAuditDDL 审计表，与 alembic_version 位于同一个库中
+	createAuditDDL = `CREATE TABLE IF NOT EXISTS migration_audit_log (
+    id BIGINT NOT NULL AUTO_INCREMENT,
+    operation VARCHAR(32) NOT NULL,
+    from_revision VARCHAR(64) NOT NULL DEFAULT '',
+    to_revision VARCHAR(64) NOT NULL DEFAULT '',
+    commit_id VARCHAR(64) NOT NULL DEFAULT '',
+    hostname VARCHAR(255) NOT NULL DEFAULT '',
+    operator VARCHAR(255) NOT NULL DEFAULT '',
+    started_at DATETIME(6) NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    outcome VARCHAR(16) NOT NULL,
+    error TEXT,
+    PRIMARY KEY (id),
+    KEY idx_started_at (started_at)
+)`
+	// createPostgresAuditDDL PostgreSQL 的审计表，索引以 createAuditIndexDDL 单独创建
+	createPostgresAuditDDL = `CREATE TABLE IF NOT EXISTS migration_audit_log (
+    id BIGSERIAL NOT NULL,
+    operation VARCHAR(32) NOT NULL,
+    from_revision VARCHAR(64) NOT NULL DEFAULT '',
+    to_revision VARCHAR(64) NOT NULL DEFAULT '',
+    commit_id VARCHAR(64) NOT NULL DEFAULT '',
+    hostname VARCHAR(255) NOT NULL DEFAULT '',
+    operator VARCHAR(255) NOT NULL DEFAULT '',
+    started_at TIMESTAMP(6) NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    outcome VARCHAR(16) NOT NULL,
+    error TEXT,
+    PRIMARY KEY (id)
+)`
+	// createSQLiteAuditDDL SQLite 的审计表，started_at 以文本保存，避免驱动将 DATETIME 列转换为 time.Time
+	createSQLiteAuditDDL = `CREATE TABLE IF NOT EXISTS migration_audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    operation VARCHAR(32) NOT NULL,
+    from_revision VARCHAR(64) NOT NULL DEFAULT '',
+    to_revision VARCHAR(64) NOT NULL DEFAULT '',
+    commit_id VARCHAR(64) NOT NULL DEFAULT '',
+    hostname VARCHAR(255) NOT NULL DEFAULT '',
+    operator VARCHAR(255) NOT NULL DEFAULT '',
+    started_at TEXT NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    outcome VARCHAR(16) NOT NULL,
+    error TEXT
+)`
+	createAuditIndexDDL = "CREATE INDEX IF NOT EXISTS idx_started_at ON migration_audit_log (started_at)"
+	insertAuditDML      = `INSERT INTO migration_audit_log
+(operation, from_revision, to_revision, commit_id, hostname, operator, started_at, duration_ms, outcome, error)
+VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	selectAuditDML = `SELECT id, operation, from_revision, to_revision, commit_id, hostname, operator,
+DATE_FORMAT(started_at, '%Y-%m-%d %H:%i:%s.%f'), duration_ms, outcome, COALESCE(error, '')
+FROM migration_audit_log ORDER BY id DESC`
+	selectPostgresAuditDML = `SELECT id, operation, from_revision, to_revision, commit_id, hostname, operator,
+to_char(started_at, 'YYYY-MM-DD HH24:MI:SS.US'), duration_ms, outcome, COALESCE(error, '')
+FROM migration_audit_log ORDER BY id DESC`
+	selectSQLiteAuditDML = `SELECT id, operation, from_revision, to_revision, commit_id, hostname, operator,
+started_at, duration_ms, outcome, COALESCE(error, '')
+FROM migration_audit_log ORDER BY id DESC`
+	// auditTimeout 写入审计记录的超时时间，审计记录在操作的 ctx 结束后仍需写入
+	auditTimeout = 10 * time.Second
+)
+
+// 审计记录的操作类型
+const (
+	AuditMigrate   = "migrate"
+	AuditUpgrade   = "upgrade"
+	AuditDowngrade = "downgrade"
+	AuditStamp     = "stamp"
+	AuditBaseline  = "baseline"
+)
+
+// 审计记录的结果
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	// AuditNoChanges Migrate 没有生成新版本
+	AuditNoChanges = "no_changes"
+)
+
+// AuditEntry 一条审计记录
+type AuditEntry struct {
+	ID           int64         `json:"id"`
+	Operation    string        `json:"operation"`
+	FromRevision string        `json:"from_revision"`
+	ToRevision   string        `json:"to_revision"`
+	CommitID     string        `json:"commit_id"`
+	Hostname     string        `json:"hostname"`
+	Operator     string        `json:"operator"`
+	StartedAt    time.Time     `json:"started_at"`
+	Duration     time.Duration `json:"duration"`
+	Outcome      string        `json:"outcome"`
+	Error        string        `json:"error,omitempty"`
+}
+
+func (e AuditEntry) String() string {
+	s := fmt.Sprintf("#%d %s %s %s %s -> %s by %s@%s in %s: %s", e.ID, e.StartedAt.Format(time.RFC3339), e.Operation,
+		orNone(e.CommitID), orBase(e.FromRevision), orBase(e.ToRevision), orNone(e.Operator), orNone(e.Hostname), e.Duration, e.Outcome)
+	if e.Error != "" {
+		s += ", " + e.Error
+	}
+	return s
+}
+
+// operator 执行迁移的操作人，未配置 Operator 时使用当前系统用户
+func (g *core) operator() string {
+	if operator := g.conf.GetOperator(); operator != "" {
+		return operator
+	}
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// audit 写入审计记录，写入失败只输出告警，不影响操作本身的结果
+func (g *core) audit(operation string, start time.Time, from, to string, err error) {
+	if !g.conf.GetAuditEnabled() {
+		return
+	}
+	entry := AuditEntry{
+		Operation:    operation,
+		FromRevision: from,
+		ToRevision:   to,
+		CommitID:     g.conf.GetCommitID(),
+		Operator:     g.operator(),
+		StartedAt:    start,
+		Duration:     time.Since(start),
+		Outcome:      AuditSuccess,
+	}
+	entry.Hostname, _ = os.Hostname()
+	switch {
+	case err == nil:
+	case operation == AuditMigrate && noNewRevision(err):
+		entry.Outcome = AuditNoChanges
+	default:
+		entry.Outcome, entry.Error = AuditFailure, err.Error()
+	}
+	if auditErr := g.writeAudit(entry); auditErr != nil {
+		g.logger.WarnWithFlag("write audit log failed", stageField(operation), revisionField(entry.ToRevision), Field{Key: "entry", Value: entry.String()}, Field{Key: FieldError, Value: auditErr})
+	}
+}
+
+// auditMigrate 写入 Migrate 的审计记录并更新 Metrics，from/to 为新版本的父版本及新版本，
+// 没有生成新版本时 revision 为当前 head，from/to 均为 head
+func (g *core) auditMigrate(start time.Time, revision Revision, err error) {
+	g.observeOperation(AuditMigrate, start, err)
+	from := revision.Revises()
+	if noNewRevision(err) {
+		from = revision.RevisionId
+	}
+	g.audit(AuditMigrate, start, from, revision.RevisionId, err)
+}
+
+// auditStatements 方言的审计表建表语句及查询语句
+func auditStatements(dialect Dialect) (ddl []string, query string) {
+	switch dialect.Name() {
+	case DialectPostgres:
+		return []string{createPostgresAuditDDL, createAuditIndexDDL}, selectPostgresAuditDML
+	case DialectSQLite:
+		return []string{createSQLiteAuditDDL, createAuditIndexDDL}, selectSQLiteAuditDML
+	}
+	return []string{createAuditDDL}, selectAuditDML
+}
+
+func (g *core) writeAudit(entry AuditEntry) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), auditTimeout)
+	defer cancel()
+	var db *sql.DB
+	if db, err = g.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var dialect Dialect
+	if dialect, err = g.dialect(); err != nil {
+		return
+	}
+	ddl, _ := auditStatements(dialect)
+	for _, stmt := range ddl {
+		if _, err = db.ExecContext(ctx, stmt); err != nil {
+			return connectionError(err)
+		}
+	}
+	_, err = db.ExecContext(ctx, rebind(dialect, insertAuditDML), entry.Operation, entry.FromRevision, entry.ToRevision, entry.CommitID,
+		entry.Hostname, entry.Operator, entry.StartedAt.UTC().Format(revisionCreateDateLayout), entry.Duration.Milliseconds(), entry.Outcome, entry.Error)
+	return
+}
+
+func (g *core) AuditLog(limit int) (entries []AuditEntry, err error) {
+	return g.AuditLogContext(context.Background(), limit)
+}
+
+// AuditLogContext 按时间倒序查询审计记录，limit 不大于0时返回全部，审计表不存在时返回空
+func (g *core) AuditLogContext(ctx context.Context, limit int) (entries []AuditEntry, err error) {
+	g.logger.Info("audit log...", stageField(StageAuditLog))
+	defer func() {
+		g.logger.InfoWithFlag(err, "audit log", stageField(StageAuditLog), Field{Key: "entries", Value: len(entries)})
+	}()
+	ctx, cancel, err := g.stageContext(ctx, StageAuditLog)
+	defer func() { err = g.stageError(ctx, StageAuditLog, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var db *sql.DB
+	if db, err = g.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	return g.auditLog(ctx, db, limit)
+}
+
+// auditLog 在 db 上查询审计记录
+func (g *core) auditLog(ctx context.Context, db *sql.DB, limit int) (entries []AuditEntry, err error) {
+	var dialect Dialect
+	if dialect, err = g.dialect(); err != nil {
+		return
+	}
+	var count int
+	if err = db.QueryRowContext(ctx, dialect.TableExistsQuery(), auditTable).Scan(&count); err != nil {
+		return nil, connectionError(err)
+	}
+	if count == 0 {
+		return
+	}
+	_, query := auditStatements(dialect)
+	if limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, query); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var (
+			e          AuditEntry
+			startedAt  string
+			durationMs int64
+		)
+		if err = rows.Scan(&e.ID, &e.Operation, &e.FromRevision, &e.ToRevision, &e.CommitID, &e.Hostname, &e.Operator,
+			&startedAt, &durationMs, &e.Outcome, &e.Error); err != nil {
+			return
+		}
+		// started_at 以 UTC 写入，格式化为字符串读取以不依赖 DSN 中的 parseTime 参数
+		if e.StartedAt, err = time.ParseInLocation(revisionCreateDateLayout, startedAt, time.UTC); err != nil {
+			return
+		}
+		e.Duration = time.Duration(durationMs) * time.Millisecond
+		entries = append(entries, e)
+	}
+	err = rows.Err()
+	return
+}
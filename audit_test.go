@@ -0,0 +1,86 @@
+package migration
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditStatements(t *testing.T) {
+	for _, tc := range []struct {
+		dialect   string
+		wantDDL   []string
+		wantQuery string
+	}{
+		{dialect: DialectMySQL, wantDDL: []string{"AUTO_INCREMENT"}, wantQuery: "DATE_FORMAT(started_at"},
+		{dialect: DialectPostgres, wantDDL: []string{"BIGSERIAL", "CREATE INDEX IF NOT EXISTS idx_started_at"}, wantQuery: "to_char(started_at"},
+		{dialect: DialectSQLite, wantDDL: []string{"AUTOINCREMENT", "CREATE INDEX IF NOT EXISTS idx_started_at"}, wantQuery: "\nstarted_at, duration_ms"},
+	} {
+		dialect, err := LookupDialect(tc.dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ddl, query := auditStatements(dialect)
+		if len(ddl) != len(tc.wantDDL) {
+			t.Fatalf("%s: %d DDL statements, want %d", tc.dialect, len(ddl), len(tc.wantDDL))
+		}
+		for i, want := range tc.wantDDL {
+			if !strings.Contains(ddl[i], want) {
+				t.Errorf("%s: DDL %q, want %q", tc.dialect, ddl[i], want)
+			}
+		}
+		if !strings.Contains(query, tc.wantQuery) || !strings.HasSuffix(query, "ORDER BY id DESC") {
+			t.Errorf("%s: query %q, want %q ordered by id", tc.dialect, query, tc.wantQuery)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	g := newCore(nil, WithScriptRoot(t.TempDir()), WithDsn("file:audit_log?mode=memory"), WithCommitID("c1"), WithOperator("ci"), WithLogHandler(&recordHandler{}))
+	defer func() { _ = g.Close() }()
+	// 审计表不存在时返回空
+	entries, err := g.AuditLog(0)
+	if err != nil || len(entries) != 0 {
+		t.Fatalf("AuditLog() = %v, %v, want empty", entries, err)
+	}
+	start := time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC)
+	g.audit(AuditUpgrade, start, "", "a", nil)
+	g.audit(AuditDowngrade, start.Add(time.Minute), "a", "", errors.New("boom"))
+	g.auditMigrate(start.Add(2*time.Minute), Revision{RevisionId: "b", Parents: []string{"a"}}, nil)
+	g.auditMigrate(start.Add(3*time.Minute), Revision{RevisionId: "b", Parents: []string{"a"}}, fmt.Errorf("migrate: %w", ErrNoSchemaChanges))
+
+	if entries, err = g.AuditLog(0); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("%s %s->%s %s %s", e.Operation, orBase(e.FromRevision), orBase(e.ToRevision), e.Outcome, e.Error))
+	}
+	want := []string{
+		"migrate b->b no_changes ",
+		"migrate a->b success ",
+		"downgrade a-><base> failure boom",
+		"upgrade <base>->a success ",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("AuditLog() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	last := entries[len(entries)-1]
+	if last.ID != 1 || last.CommitID != "c1" || last.Operator != "ci" || !last.StartedAt.Equal(start) || last.Duration < 0 {
+		t.Fatalf("entry %+v, want id 1 by ci at %s", last, start)
+	}
+	if entries, err = g.AuditLog(1); err != nil || len(entries) != 1 || entries[0].ID != 4 {
+		t.Fatalf("AuditLog(1) = %+v, %v, want the newest entry", entries, err)
+	}
+}
+
+func TestAuditDisabled(t *testing.T) {
+	g := newCore(nil, WithScriptRoot(t.TempDir()), WithDsn("file:audit_disabled?mode=memory"), WithAuditEnabled(false))
+	defer func() { _ = g.Close() }()
+	g.audit(AuditUpgrade, time.Now(), "", "a", nil)
+	if entries, err := g.AuditLog(0); err != nil || len(entries) != 0 {
+		t.Fatalf("AuditLog() = %v, %v, want no entries", entries, err)
+	}
+}
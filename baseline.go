@@ -0,0 +1,212 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// baselineMessage Baseline 生成的版本的 message
+const baselineMessage = "baseline"
+
+// autoIncrementOptionRegexp SHOW CREATE TABLE 输出中记录当前自增值的表选项，不属于表结构
+var autoIncrementOptionRegexp = regexp.MustCompile(`(?i)\s+AUTO_INCREMENT=\d+`)
+
+// alembicEmptyBodyRegexp flask db revision 生成的空 upgrade/downgrade 函数
+var alembicEmptyBodyRegexp = regexp.MustCompile(`(?m)^def (upgrade|downgrade)\(\):\n[ \t]+pass[ \t]*$`)
+
+// BaselineMismatchError Baseline 生成的版本推导出的表结构与数据库不一致，版本已被删除且数据库未被标记
+type BaselineMismatchError struct {
+	Revision string
+	Items    []DriftItem
+}
+
+func (e *BaselineMismatchError) Error() string {
+	items := make([]string, 0, len(e.Items))
+	for _, item := range e.Items {
+		items = append(items, item.String())
+	}
+	return fmt.Sprintf("baseline revision '%s' does not match the live schema: %s", e.Revision, strings.Join(items, "; "))
+}
+
+func (e *BaselineMismatchError) Is(target error) bool { return target == ErrBaselineMismatch }
+
+// revisionId 新版本的版本号，配置了 CommitID 时使用 CommitID
+func (g *core) revisionId() (string, error) {
+	if id := g.conf.GetCommitID(); id != "" {
+		return id, nil
+	}
+	return newRevisionId()
+}
+
+// resolveStampTarget 将 Stamp 的目标解析为具体的版本号，返回空表示 base，与 Upgrade/Downgrade 不同，不限制方向
+func resolveStampTarget(graph *RevisionGraph, target string) (string, error) {
+	switch target = strings.TrimSpace(target); target {
+	case "", TargetHead, TargetHeads:
+		return graph.Head()
+	case TargetBase:
+		return "", nil
+	}
+	return graph.Find(target)
+}
+
+// checkUnversioned Baseline 只能用于没有本地版本且数据库没有版本号的项目
+func checkUnversioned(graph *RevisionGraph, current string) error {
+	if graph.Len() > 0 {
+		return fmt.Errorf("%w: local revisions %v already exist", ErrAlreadyVersioned, graph.Revisions())
+	}
+	if current != "" {
+		return fmt.Errorf("%w: database is already stamped with revision '%s'", ErrAlreadyVersioned, current)
+	}
+	return nil
+}
+
+// baselineStatements 以 SHOW CREATE TABLE 导出数据库中的表，up 按外键依赖排序，被引用的表在前，down 为逆序的 DROP TABLE，
+// 迁移工具自身使用的表、视图、触发器及存储过程不包含在内，仅支持 MySQL，调用方需先以 requireMySQL 检查
+func (g *core) baselineStatements(ctx context.Context, db *sql.DB) (up, down []string, err error) {
+	var tables []string
+	if tables, err = baselineTables(ctx, db); err != nil {
+		return
+	}
+	for _, table := range tables {
+		var name, ddl string
+		if err = db.QueryRowContext(ctx, "SHOW CREATE TABLE "+quoteIdent(table)).Scan(&name, &ddl); err != nil {
+			return nil, nil, connectionError(err)
+		}
+		up = append(up, autoIncrementOptionRegexp.ReplaceAllString(ddl, ""))
+	}
+	for i := len(tables) - 1; i >= 0; i-- {
+		down = append(down, "DROP TABLE "+quoteIdent(tables[i]))
+	}
+	return
+}
+
+// baselineTables 当前库中的表，按外键依赖排序，循环依赖的表按表名排在最后
+func baselineTables(ctx context.Context, db *sql.DB) (tables []string, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE' ORDER BY TABLE_NAME"); err != nil {
+		return nil, connectionError(err)
+	}
+	var names []string
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return
+		}
+		if !isInternalTable(name) {
+			names = append(names, name)
+		}
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if rows, err = db.QueryContext(ctx, `SELECT TABLE_NAME, REFERENCED_TABLE_NAME FROM information_schema.REFERENTIAL_CONSTRAINTS
+WHERE CONSTRAINT_SCHEMA = DATABASE() AND UNIQUE_CONSTRAINT_SCHEMA = DATABASE()`); err != nil {
+		return nil, connectionError(err)
+	}
+	defer func() { _ = rows.Close() }()
+	references := make(map[string][]string)
+	for rows.Next() {
+		var table, referenced string
+		if err = rows.Scan(&table, &referenced); err != nil {
+			return
+		}
+		if table != referenced {
+			references[table] = append(references[table], referenced)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	return orderTables(names, references), nil
+}
+
+// orderTables 按依赖排序，references 中被依赖的表排在依赖它的表之前，同一层级按表名排序
+func orderTables(names []string, references map[string][]string) (ordered []string) {
+	pending := make(map[string]bool, len(names))
+	for _, name := range names {
+		pending[name] = true
+	}
+	remaining := append([]string(nil), names...)
+	sort.Strings(remaining)
+	for len(remaining) > 0 {
+		var next, ready []string
+		for _, name := range remaining {
+			blocked := false
+			for _, ref := range references[name] {
+				if pending[ref] {
+					blocked = true
+					break
+				}
+			}
+			if blocked {
+				next = append(next, name)
+			} else {
+				ready = append(ready, name)
+			}
+		}
+		if len(ready) == 0 {
+			// 循环依赖，剩余的表按表名排序，由 MySQL 在 CREATE TABLE 时报错
+			return append(ordered, next...)
+		}
+		for _, name := range ready {
+			delete(pending, name)
+		}
+		ordered = append(ordered, ready...)
+		remaining = next
+	}
+	return
+}
+
+// checkBaseline 以 statements 推导出的表结构与数据库对比，不一致时返回 *BaselineMismatchError
+func (g *core) checkBaseline(ctx context.Context, db *sql.DB, revision string, statements []string) (err error) {
+	var items []DriftItem
+	if items, err = g.drift(ctx, db, statements); err != nil {
+		return
+	}
+	if len(items) > 0 {
+		return &BaselineMismatchError{Revision: revision, Items: items}
+	}
+	return nil
+}
+
+// alembicExecuteBody 在 Alembic 版本中依次执行语句的函数体，语句以 sa.text 包裹，冒号需转义以免被识别为绑定参数
+func alembicExecuteBody(statements []string) string {
+	var b strings.Builder
+	for i, stmt := range statements {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		literal := quotePython(strings.ReplaceAll(stmt, ":", `\:`))
+		literal = strings.NewReplacer("\r", `\r`, "\n", `\n`).Replace(literal)
+		b.WriteString("    op.execute(sa.text(" + literal + "))")
+	}
+	return b.String()
+}
+
+// fillAlembicRevision 以 up/down 替换 flask db revision 生成的空 upgrade/downgrade 函数体
+func fillAlembicRevision(script string, up, down []string) (string, error) {
+	replaced := 0
+	script = alembicEmptyBodyRegexp.ReplaceAllStringFunc(script, func(s string) string {
+		replaced++
+		name := alembicEmptyBodyRegexp.FindStringSubmatch(s)[1]
+		statements := up
+		if name == "downgrade" {
+			statements = down
+		}
+		if len(statements) == 0 {
+			return s
+		}
+		return "def " + name + "():\n" + alembicExecuteBody(statements)
+	})
+	if replaced != 2 {
+		return "", fmt.Errorf("unexpected revision script, empty upgrade() and downgrade() not found")
+	}
+	return script, nil
+}
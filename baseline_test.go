@@ -0,0 +1,111 @@
+package migration
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOrderTables(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		names      []string
+		references map[string][]string
+		want       []string
+	}{
+		{name: "no references", names: []string{"c", "a", "b"}, want: []string{"a", "b", "c"}},
+		{name: "referenced first", names: []string{"a_orders", "b_users"}, references: map[string][]string{"a_orders": {"b_users"}}, want: []string{"b_users", "a_orders"}},
+		{
+			name:       "levels",
+			names:      []string{"items", "orders", "users", "products"},
+			references: map[string][]string{"items": {"orders", "products"}, "orders": {"users"}},
+			want:       []string{"products", "users", "orders", "items"},
+		},
+		{name: "self reference", names: []string{"nodes"}, references: map[string][]string{"nodes": {"nodes"}}, want: []string{"nodes"}},
+		{name: "external reference", names: []string{"a"}, references: map[string][]string{"a": {"other"}}, want: []string{"a"}},
+		{
+			name:       "cycle last",
+			names:      []string{"z", "b", "a"},
+			references: map[string][]string{"a": {"b"}, "b": {"a"}},
+			want:       []string{"z", "a", "b"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := orderTables(tc.names, tc.references); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("orderTables() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+const alembicEmptyRevision = `"""baseline
+
+Revision ID: abc
+Revises: 
+Create Date: 2023-01-02 03:04:05.000006
+
+"""
+from alembic import op
+import sqlalchemy as sa
+
+
+# revision identifiers, used by Alembic.
+revision = 'abc'
+down_revision = None
+branch_labels = None
+depends_on = None
+
+
+def upgrade():
+    pass
+
+
+def downgrade():
+    pass
+`
+
+func TestFillAlembicRevision(t *testing.T) {
+	up := []string{"CREATE TABLE `a` (\n  `id` int NOT NULL\n)", "CREATE TABLE `b` (`note` varchar(8) DEFAULT 'x:y')"}
+	down := []string{"DROP TABLE `b`", "DROP TABLE `a`"}
+	got, err := fillAlembicRevision(alembicEmptyRevision, up, down)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(strings.Replace(alembicEmptyRevision,
+		"def upgrade():\n    pass\n",
+		"def upgrade():\n"+
+			"    op.execute(sa.text('CREATE TABLE `a` (\\n  `id` int NOT NULL\\n)'))\n"+
+			"    op.execute(sa.text('CREATE TABLE `b` (`note` varchar(8) DEFAULT \\'x\\\\:y\\')'))\n", 1),
+		"def downgrade():\n    pass\n",
+		"def downgrade():\n"+
+			"    op.execute(sa.text('DROP TABLE `b`'))\n"+
+			"    op.execute(sa.text('DROP TABLE `a`'))\n", 1)
+	if got != want {
+		t.Fatalf("fillAlembicRevision() =\n%s\nwant\n%s", got, want)
+	}
+
+	// 没有表时保留 pass
+	if got, err = fillAlembicRevision(alembicEmptyRevision, nil, nil); err != nil || got != alembicEmptyRevision {
+		t.Fatalf("fillAlembicRevision(nil) = %q, %v, want the script unchanged", got, err)
+	}
+	// 已填写过的版本不再替换
+	if _, err = fillAlembicRevision(want, up, down); err == nil {
+		t.Fatal("fillAlembicRevision() on a filled script = nil, want error")
+	}
+}
+
+func TestBaselineUnsupportedDialect(t *testing.T) {
+	root := t.TempDir()
+	m := New(log.New(io.Discard, "", 0), WithBackend(BackendNative), WithScriptRoot(root), WithDsn("file:baseline?mode=memory"), WithLockEnabled(false), WithAuditEnabled(false))
+	defer func() { _ = m.Close() }()
+	if _, err := m.Baseline(); !errors.Is(err, ErrUnsupportedDialect) {
+		t.Fatalf("Baseline() = %v, want %v", err, ErrUnsupportedDialect)
+	}
+	if entries, _ := os.ReadDir(root); len(entries) != 0 {
+		t.Fatalf("Baseline() left %d entries in the script root, want none", len(entries))
+	}
+}
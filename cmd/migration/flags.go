@@ -0,0 +1,158 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const envPrefix = "MIGRATION_"
+
+// fieldValue 将配置结构体的字段包装为 flag.Value
+type fieldValue struct {
+	v reflect.Value
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func (f fieldValue) String() string {
+	if !f.v.IsValid() {
+		return ""
+	}
+	switch f.v.Kind() {
+	case reflect.Map:
+		var kvs []string
+		for _, k := range f.v.MapKeys() {
+			kvs = append(kvs, fmt.Sprintf("%v=%v", k.Interface(), f.v.MapIndex(k).Interface()))
+		}
+		sort.Strings(kvs)
+		return strings.Join(kvs, ",")
+	case reflect.Slice:
+		var items []string
+		for i := 0; i < f.v.Len(); i++ {
+			items = append(items, fmt.Sprint(f.v.Index(i).Interface()))
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(f.v.Interface())
+}
+
+func (f fieldValue) Set(s string) error {
+	return setValue(f.v, s)
+}
+
+// IsBoolFlag 使 bool 字段支持 --flag 形式
+func (f fieldValue) IsBoolFlag() bool { return f.v.Kind() == reflect.Bool }
+
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int64, reflect.Int32:
+		i, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		out := reflect.MakeSlice(v.Type(), 0, 0)
+		for _, item := range splitList(s) {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(elem, item); err != nil {
+				return err
+			}
+			out = reflect.Append(out, elem)
+		}
+		v.Set(out)
+	case reflect.Map:
+		out := reflect.MakeMap(v.Type())
+		for _, item := range splitList(s) {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				return fmt.Errorf("invalid map item '%s', expect key=value", item)
+			}
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := setValue(key, strings.TrimSpace(kv[0])); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := setValue(value, strings.TrimSpace(kv[1])); err != nil {
+				return err
+			}
+			out.SetMapIndex(key, value)
+		}
+		v.Set(out)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func splitList(s string) (out []string) {
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return
+}
+
+func supported(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.String, reflect.Bool, reflect.Int, reflect.Int64, reflect.Int32, reflect.Float64:
+		return true
+	case reflect.Slice:
+		return supported(t.Elem())
+	case reflect.Map:
+		return supported(t.Key()) && supported(t.Elem())
+	}
+	return false
+}
+
+// bindStruct 将 optiongen 生成的配置结构体字段注册为命令行参数，参数名为 xconf 标签，
+// 同名的大写环境变量(MIGRATION_ 前缀)作为默认值，接口、函数等无法以文本表示的字段会被忽略
+func bindStruct(fs *flag.FlagSet, ptr interface{}) error {
+	v := reflect.ValueOf(ptr).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("xconf")
+		if name == "" || name == "-" || !supported(field.Type) {
+			continue
+		}
+		env := envPrefix + strings.ToUpper(name)
+		value := fieldValue{v: v.Field(i)}
+		if s, ok := os.LookupEnv(env); ok {
+			if err := value.Set(s); err != nil {
+				return fmt.Errorf("invalid env %s: %w", env, err)
+			}
+		}
+		fs.Var(value, name, fmt.Sprintf("%s (env %s)", field.Tag.Get("usage"), env))
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConf struct {
+	Name     string            `xconf:"name" usage:"name"`
+	Enabled  bool              `xconf:"enabled"`
+	Count    int               `xconf:"count"`
+	Ratio    float64           `xconf:"ratio"`
+	Timeout  time.Duration     `xconf:"timeout"`
+	Items    []string          `xconf:"items"`
+	Labels   map[string]string `xconf:"labels"`
+	Handler  func()            `xconf:"handler"`
+	Internal string            `xconf:"-"`
+	Untagged string
+}
+
+func TestBindStruct(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		want    testConf
+		wantErr string
+	}{
+		{name: "flags", args: []string{"--name=app", "--enabled", "--count=3", "--ratio=0.5", "--timeout=1m", "--items=a, b,", "--labels=k1=v1,k2 = v2"},
+			want: testConf{Name: "app", Enabled: true, Count: 3, Ratio: 0.5, Timeout: time.Minute, Items: []string{"a", "b"}, Labels: map[string]string{"k1": "v1", "k2": "v2"}}},
+		{name: "env", env: map[string]string{"MIGRATION_NAME": "app", "MIGRATION_ENABLED": "true", "MIGRATION_ITEMS": "a,b"},
+			want: testConf{Name: "app", Enabled: true, Items: []string{"a", "b"}}},
+		{name: "flag overrides env", env: map[string]string{"MIGRATION_NAME": "env", "MIGRATION_COUNT": "1"}, args: []string{"--name=flag"},
+			want: testConf{Name: "flag", Count: 1}},
+		{name: "bool flag false", env: map[string]string{"MIGRATION_ENABLED": "true"}, args: []string{"--enabled=false"}},
+		{name: "invalid env", env: map[string]string{"MIGRATION_COUNT": "many"}, wantErr: "invalid env MIGRATION_COUNT"},
+		{name: "invalid map env", env: map[string]string{"MIGRATION_LABELS": "k1"}, wantErr: "invalid map item 'k1'"},
+		{name: "invalid duration flag", args: []string{"--timeout=soon"}, wantErr: "invalid value"},
+		{name: "unbound fields", args: []string{"--handler=x"}, wantErr: "flag provided but not defined: -handler"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			var c testConf
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+			err := bindStruct(fs, &c)
+			if err == nil {
+				err = fs.Parse(tc.args)
+			}
+			switch {
+			case tc.wantErr != "":
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("bind and parse = %v, want %q", err, tc.wantErr)
+				}
+			case err != nil:
+				t.Fatalf("bind and parse = %v", err)
+			case !reflect.DeepEqual(c, tc.want):
+				t.Fatalf("conf %+v, want %+v", c, tc.want)
+			}
+		})
+	}
+}
+
+func TestFieldValueString(t *testing.T) {
+	c := testConf{Items: []string{"a", "b"}, Labels: map[string]string{"k2": "v2", "k1": "v1"}, Timeout: time.Second}
+	v := reflect.ValueOf(&c).Elem()
+	for field, want := range map[string]string{"Items": "a,b", "Labels": "k1=v1,k2=v2", "Timeout": "1s"} {
+		if got := (fieldValue{v: v.FieldByName(field)}).String(); got != want {
+			t.Errorf("%s String() = %q, want %q", field, got, want)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+// migration 命令行工具，子命令与 migration.Migration 接口一一对应
+//
+// 用法:
+//
+//	migration [flags] <command> [args]
+//
+// 所有 Conf/GenerateConf 中可以文本表示的配置项均可通过同名参数(如 --script_root)
+// 或 MIGRATION_ 前缀的大写环境变量(如 MIGRATION_SCRIPT_ROOT)设置，
+// 内置 mysql、postgres(lib/pq) 及 sqlite(modernc.org/sqlite) 驱动
+//
+// 退出码:
+//
+//	0 成功
+//	1 执行失败
+//	2 参数错误
+//	3 没有检测到 schema 变更
+//	4 数据库版本不是最新
+//	5 破坏性 DDL 被 ddl_policy 拒绝执行
+//	6 数据库表结构与 head 版本不一致(drift)
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	_ "github.com/lib/pq"
+	"github.com/sandwich-go/migration"
+	"io"
+	"log"
+	_ "modernc.org/sqlite"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitUsage       = 2
+	exitNoChanges   = 3
+	exitNotUpToDate = 4
+	exitDestructive = 5
+	exitDrift       = 6
+)
+
+const commandsUsage = `Commands:
+  generate              generate migration script and initialize migration support
+  migrate [-message m]  create database if not exists and generate a new revision
+  upgrade [target]      upgrade the database to target (default head)
+  downgrade [target]    downgrade the database to target (default -1)
+  show [revision]       show the local revision (default head)
+  current [-check]      show the database revision, -check exits 4 if it is not head
+  history               show the list of revisions
+  ddl [-file f] [-latest]
+                        show the SQL of the upgrade without executing it
+  drift                 compare the database schema with the head revision, exits 6 on drift
+  plan [-markdown]      show the pending revisions with their SQL and risk, -markdown renders it for PR comments
+  audit [-limit n]      show the audit log of migrate/upgrade/downgrade, newest first
+  status [-check]       compare the local head with the database revision, -check exits 4 if it is not up to date
+  stamp <revision>      set the database revision without running migrations, revision can be head or base
+  baseline              generate an initial revision from the live schema and stamp the database, exits 6 on mismatch,
+                        mysql only
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// withConf 以命令行解析得到的配置整体替换默认配置
+func withConf(c *migration.Conf) migration.ConfOption {
+	return func(cc *migration.Conf) migration.ConfOption {
+		*cc = *c
+		return nil
+	}
+}
+
+func withGenerateConf(c *migration.GenerateConf) migration.GenerateConfOption {
+	return func(cc *migration.GenerateConf) migration.GenerateConfOption {
+		*cc = *c
+		return nil
+	}
+}
+
+// run 执行命令行并返回退出码，opts 在命令行配置之后应用，用于设置 Executor 等无法以文本表示的配置
+func run(args []string, stdout, stderr io.Writer, opts ...migration.ConfOption) int {
+	conf := migration.NewConf()
+	generateConf := migration.NewGenerateConf()
+	fs := flag.NewFlagSet("migration", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	output := fs.String("output", envOr("MIGRATION_OUTPUT", "human"), "output format, human or json (env MIGRATION_OUTPUT)")
+	quiet := fs.Bool("quiet", false, "discard migration logs")
+	logFormat := fs.String("log_format", envOr("MIGRATION_LOG_FORMAT", "pretty"), "log format, pretty or json (env MIGRATION_LOG_FORMAT)")
+	fs.Usage = func() {
+		_, _ = fmt.Fprintf(stderr, "Usage: migration [flags] <command> [args]\n\n%s\nFlags:\n", commandsUsage)
+		fs.PrintDefaults()
+	}
+	for _, ptr := range []interface{}{conf, generateConf} {
+		if err := bindStruct(fs, ptr); err != nil {
+			_, _ = fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return exitUsage
+	}
+	if *output != "human" && *output != "json" {
+		_, _ = fmt.Fprintf(stderr, "invalid output format '%s'\n", *output)
+		return exitUsage
+	}
+	if *logFormat != "pretty" && *logFormat != "json" {
+		_, _ = fmt.Fprintf(stderr, "invalid log format '%s'\n", *logFormat)
+		return exitUsage
+	}
+
+	var logWriter io.Writer = stderr
+	if *quiet {
+		logWriter = io.Discard
+	}
+	if *logFormat == "json" {
+		conf.LogHandler = migration.NewJSONHandler(logWriter, migration.LevelInfo)
+	}
+	m := migration.New(log.New(logWriter, "", log.LstdFlags), append([]migration.ConfOption{withConf(conf)}, opts...)...)
+	p := &printer{json: *output == "json", stdout: stdout, stderr: stderr}
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	c := &command{m: m, conf: conf, generateConf: generateConf, p: p}
+	return c.run(ctx, fs.Arg(0), fs.Args()[1:])
+}
+
+type command struct {
+	m            migration.Migration
+	conf         *migration.Conf
+	generateConf *migration.GenerateConf
+	p            *printer
+}
+
+func (c *command) run(ctx context.Context, name string, args []string) int {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.p.stderr)
+	var (
+		message  = fs.String("message", "", "revision message")
+		check    = fs.Bool("check", false, "exit 4 if the database is not at head")
+		file     = fs.String("file", "", "write the SQL to the file, relative to script_root")
+		latest   = fs.Bool("latest", false, "only the revisions not yet applied to the database")
+		markdown = fs.Bool("markdown", false, "render the plan as Markdown")
+		limit    = fs.Int("limit", 20, "max number of audit entries, 0 for all")
+	)
+	if err := fs.Parse(args); err != nil {
+		return exitUsage
+	}
+	arg := func(def string) string {
+		if fs.NArg() > 0 {
+			return fs.Arg(0)
+		}
+		return def
+	}
+
+	switch name {
+	case "generate":
+		if err := c.m.GenerateContext(ctx, withGenerateConf(c.generateConf)); err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result("generated", map[string]interface{}{"generated": true})
+	case "migrate":
+		return c.migrate(ctx, *message)
+	case "upgrade":
+		target := arg(migration.TargetHead)
+		if err := c.m.UpgradeToContext(ctx, target); err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result("upgraded to "+target, map[string]interface{}{"target": target})
+	case "downgrade":
+		target := arg("-1")
+		if err := c.m.DowngradeToContext(ctx, target); err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result("downgraded to "+target, map[string]interface{}{"target": target})
+	case "show":
+		revision, err := c.m.ShowLocalRevisionContext(ctx, arg(""))
+		if err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result(humanRevision(revision), revision)
+	case "current":
+		revision, err := c.m.ShowDatabaseRevisionContext(ctx)
+		if err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result(humanRevision(revision), revision)
+		if *check {
+			return c.checkHead(ctx, revision)
+		}
+	case "history":
+		revisions, err := c.m.HistoryContext(ctx)
+		if err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result(humanHistory(revisions), revisions)
+	case "ddl":
+		ddl, err := c.m.ShowDDLContext(ctx, *file, *latest)
+		if err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result(ddl, map[string]interface{}{"ddl": ddl})
+	case "drift":
+		report, err := c.m.DriftContext(ctx)
+		if err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result(report.String(), report)
+		if report.HasDrift() {
+			return exitDrift
+		}
+	case "plan":
+		plan, err := c.m.PlanContext(ctx)
+		if err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		human := plan.Text()
+		if *markdown {
+			human = plan.Markdown()
+		}
+		c.p.result(human, plan)
+	case "audit":
+		entries, err := c.m.AuditLogContext(ctx, *limit)
+		if err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		var b strings.Builder
+		for _, e := range entries {
+			b.WriteString(e.String() + "\n")
+		}
+		c.p.result(b.String(), entries)
+	case "status":
+		status, err := c.m.StatusContext(ctx)
+		if err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result(status.Table(), status)
+		if *check && !status.UpToDate() {
+			return exitNotUpToDate
+		}
+	case "stamp":
+		if fs.NArg() != 1 {
+			_, _ = fmt.Fprintf(c.p.stderr, "stamp requires a revision\n\n%s", commandsUsage)
+			return exitUsage
+		}
+		target := fs.Arg(0)
+		if err := c.m.StampContext(ctx, target); err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result("stamped "+target, map[string]interface{}{"target": target})
+	case "baseline":
+		revision, err := c.m.BaselineContext(ctx)
+		if err != nil {
+			return c.p.failure(err, exitCode(err))
+		}
+		c.p.result(humanRevision(revision), revision)
+	default:
+		_, _ = fmt.Fprintf(c.p.stderr, "unknown command '%s'\n\n%s", name, commandsUsage)
+		return exitUsage
+	}
+	return exitOK
+}
+
+func (c *command) migrate(ctx context.Context, message string) int {
+	revision, err := c.m.MigrateContext(ctx, message)
+	if err != nil && exitCode(err) == exitFailure {
+		return c.p.failure(err, exitCode(err))
+	}
+	c.p.result(humanRevision(revision), revision)
+	if err != nil {
+		_, _ = fmt.Fprintln(c.p.stderr, "warning:", err)
+	}
+	return exitCode(err)
+}
+
+func (c *command) checkHead(ctx context.Context, current migration.Revision) int {
+	head, err := c.m.ShowLocalRevisionContext(ctx, "")
+	if err != nil {
+		return c.p.failure(err, exitCode(err))
+	}
+	if head.RevisionId != current.RevisionId {
+		return exitNotUpToDate
+	}
+	return exitOK
+}
+
+type printer struct {
+	json   bool
+	stdout io.Writer
+	stderr io.Writer
+}
+
+func (p *printer) result(human string, v interface{}) {
+	if p.json {
+		enc := json.NewEncoder(p.stdout)
+		enc.SetIndent("", "  ")
+		_ = enc.Encode(v)
+		return
+	}
+	_, _ = fmt.Fprintln(p.stdout, strings.TrimRight(human, "\n"))
+}
+
+// exitCode 依据错误类型返回退出码
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, migration.ErrNoSchemaChanges):
+		return exitNoChanges
+	case errors.Is(err, migration.ErrDatabaseNotUpToDate):
+		return exitNotUpToDate
+	case errors.Is(err, migration.ErrDestructiveDDL):
+		return exitDestructive
+	case errors.Is(err, migration.ErrBaselineMismatch):
+		return exitDrift
+	}
+	return exitFailure
+}
+
+func (p *printer) failure(err error, code int) int {
+	if p.json {
+		_ = json.NewEncoder(p.stderr).Encode(map[string]interface{}{"error": err.Error(), "exit_code": code})
+	} else {
+		_, _ = fmt.Fprintln(p.stderr, "error:", err)
+	}
+	return code
+}
+
+func humanRevision(r migration.Revision) string {
+	if r.RevisionId == "" {
+		return "(no revision)"
+	}
+	return strings.TrimSuffix(r.LogEntry(), "\n")
+}
+
+func humanHistory(revisions []migration.Revision) string {
+	var b strings.Builder
+	for _, r := range revisions {
+		parent := r.Revises()
+		if parent == "" {
+			parent = "<base>"
+		}
+		head := ""
+		if r.IsHead {
+			head = " (head)"
+		}
+		_, _ = fmt.Fprintf(&b, "%s -> %s%s, %s\n", parent, r.RevisionId, head, r.Message)
+	}
+	return b.String()
+}
+
+func envOr(key, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
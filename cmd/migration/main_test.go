@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sandwich-go/migration"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const (
+	historyOutput = "Rev: b (head)\nParent: a\nPath: b_.py\n\n    b\n\n" +
+		"Rev: a\nParent: <base>\nPath: a_.py\n\n    a\n"
+	headOutput  = "Rev: b (head)\nParent: a\nPath: b_.py\n\n    b\n"
+	aheadOutput = "Rev: a\nParent: <base>\nPath: a_.py\n\n    a\n"
+	// upgradeSQL flask db upgrade --sql a:b 的输出，包含破坏性 DDL
+	upgradeSQL = "-- Running upgrade a -> b\n\nDROP TABLE legacy;\n\n" +
+		"UPDATE alembic_version SET version_num='b' WHERE alembic_version.version_num = 'a';\n\n"
+)
+
+// testReplay 本地版本为 a <- b，数据库版本为 current
+func testReplay(current string) *migration.ReplayExecutor {
+	return migration.NewReplayExecutor().
+		On("db init", migration.Reply{}).
+		On("db history --verbose", migration.Reply{Stdout: historyOutput}).
+		On("db show", migration.Reply{Stdout: headOutput}).
+		On("db current --verbose", migration.Reply{Stdout: current}).
+		On("db upgrade --sql", migration.Reply{Stdout: upgradeSQL}).
+		On("db upgrade", migration.Reply{}).
+		On("db migrate", migration.Reply{Stderr: "INFO  [alembic.env] " + migration.SchemaNoChanges + "."})
+}
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		args       []string
+		env        map[string]string
+		replay     *migration.ReplayExecutor
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "history", args: []string{"history"}, replay: testReplay(headOutput), wantStdout: "a -> b (head)"},
+		{name: "show json", args: []string{"--output=json", "show"}, replay: testReplay(headOutput), wantStdout: `"RevisionId": "b"`},
+		{name: "output from env", args: []string{"show"}, env: map[string]string{"MIGRATION_OUTPUT": "json"}, replay: testReplay(headOutput), wantStdout: `"RevisionId": "b"`},
+		{name: "current check at head", args: []string{"current", "-check"}, replay: testReplay(headOutput)},
+		{name: "upgrade", args: []string{"upgrade"}, replay: testReplay(aheadOutput), wantStdout: "upgraded to head"},
+		{name: "command failure", args: []string{"history"}, wantCode: exitFailure, wantStderr: "error:",
+			replay: migration.NewReplayExecutor().On("db init", migration.Reply{}).On("db history", migration.Reply{Stderr: "Traceback (most recent call last):", ExitCode: 1})},
+		{name: "no command", wantCode: exitUsage, wantStderr: "Usage: migration"},
+		{name: "unknown command", args: []string{"unknown"}, wantCode: exitUsage, wantStderr: "unknown command 'unknown'"},
+		{name: "unknown flag", args: []string{"--unknown", "history"}, wantCode: exitUsage},
+		{name: "invalid output", args: []string{"--output=xml", "history"}, wantCode: exitUsage, wantStderr: "invalid output format"},
+		{name: "invalid env", args: []string{"history"}, env: map[string]string{"MIGRATION_LOCK_ENABLED": "maybe"}, wantCode: exitUsage, wantStderr: "invalid env MIGRATION_LOCK_ENABLED"},
+		{name: "stamp without revision", args: []string{"stamp"}, wantCode: exitUsage, wantStderr: "stamp requires a revision"},
+		{name: "migrate without changes", args: []string{"migrate", "-message", "add users"}, replay: testReplay(headOutput), wantCode: exitNoChanges, wantStdout: "Rev: b"},
+		{name: "current check behind", args: []string{"current", "-check"}, replay: testReplay(aheadOutput), wantCode: exitNotUpToDate},
+		{name: "status check behind", args: []string{"status", "-check"}, replay: testReplay(aheadOutput), wantCode: exitNotUpToDate, wantStdout: "behind"},
+		{name: "destructive ddl", args: []string{"--ddl_policy=block", "upgrade"}, replay: testReplay(aheadOutput), wantCode: exitDestructive, wantStderr: "drop_table legacy"},
+		{name: "ddl policy from env", args: []string{"upgrade"}, env: map[string]string{"MIGRATION_DDL_POLICY": "block"}, replay: testReplay(aheadOutput), wantCode: exitDestructive},
+		{name: "flag overrides env", args: []string{"--ddl_policy=warn", "upgrade"}, env: map[string]string{"MIGRATION_DDL_POLICY": "block"}, replay: testReplay(aheadOutput)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			root := t.TempDir()
+			// migrations 目录由 flask db init 创建
+			if err := os.Mkdir(filepath.Join(root, "migrations"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			var opts []migration.ConfOption
+			if tc.replay != nil {
+				opts = append(opts, migration.WithExecutor(tc.replay))
+			}
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"--quiet", "--script_root=" + root, "--audit_enabled=false"}, tc.args...)
+			if code := run(args, &stdout, &stderr, opts...); code != tc.wantCode {
+				t.Fatalf("run(%q) = %d, want %d, stderr: %s", args, code, tc.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tc.wantStdout) {
+				t.Fatalf("stdout %q, want %q", stdout.String(), tc.wantStdout)
+			}
+			if !strings.Contains(stderr.String(), tc.wantStderr) {
+				t.Fatalf("stderr %q, want %q", stderr.String(), tc.wantStderr)
+			}
+		})
+	}
+}
+
+func TestRunJSONFailure(t *testing.T) {
+	replay := migration.NewReplayExecutor().On("db init", migration.Reply{Stderr: "Traceback (most recent call last):", ExitCode: 1})
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"--quiet", "--output=json", "--script_root=" + t.TempDir(), "history"}, &stdout, &stderr, migration.WithExecutor(replay)); code != exitFailure {
+		t.Fatalf("run() = %d, want %d", code, exitFailure)
+	}
+	var failure struct {
+		Error    string `json:"error"`
+		ExitCode int    `json:"exit_code"`
+	}
+	if err := json.Unmarshal(stderr.Bytes(), &failure); err != nil || failure.ExitCode != exitFailure || failure.Error == "" {
+		t.Fatalf("stderr %q, want a JSON error with exit code %d", stderr.String(), exitFailure)
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want int
+	}{
+		{err: nil, want: exitOK},
+		{err: errors.New("boom"), want: exitFailure},
+		{err: fmt.Errorf("migrate: %w", migration.ErrNoSchemaChanges), want: exitNoChanges},
+		{err: fmt.Errorf("migrate: %w", migration.ErrDatabaseNotUpToDate), want: exitNotUpToDate},
+		{err: &migration.DestructiveDDLError{Policy: migration.DDLPolicyBlock}, want: exitDestructive},
+		{err: fmt.Errorf("baseline: %w", migration.ErrBaselineMismatch), want: exitDrift},
+	} {
+		if got := exitCode(tc.err); got != tc.want {
+			t.Errorf("exitCode(%v) = %d, want %d", tc.err, got, tc.want)
+		}
+	}
+}
@@ -1,23 +1,69 @@
 package migration
 
+import "time"
+
 //go:generate optiongen --option_with_struct_name=false --new_func=NewConf --xconf=true --empty_composite_nil=true --usage_tag_name=usage
 func ConfOptionDeclareWithDefault() interface{} {
 	return map[string]interface{}{
-		"FileName":   "migration", // @MethodComment(migration 脚本名)
-		"ScriptRoot": ".",         // @MethodComment(migration 脚本根路径)
-		"CommitID":   "",          // @MethodComment(repo commitID)
+		"FileName":             "migration",                     // @MethodComment(migration 脚本名)
+		"ScriptRoot":           ".",                             // @MethodComment(migration 脚本根路径)
+		"CommitID":             "",                              // @MethodComment(repo commitID)
+		"Timeout":              time.Duration(0),                // @MethodComment(每个阶段的默认超时时间，0表示不超时)
+		"StageTimeouts":        (map[string]time.Duration)(nil), // @MethodComment(按阶段设置的超时时间，key为阶段名，优先于Timeout)
+		"Backend":              BackendAlembic,                  // @MethodComment(迁移后端，可选 alembic(默认，依赖 flask db) 或 native(纯 Go 实现))
+		"Dsn":                  "",                              // @MethodComment(数据库连接串，支持 go-sql-driver DSN 或 SQLAlchemy URL，Go 侧连接数据库(创建库、迁移锁、审计等)时需配置 Database 或 Dsn)
+		"Executor":             Executor(NewLocalExecutor()),    // @MethodComment(命令执行器，默认在本地进程中执行)
+		"LockEnabled":          true,                            // @MethodComment(是否在 Migrate/Upgrade/Downgrade 期间持有迁移锁，避免多个副本并发执行，未配置 Locker 且没有数据库连接配置时告警并跳过)
+		"Locker":               Locker(nil),                     // @MethodComment(迁移锁实现，为 nil 时使用方言默认的实现(MySQL GET_LOCK、PostgreSQL advisory lock、SQLite 锁文件))
+		"LockName":             "",                              // @MethodComment(迁移锁名，为空时使用 migration:<库名>)
+		"LockWaitTimeout":      time.Minute,                     // @MethodComment(获取迁移锁的最长等待时间)
+		"DDLPolicy":            DDLPolicyWarn,                   // @MethodComment(破坏性 DDL 的处理策略，可选 off、warn(默认，仅告警)、block(拒绝执行)、allowlist(仅允许 DDLAllowRevisions 中的版本))
+		"DDLAllowRevisions":    ([]string)(nil),                 // @MethodComment(allowlist 策略下允许包含破坏性 DDL 的版本号)
+		"DDLLargeTableRows":    int64(1000000),                  // @MethodComment(行数不小于该值的表视为大表，在大表上创建索引视为破坏性 DDL，不大于0时不检查)
+		"Database":             (*DSN)(nil),                     // @MethodComment(结构化的数据库连接配置，优先于 Dsn，同时用于 Go 侧连接及生成 migration 脚本中的 SQLALCHEMY_DATABASE_URI)
+		"DataMigrations":       (*DataMigrations)(nil),          // @MethodComment(数据迁移注册表，为 nil 时使用 DefaultDataMigrations)
+		"OSCTableRows":         int64(0),                        // @MethodComment(行数不小于该值的表上的 ALTER TABLE 在 Upgrade 时以在线表结构变更(影子表+触发器+分批复制+RENAME)执行，不大于0时不按行数选择)
+		"OSCFilter":            OSCFilter(nil),                  // @MethodComment(按语句选择是否以在线表结构变更执行 ALTER TABLE，非 nil 时优先于 OSCTableRows)
+		"OSCChunkSize":         1000,                            // @MethodComment(在线表结构变更每批复制的行数)
+		"OSCMaxReplicationLag": time.Second * 10,                // @MethodComment(在线表结构变更时 OSCReplicas 中任一从库的复制延迟超过该值则暂停复制，不大于0时不检查)
+		"OSCReplicas":          ([]*DSN)(nil),                   // @MethodComment(在线表结构变更时检查复制延迟的从库)
+		"OSCProgress":          OSCProgressFunc(nil),            // @MethodComment(在线表结构变更的进度回调，为 nil 时每完成 10% 输出一次日志)
+		"AuditEnabled":         true,                            // @MethodComment(是否将 Migrate/Upgrade/Downgrade 的执行记录写入 migration_audit_log 表)
+		"Operator":             "",                              // @MethodComment(审计记录中的操作人，为空时使用当前系统用户)
+		"LogHandler":           Handler(nil),                    // @MethodComment(日志处理器，为空时以 New 传入的 log.Logger 输出默认格式的日志，可使用 NewJSONHandler、NewSlogHandler)
+		"Hooks":                []Hook(nil),                     // @MethodComment(迁移事件的 Hook，按顺序同步调用，可在阶段或版本开始时否决执行)
+		"RewriteScript":        false,                           // @MethodComment(配置了 Database/Dsn 时，是否在 prepare 时为不支持 MIGRATION_DATABASE_URI 环境变量覆盖的旧 migration 脚本加上覆盖，关闭时旧脚本需先执行 Generate 重新生成)
+		"Metrics":              (*Metrics)(nil),                 // @MethodComment(Prometheus 指标，非 nil 时 Migrate/Upgrade/Downgrade/Stamp 直接更新其中的指标，database 标签为 MetricsDatabase)
+		"MetricsDatabase":      "",                              // @MethodComment(指标的 database 标签，为空时使用 Database/Dsn 中的库名)
 	}
 }
 
 //go:generate optiongen --option_with_struct_name=false --new_func=NewGenerateConf --xconf=true --empty_composite_nil=true --usage_tag_name=usage
 func GenerateConfOptionDeclareWithDefault() interface{} {
 	return map[string]interface{}{
-		"MysqlDbName":           "migration", // @MethodComment(migration db名)
-		"MysqlUser":             "root",      // @MethodComment(migration 数据库用户名)
-		"MysqlPassword":         "",          // @MethodComment(migration 数据库用户密码)
-		"MysqlHost":             "127.0.0.1", // @MethodComment(migration 数据库地址)
-		"MysqlPort":             3306,        // @MethodComment(migration 数据库端口号)
-		"ProtokitGoSettingPath": "",          // @MethodComment(protokitgo 配置文件路径)
-		"ProtokitPath":          "",          // @MethodComment(protokitgo 路径)
+		"MysqlDbName":           "",      // @MethodComment(Deprecated: 使用 DbName，DbName 为空时生效)
+		"MysqlUser":             "",      // @MethodComment(Deprecated: 使用 DbUser，DbUser 为空时生效)
+		"MysqlPassword":         "",      // @MethodComment(Deprecated: 使用 DbPassword，DbPassword 为空时生效)
+		"MysqlHost":             "",      // @MethodComment(Deprecated: 使用 DbHost，DbHost 为空时生效)
+		"MysqlPort":             0,       // @MethodComment(Deprecated: 使用 DbPort，DbPort 为 0 时生效)
+		"ProtokitGoSettingPath": "",      // @MethodComment(protokitgo 配置文件路径)
+		"ProtokitPath":          "",      // @MethodComment(protokitgo 路径)
+		"Dialect":               "mysql", // @MethodComment(数据库方言，可选 mysql(默认)、postgres、sqlite，Db* 配置用于所有方言)
+		"DbName":                "",      // @MethodComment(数据库名，sqlite 下为库文件路径，为空时使用 MysqlDbName，均为空时为 migration)
+		"DbUser":                "",      // @MethodComment(数据库用户名，为空时使用 MysqlUser，均为空时为 root)
+		"DbPassword":            "",      // @MethodComment(数据库用户密码，为空时使用 MysqlPassword)
+		"DbHost":                "",      // @MethodComment(数据库地址，为空时使用 MysqlHost，均为空时为 127.0.0.1)
+		"DbPort":                0,       // @MethodComment(数据库端口号，为 0 时使用 MysqlPort，均为 0 时使用方言的默认端口)
+	}
+}
+
+//go:generate optiongen --option_with_struct_name=false --new_func=NewFleetConf --xconf=true --empty_composite_nil=true --usage_tag_name=usage
+func FleetConfOptionDeclareWithDefault() interface{} {
+	return map[string]interface{}{
+		"Targets":       ([]FleetTarget)(nil),   // @MethodComment(目标数据库列表)
+		"Discover":      FleetDiscoverFunc(nil), // @MethodComment(目标数据库发现函数，非 nil 时与 Targets 合并)
+		"Concurrency":   4,                      // @MethodComment(同时执行的目标数据库数量上限，不大于0时为1)
+		"FailurePolicy": FleetContinue,          // @MethodComment(目标数据库执行失败时的处理策略，可选 continue(默认，继续执行其他目标) 或 stop(不再开始新的目标))
+		"SharedOptions": ([]ConfOption)(nil),    // @MethodComment(所有目标共用的 Conf 配置，目标自身的配置优先)
 	}
 }
@@ -14,7 +14,33 @@ const (
 	migrationsAlreadyDone      = "No handlers could be found for logger"
 	dbNotUpToDate              = "Target database is not up to date"
 	SchemaNoChanges            = "No changes in schema detected"
-	mysqlDSNPrefix             = "mysql://"
 	updateAlembicVersionPrefix = "UPDATE alembic_version"
 	insertAlembicVersionPrefix = "INSERT INTO alembic_version"
 )
+
+// 迁移后端
+const (
+	// BackendAlembic 通过 flask db 命令调用 Flask-Migrate(Alembic) 执行迁移
+	BackendAlembic = "alembic"
+	// BackendNative 纯 Go 实现，直接执行 migrations/versions 下的 .sql 版本文件，与 alembic_version 表兼容
+	BackendNative = "native"
+)
+
+const (
+	migrationsVersionsDir    = "migrations/versions"
+	nativeUpSuffix           = ".up.sql"
+	nativeDownSuffix         = ".down.sql"
+	alembicScriptSuffix      = ".py"
+	alembicPackageInit       = "__init__.py"
+	alembicVersionTable      = "alembic_version"
+	revisionCreateDateLayout = "2006-01-02 15:04:05.999999"
+	createAlembicVersionDDL  = `CREATE TABLE alembic_version (
+    version_num VARCHAR(32) NOT NULL, 
+    CONSTRAINT alembic_version_pkc PRIMARY KEY (version_num)
+)`
+	insertAlembicVersionDDL = "INSERT INTO alembic_version (version_num) VALUES ('%s')"
+	updateAlembicVersionDDL = "UPDATE alembic_version SET version_num='%s' WHERE alembic_version.version_num = '%s'"
+	deleteAlembicVersionDDL = "DELETE FROM alembic_version WHERE alembic_version.version_num = '%s'"
+	runningUpgradeComment   = "-- Running upgrade %s -> %s"
+	runningDowngradeComment = "-- Running downgrade %s -> %s"
+)
@@ -0,0 +1,251 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/sandwich-go/boost/xos"
+	"github.com/sandwich-go/boost/xpanic"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// core 各迁移后端共享的配置、日志、命令执行及数据库连接能力
+type core struct {
+	logger *Logger
+	conf   ConfInterface
+}
+
+func newCore(logger *log.Logger, opts ...ConfOption) *core {
+	g := &core{logger: NewLogger(logger), conf: NewConf(opts...)}
+	if h := g.conf.GetLogHandler(); h != nil {
+		g.logger = NewLoggerWithHandler(h)
+	}
+	// 配置了 Hook 或 Metrics 时告警日志同时作为 EventWarning 通知 Hook 并计入告警数
+	if len(g.conf.GetHooks()) > 0 || g.conf.GetMetrics() != nil {
+		g.logger = NewLoggerWithHandler(&hookHandler{Handler: g.logger.Handler(), g: g})
+	}
+	return g
+}
+
+// migrationBuildDir ScriptRoot 的绝对路径，flask 命令的工作目录及所有脚本文件路径均基于该目录
+func (g *core) migrationBuildDir() (migrationBuildDir string) {
+	root := g.conf.GetScriptRoot()
+	if abs, err := filepath.Abs(root); err == nil {
+		return abs
+	}
+	return root
+}
+
+func (g *core) Command(env string, name string, arg ...string) (output []byte, err error) {
+	return g.CommandContext(context.Background(), env, name, arg...)
+}
+
+func (g *core) CommandContext(ctx context.Context, env string, name string, arg ...string) (output []byte, err error) {
+	var envs []string
+	if env != "" {
+		envs = append(envs, env)
+	}
+	output, _, err = g.exec(ctx, "", envs, name, arg...)
+	return
+}
+
+// exec 通过 Executor 在 dir 中执行命令，dir 为空时使用当前进程的工作目录，失败时返回 *CommandError
+func (g *core) exec(ctx context.Context, dir string, env []string, name string, arg ...string) (stdout []byte, stderr []byte, err error) {
+	cmd := Cmd{Name: name, Args: arg, Env: env, Dir: dir}
+	start := time.Now()
+	xpanic.Try(func() {
+		stdout, stderr, err = g.conf.GetExecutor().Execute(ctx, cmd)
+	}).Catch(func(e xpanic.E) {
+		err = fmt.Errorf("panic as error:%v", e)
+	})
+	fields := []Field{stageField(contextStage(ctx)), {Key: FieldCommand, Value: cmd.String()}, {Key: FieldDuration, Value: time.Since(start)}}
+	if err != nil {
+		ce := newCommandError(cmd, stdout, stderr, err)
+		g.logger.Log(LevelDebug, "exec command", append(fields, Field{Key: FieldExitCode, Value: ce.ExitCode}, Field{Key: FieldError, Value: ce.Err})...)
+		return stdout, stderr, ce
+	}
+	g.logger.Log(LevelDebug, "exec command", append(fields, Field{Key: FieldExitCode, Value: 0})...)
+	return
+}
+
+// databaseURIEnv 覆盖 migration 脚本中 SQLALCHEMY_DATABASE_URI 的环境变量，flask 命令执行时由 Conf 中的 Database/Dsn 设置
+const databaseURIEnv = "MIGRATION_DATABASE_URI"
+
+// databaseURIRegexp 匹配 migration 脚本中的 app.config['SQLALCHEMY_DATABASE_URI'] = os.environ.get('MIGRATION_DATABASE_URI') or '...'，
+// 兼容没有环境变量覆盖的旧脚本
+var databaseURIRegexp = regexp.MustCompile(`(app\.config\[['"]SQLALCHEMY_DATABASE_URI['"]\]\s*=\s*)(os\.environ\.get\(['"]` + databaseURIEnv +
+	`['"]\)\s*or\s*)?(?:'((?:[^'\\]|\\.)*)'|"((?:[^"\\]|\\.)*)")`)
+
+// importRegexp 匹配 Python 脚本中的第一条 import 语句
+var importRegexp = regexp.MustCompile(`(?m)^(?:import|from)\s`)
+
+// importOSRegexp 匹配 Python 脚本中的 import os
+var importOSRegexp = regexp.MustCompile(`(?m)^import\s+os\s*$`)
+
+// scriptFile migration 脚本路径，FileName 没有 .py 后缀时兼容带后缀的文件
+func (g *core) scriptFile() string {
+	file := filepath.Join(g.migrationBuildDir(), g.conf.GetFileName())
+	if !xos.ExistsFile(file) && !strings.HasSuffix(file, ".py") && xos.ExistsFile(file+".py") {
+		return file + ".py"
+	}
+	return file
+}
+
+// writeDatabaseURI 将 migration 脚本中的 SQLALCHEMY_DATABASE_URI 替换为 dsn 对应的 SQLAlchemy URL
+func (g *core) writeDatabaseURI(dsn *DSN) (err error) {
+	file := g.scriptFile()
+	defer func() {
+		g.logger.InfoWithFlag(err, "write SQLALCHEMY_DATABASE_URI to migration python script", stageField(StageGenerate), Field{Key: "file", Value: file}, Field{Key: "dsn", Value: dsn})
+	}()
+	var uri string
+	if uri, err = dsn.scriptURL(); err != nil {
+		return
+	}
+	return g.rewriteDatabaseURI(file, func([]byte) string { return quotePython(uri) })
+}
+
+// hookDatabaseURI 检查 migration 脚本是否支持 MIGRATION_DATABASE_URI 环境变量覆盖，不支持时只有开启了 RewriteScript 才会改写脚本，
+// 保留原有的 SQLALCHEMY_DATABASE_URI，否则返回错误，以免 flask 命令与 Go 侧连接不同的数据库
+func (g *core) hookDatabaseURI() (err error) {
+	file := g.scriptFile()
+	var content []byte
+	if content, err = xos.FileGetContents(file); err != nil {
+		return
+	}
+	if m := databaseURIRegexp.FindSubmatch(content); m == nil || len(m[2]) > 0 {
+		return
+	}
+	if !g.conf.GetRewriteScript() {
+		return fmt.Errorf("migration python script '%s' does not read SQLALCHEMY_DATABASE_URI from %s, "+
+			"regenerate it with Generate or enable RewriteScript", file, databaseURIEnv)
+	}
+	defer func() {
+		g.logger.InfoWithFlag(err, "hook SQLALCHEMY_DATABASE_URI in migration python script", stageField(StagePrepare), Field{Key: "file", Value: file}, Field{Key: "env", Value: databaseURIEnv})
+	}()
+	return g.rewriteDatabaseURI(file, func(literal []byte) string { return string(literal) })
+}
+
+// scriptMu 串行化 migration 脚本的改写，多个 Migration 可能共用同一个 ScriptRoot
+var scriptMu sync.Mutex
+
+// rewriteDatabaseURI 重写 migration 脚本中的 SQLALCHEMY_DATABASE_URI，literal 为原有的字符串字面量，返回新的字符串字面量
+func (g *core) rewriteDatabaseURI(file string, literal func([]byte) string) (err error) {
+	scriptMu.Lock()
+	defer scriptMu.Unlock()
+	var content []byte
+	if content, err = xos.FileGetContents(file); err != nil {
+		return
+	}
+	if !databaseURIRegexp.Match(content) {
+		return fmt.Errorf("invalid migration file, not found 'SQLALCHEMY_DATABASE_URI' in '%s'", file)
+	}
+	replaced := databaseURIRegexp.ReplaceAllFunc(content, func(b []byte) []byte {
+		m := databaseURIRegexp.FindSubmatch(b)
+		old := b[len(m[1])+len(m[2]):]
+		return []byte(fmt.Sprintf("%sos.environ.get(%s) or %s", m[1], quotePython(databaseURIEnv), literal(old)))
+	})
+	if !importOSRegexp.Match(replaced) {
+		i := 0
+		if loc := importRegexp.FindIndex(replaced); loc != nil {
+			i = loc[0]
+		}
+		replaced = append(append(append([]byte(nil), replaced[:i]...), "import os\n"...), replaced[i:]...)
+	}
+	return xos.FilePutContents(file, replaced)
+}
+
+// quotePython 以单引号包裹的 Python 字符串字面量
+func quotePython(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+func unquotePython(s string) string {
+	return strings.NewReplacer(`\\`, `\`, `\'`, `'`, `\"`, `"`).Replace(s)
+}
+
+func (g *core) createDatabaseIfNotExists(ctx context.Context) (err error) {
+	g.logger.Info("create database if not exists...", stageField(StageCreateDatabase))
+	var dbName string
+	defer func() {
+		g.logger.InfoWithFlag(err, "create database if not exists", stageField(StageCreateDatabase), Field{Key: "db_name", Value: dbName})
+	}()
+	ctx, cancel, err := g.stageContext(ctx, StageCreateDatabase)
+	defer func() { err = g.stageError(ctx, StageCreateDatabase, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var database *DSN
+	if database, err = g.database(); err != nil {
+		// 没有数据库连接配置时由 migration 脚本自行连接，库需已存在
+		if errors.Is(err, ErrNoDatabase) {
+			g.logger.WarnContext(ctx, "skip create database", stageField(StageCreateDatabase), Field{Key: FieldError, Value: err})
+			err = nil
+		}
+		return
+	}
+	dbName = database.DBName
+	var dialect Dialect
+	if dialect, err = database.dialect(); err != nil {
+		return
+	}
+	return dialect.CreateDatabase(ctx, database)
+}
+
+// Close 关闭 sqlite 内存库保持的连接，其他数据库没有需要释放的资源
+func (g *core) Close() (err error) {
+	database, err := g.database()
+	if err != nil || !database.sqliteMemory() {
+		return nil
+	}
+	return releaseSQLiteMemory(database)
+}
+
+// database 数据库连接配置，优先使用配置中的 Database，其次是 Dsn，均未配置时为 migration 脚本中 SQLALCHEMY_DATABASE_URI 的字面量，
+// 都没有时返回 ErrNoDatabase
+func (g *core) database() (*DSN, error) {
+	if database := g.conf.GetDatabase(); database != nil {
+		return database, nil
+	}
+	if dsn := g.conf.GetDsn(); dsn != "" {
+		return ParseDSN(dsn)
+	}
+	return g.scriptDatabase()
+}
+
+// scriptDatabase 解析 migration 脚本中 SQLALCHEMY_DATABASE_URI 的字面量(环境变量覆盖时为其默认值)，
+// 脚本不存在或以其他方式(如 app.config.from_object)配置连接时返回 ErrNoDatabase
+func (g *core) scriptDatabase() (*DSN, error) {
+	file := g.scriptFile()
+	if !xos.ExistsFile(file) {
+		return nil, fmt.Errorf("%w, set Database or Dsn", ErrNoDatabase)
+	}
+	content, err := xos.FileGetContents(file)
+	if err != nil {
+		return nil, err
+	}
+	m := databaseURIRegexp.FindSubmatch(content)
+	if m == nil {
+		return nil, fmt.Errorf("%w, set Database or Dsn, SQLALCHEMY_DATABASE_URI literal not found in '%s'", ErrNoDatabase, file)
+	}
+	return ParseSQLAlchemyURL(unquotePython(string(m[3]) + string(m[4])))
+}
+
+// openDatabase 以方言的驱动打开 DSN 指向的数据库
+func (g *core) openDatabase() (db *sql.DB, err error) {
+	var database *DSN
+	if database, err = g.database(); err != nil {
+		return
+	}
+	var dialect Dialect
+	if dialect, err = database.dialect(); err != nil {
+		return
+	}
+	return openDSN(dialect, database, true)
+}
@@ -0,0 +1,108 @@
+package migration
+
+import (
+	"errors"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestHookDatabaseURI(t *testing.T) {
+	const legacy = "from flask import Flask\napp = Flask(__name__)\napp.config['SQLALCHEMY_DATABASE_URI'] = 'mysql://root@127.0.0.1:3306/app'\n"
+	const hooked = "import os\nfrom flask import Flask\napp = Flask(__name__)\n" +
+		"app.config['SQLALCHEMY_DATABASE_URI'] = os.environ.get('MIGRATION_DATABASE_URI') or 'mysql://root@127.0.0.1:3306/app'\n"
+	for _, tc := range []struct {
+		name    string
+		script  string
+		rewrite bool
+		want    string
+		wantErr bool
+	}{
+		{name: "legacy", script: legacy, wantErr: true, want: legacy},
+		{name: "legacy rewrite", script: legacy, rewrite: true, want: hooked},
+		{name: "hooked", script: hooked, want: hooked},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newCore(log.New(io.Discard, "", 0), WithScriptRoot(t.TempDir()), WithDsn("root@tcp(127.0.0.1:3306)/app"), WithRewriteScript(tc.rewrite))
+			if err := os.WriteFile(g.scriptFile(), []byte(tc.script), 0644); err != nil {
+				t.Fatal(err)
+			}
+			err := g.hookDatabaseURI()
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("hookDatabaseURI() = %v, want error %v", err, tc.wantErr)
+			}
+			content, err := os.ReadFile(g.scriptFile())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(content) != tc.want {
+				t.Fatalf("script = %q, want %q", content, tc.want)
+			}
+		})
+	}
+}
+
+func TestWriteDatabaseURI(t *testing.T) {
+	g := newCore(log.New(io.Discard, "", 0), WithScriptRoot(t.TempDir()))
+	script := "app.config[\"SQLALCHEMY_DATABASE_URI\"] = \"mysql://old@localhost/old\"\n"
+	if err := os.WriteFile(g.scriptFile(), []byte(script), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := &DSN{Host: "db", Port: 3306, User: "u", Password: "it's", DBName: "app"}
+	if err := g.writeDatabaseURI(d); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(g.scriptFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := databaseURIRegexp.FindStringSubmatch(string(content))
+	if m == nil || m[2] == "" || !strings.HasPrefix(string(content), "import os\n") {
+		t.Fatalf("script not hooked: %q", content)
+	}
+	parsed, err := ParseSQLAlchemyURL(unquotePython(m[3] + m[4]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Password != d.Password || parsed.Addr() != d.Addr() || parsed.DBName != d.DBName {
+		t.Fatalf("SQLALCHEMY_DATABASE_URI = %+v, want %+v", parsed, d)
+	}
+}
+
+func TestScriptDatabase(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		script  string
+		want    string
+		wantErr error
+	}{
+		{name: "literal", script: "app.config['SQLALCHEMY_DATABASE_URI'] = 'mysql://root:pw@db:3307/app'\n", want: "db:3307/app"},
+		{name: "env default", script: "app.config['SQLALCHEMY_DATABASE_URI'] = os.environ.get('MIGRATION_DATABASE_URI') or \"mysql://root@db/app\"\n", want: "db:3306/app"},
+		{name: "from object", script: "app.config.from_object(Config)\n", wantErr: ErrNoDatabase},
+		{name: "missing script", wantErr: ErrNoDatabase},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newCore(log.New(io.Discard, "", 0), WithScriptRoot(t.TempDir()))
+			if tc.script != "" {
+				if err := os.WriteFile(g.scriptFile(), []byte(tc.script), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			d, err := g.database()
+			if tc.wantErr != nil {
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("database() = %v, want %v", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := d.Addr() + "/" + d.DBName; got != tc.want {
+				t.Fatalf("database() = %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,198 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync"
+)
+
+const (
+	dataMigrationTable = "migration_data_versions"
+	// createDataMigrationDDL 数据迁移执行记录表，(revision, name) 唯一
+	createDataMigrationDDL = `CREATE TABLE IF NOT EXISTS migration_data_versions (
+    revision VARCHAR(64) NOT NULL,
+    name VARCHAR(191) NOT NULL,
+    applied_at DATETIME NOT NULL,
+    PRIMARY KEY (revision, name)
+)`
+	// createPostgresDataMigrationDDL PostgreSQL 没有 DATETIME 类型
+	createPostgresDataMigrationDDL = `CREATE TABLE IF NOT EXISTS migration_data_versions (
+    revision VARCHAR(64) NOT NULL,
+    name VARCHAR(191) NOT NULL,
+    applied_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (revision, name)
+)`
+	insertDataMigrationDML = "INSERT INTO migration_data_versions (revision, name, applied_at) VALUES (?, ?, CURRENT_TIMESTAMP)"
+	deleteDataMigrationDML = "DELETE FROM migration_data_versions WHERE revision = ? AND name = ?"
+	selectDataMigrationDML = "SELECT COUNT(*) FROM migration_data_versions WHERE revision = ? AND name = ?"
+)
+
+// DataMigrationFunc 数据迁移函数，在独立的事务中执行，返回错误时事务回滚
+type DataMigrationFunc func(ctx context.Context, tx *sql.Tx) error
+
+// DataMigration 绑定到版本上的数据迁移，Upgrade 在该版本的 DDL 执行之后调用 Up，Downgrade 在回退该版本的 DDL 之前调用 Down
+type DataMigration struct {
+	// Revision 版本号(即生成版本时使用的 CommitID)
+	Revision string
+	// Name 同一版本下的数据迁移名，同一版本的多个数据迁移按注册顺序执行
+	Name string
+	Up   DataMigrationFunc
+	// Down 可选，为 nil 时 Downgrade 只删除执行记录
+	Down DataMigrationFunc
+}
+
+// DataMigrations 数据迁移注册表
+type DataMigrations struct {
+	mu         sync.RWMutex
+	migrations map[string][]DataMigration
+}
+
+// DefaultDataMigrations 默认的数据迁移注册表，Conf 中没有设置 DataMigrations 时使用
+var DefaultDataMigrations = NewDataMigrations()
+
+// NewDataMigrations 创建数据迁移注册表
+func NewDataMigrations() *DataMigrations {
+	return &DataMigrations{migrations: make(map[string][]DataMigration)}
+}
+
+// Register 注册数据迁移，Revision、Name、Up 不能为空，同一版本下 Name 不能重复
+func (d *DataMigrations) Register(m DataMigration) error {
+	if m.Revision == "" || m.Name == "" {
+		return fmt.Errorf("data migration revision and name are required")
+	}
+	if m.Up == nil {
+		return fmt.Errorf("data migration '%s' of revision '%s' has no up function", m.Name, m.Revision)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, exists := range d.migrations[m.Revision] {
+		if exists.Name == m.Name {
+			return fmt.Errorf("data migration '%s' of revision '%s' is already registered", m.Name, m.Revision)
+		}
+	}
+	d.migrations[m.Revision] = append(d.migrations[m.Revision], m)
+	return nil
+}
+
+// Revision 版本下注册的数据迁移，按注册顺序返回
+func (d *DataMigrations) Revision(revision string) []DataMigration {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return append([]DataMigration(nil), d.migrations[revision]...)
+}
+
+// Has 版本列表中是否有注册了数据迁移的版本
+func (d *DataMigrations) Has(revisions ...string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, revision := range revisions {
+		if len(d.migrations[revision]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterDataMigration 向 DefaultDataMigrations 注册数据迁移，通常在 init 中调用，注册失败时 panic
+func RegisterDataMigration(revision, name string, up, down DataMigrationFunc) {
+	if err := DefaultDataMigrations.Register(DataMigration{Revision: revision, Name: name, Up: up, Down: down}); err != nil {
+		panic(err)
+	}
+}
+
+// dataMigrations 当前使用的数据迁移注册表
+func (g *core) dataMigrations() *DataMigrations {
+	if d := g.conf.GetDataMigrations(); d != nil {
+		return d
+	}
+	return DefaultDataMigrations
+}
+
+// applyDataMigrations 执行版本下尚未执行的数据迁移，每个数据迁移与其执行记录在同一事务中提交
+func (g *core) applyDataMigrations(ctx context.Context, db *sql.DB, revision string) (err error) {
+	migrations := g.dataMigrations().Revision(revision)
+	if len(migrations) == 0 {
+		return
+	}
+	if err = g.createDataMigrationTable(ctx, db); err != nil {
+		return
+	}
+	for _, m := range migrations {
+		if err = g.runDataMigration(ctx, db, m, true); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// revertDataMigrations 按注册的逆序回退版本下已执行的数据迁移
+func (g *core) revertDataMigrations(ctx context.Context, db *sql.DB, revision string) (err error) {
+	migrations := g.dataMigrations().Revision(revision)
+	if len(migrations) == 0 {
+		return
+	}
+	if err = g.createDataMigrationTable(ctx, db); err != nil {
+		return
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		if err = g.runDataMigration(ctx, db, migrations[i], false); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// createDataMigrationTable 创建数据迁移执行记录表
+func (g *core) createDataMigrationTable(ctx context.Context, db *sql.DB) error {
+	dialect, err := g.dialect()
+	if err != nil {
+		return err
+	}
+	ddl := createDataMigrationDDL
+	if dialect.Name() == DialectPostgres {
+		ddl = createPostgresDataMigrationDDL
+	}
+	_, err = db.ExecContext(ctx, ddl)
+	return err
+}
+
+func (g *core) runDataMigration(ctx context.Context, db *sql.DB, m DataMigration, up bool) (err error) {
+	direction, fn, record := "up", m.Up, insertDataMigrationDML
+	if !up {
+		direction, fn, record = "down", m.Down, deleteDataMigrationDML
+	}
+	var dialect Dialect
+	if dialect, err = g.dialect(); err != nil {
+		return
+	}
+	var tx *sql.Tx
+	if tx, err = db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	var count int
+	if err = tx.QueryRowContext(ctx, rebind(dialect, selectDataMigrationDML), m.Revision, m.Name).Scan(&count); err != nil {
+		return
+	}
+	if applied := count > 0; applied == up {
+		return tx.Rollback()
+	}
+	g.logger.Info("data migration "+direction+"...", stageField(contextStage(ctx)), revisionField(m.Revision), Field{Key: "name", Value: m.Name})
+	defer func() {
+		g.logger.InfoWithFlag(err, "data migration "+direction, stageField(contextStage(ctx)), revisionField(m.Revision), Field{Key: "name", Value: m.Name})
+	}()
+	if fn == nil {
+		g.logger.WarnContext(ctx, "data migration has no down function, only the record is removed", stageField(contextStage(ctx)), revisionField(m.Revision), Field{Key: "name", Value: m.Name})
+	} else if err = fn(ctx, tx); err != nil {
+		return fmt.Errorf("data migration '%s' %s of revision '%s' failed: %w", m.Name, direction, m.Revision, err)
+	}
+	if _, err = tx.ExecContext(ctx, rebind(dialect, record), m.Revision, m.Name); err != nil {
+		return
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,118 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// execItem 返回在 items 表中插入或删除 id 的数据迁移函数，calls 记录调用顺序
+func execItem(calls *[]string, name, query string, id int) DataMigrationFunc {
+	return func(ctx context.Context, tx *sql.Tx) error {
+		*calls = append(*calls, name)
+		_, err := tx.ExecContext(ctx, query, id)
+		return err
+	}
+}
+
+func queryColumn(t *testing.T, db *sql.DB, query string) (values []string) {
+	t.Helper()
+	rows, err := db.Query(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var v string
+		if err = rows.Scan(&v); err != nil {
+			t.Fatal(err)
+		}
+		values = append(values, v)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestDataMigrations(t *testing.T) {
+	errBroken := errors.New("broken")
+	var calls []string
+	d := NewDataMigrations()
+	for _, m := range []DataMigration{
+		{Revision: "r1", Name: "seed", Up: execItem(&calls, "seed up", "INSERT INTO items (id) VALUES (?)", 1), Down: execItem(&calls, "seed down", "DELETE FROM items WHERE id = ?", 1)},
+		{Revision: "r1", Name: "fill", Up: execItem(&calls, "fill up", "INSERT INTO items (id) VALUES (?)", 2)},
+		{Revision: "r2", Name: "broken", Up: func(ctx context.Context, tx *sql.Tx) error {
+			calls = append(calls, "broken up")
+			if _, err := tx.ExecContext(ctx, "INSERT INTO items (id) VALUES (3)"); err != nil {
+				return err
+			}
+			return errBroken
+		}},
+	} {
+		if err := d.Register(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Register(DataMigration{Revision: "r1", Name: "seed", Up: execItem(&calls, "", "", 0)}); err == nil {
+		t.Fatal("Register() of a duplicate name = nil, want error")
+	}
+	if !d.Has("r0", "r2") || d.Has("r0") {
+		t.Fatal("Has() mismatch")
+	}
+
+	h := &recordHandler{}
+	g := newCore(nil, WithScriptRoot(t.TempDir()), WithDsn("file:data_migrations?mode=memory"), WithDataMigrations(d), WithLogHandler(h))
+	defer func() { _ = g.Close() }()
+	db, err := g.openDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = db.Close() }()
+	if _, err = db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY)"); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	assert := func(step string, wantCalls, wantItems, wantRecords []string) {
+		t.Helper()
+		items := queryColumn(t, db, "SELECT id FROM items ORDER BY id")
+		records := queryColumn(t, db, "SELECT revision || '/' || name FROM migration_data_versions ORDER BY revision, name")
+		if !reflect.DeepEqual(calls, wantCalls) || !reflect.DeepEqual(items, wantItems) || !reflect.DeepEqual(records, wantRecords) {
+			t.Fatalf("%s: calls %v, items %v, records %v, want %v, %v, %v", step, calls, items, records, wantCalls, wantItems, wantRecords)
+		}
+		calls = nil
+	}
+
+	if err = g.applyDataMigrations(ctx, db, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	assert("apply", []string{"seed up", "fill up"}, []string{"1", "2"}, []string{"r1/fill", "r1/seed"})
+	// 已执行的数据迁移不会重复执行
+	if err = g.applyDataMigrations(ctx, db, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	assert("apply again", nil, []string{"1", "2"}, []string{"r1/fill", "r1/seed"})
+	// 失败时数据变更与执行记录一起回滚
+	if err = g.applyDataMigrations(ctx, db, "r2"); !errors.Is(err, errBroken) {
+		t.Fatalf("applyDataMigrations(r2) = %v, want %v", err, errBroken)
+	}
+	assert("apply broken", []string{"broken up"}, []string{"1", "2"}, []string{"r1/fill", "r1/seed"})
+	// 按注册的逆序回退，没有 Down 的数据迁移只删除执行记录
+	if err = g.revertDataMigrations(ctx, db, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	assert("revert", []string{"seed down"}, []string{"2"}, nil)
+	warned := false
+	for _, r := range h.records {
+		warned = warned || r.Level == LevelWarn && r.field("name") == "fill"
+	}
+	if !warned {
+		t.Fatalf("records %+v, want a warning for fill without down", h.records)
+	}
+	if err = g.revertDataMigrations(ctx, db, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	assert("revert again", nil, []string{"2"}, nil)
+}
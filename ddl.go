@@ -0,0 +1,745 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 破坏性 DDL 的处理策略，见 WithDDLPolicy
+const (
+	// DDLPolicyOff 不分析 DDL
+	DDLPolicyOff = "off"
+	// DDLPolicyWarn 仅输出告警日志
+	DDLPolicyWarn = "warn"
+	// DDLPolicyBlock 存在破坏性 DDL 时拒绝执行
+	DDLPolicyBlock = "block"
+	// DDLPolicyAllowList 仅允许执行 DDLAllowRevisions 中的版本所包含的破坏性 DDL
+	DDLPolicyAllowList = "allowlist"
+)
+
+// DDLKind 破坏性 DDL 的类型
+type DDLKind string
+
+const (
+	// DDLDropTable 删除表
+	DDLDropTable DDLKind = "drop_table"
+	// DDLDropColumn 删除列
+	DDLDropColumn DDLKind = "drop_column"
+	// DDLNarrowingType 列类型收窄，可能截断已有数据
+	DDLNarrowingType DDLKind = "narrowing_type_change"
+	// DDLNotNullWithoutDefault 新增或修改为 NOT NULL 且没有默认值的列
+	DDLNotNullWithoutDefault DDLKind = "not_null_without_default"
+	// DDLIndexOnLargeTable 在大表上创建索引
+	DDLIndexOnLargeTable DDLKind = "index_rebuild_on_large_table"
+)
+
+// DDLFinding 一条被识别为破坏性的 DDL 语句
+type DDLFinding struct {
+	// Revision 语句所属的版本号，离线 SQL 中没有版本标记时为空
+	Revision  string  `json:"revision,omitempty"`
+	Kind      DDLKind `json:"kind"`
+	Table     string  `json:"table"`
+	Column    string  `json:"column,omitempty"`
+	Detail    string  `json:"detail,omitempty"`
+	Statement string  `json:"statement"`
+}
+
+func (f DDLFinding) String() string {
+	target := f.Table
+	if f.Column != "" {
+		target += "." + f.Column
+	}
+	s := fmt.Sprintf("%s %s", f.Kind, target)
+	if f.Detail != "" {
+		s += " (" + f.Detail + ")"
+	}
+	if f.Revision != "" {
+		s += " in revision " + f.Revision
+	}
+	return s
+}
+
+// DestructiveDDLError 破坏性 DDL 被 DDLPolicy 拒绝执行
+type DestructiveDDLError struct {
+	Policy   string
+	Findings []DDLFinding
+}
+
+func (e *DestructiveDDLError) Error() string {
+	items := make([]string, 0, len(e.Findings))
+	for _, f := range e.Findings {
+		items = append(items, f.String())
+	}
+	return fmt.Sprintf("destructive DDL rejected by policy '%s': %s", e.Policy, strings.Join(items, "; "))
+}
+
+func (e *DestructiveDDLError) Is(target error) bool { return target == ErrDestructiveDDL }
+
+// TableStats 数据库中已有表的统计信息
+type TableStats struct {
+	Rows    int64
+	Columns map[string]ColumnStats
+}
+
+// ColumnStats 数据库中已有列的信息
+type ColumnStats struct {
+	// Type 与 information_schema.COLUMNS.COLUMN_TYPE 格式一致，如 varchar(32)、int(11) unsigned
+	Type     string
+	Nullable bool
+}
+
+// DDLAnalyzer 识别 DDL 中的破坏性语句
+// 分析时会依据 CREATE TABLE/ALTER TABLE 语句更新表结构，因此同一份 SQL 中先建表后修改的情况也能识别类型收窄
+type DDLAnalyzer struct {
+	// Tables 数据库中已有的表，key 为表名，用于判断类型收窄、NOT NULL 及大表，为空时仅依据 SQL 本身判断
+	Tables map[string]TableStats
+	// LargeTableRows 行数不小于该值的表视为大表，不大于0时不检查
+	LargeTableRows int64
+	// Dialect SQL 的方言，决定 Analyze 拆分语句时的引号及注释规则，为空时为 MySQL
+	Dialect string
+}
+
+// ddlColumn 分析过程中的列定义
+type ddlColumn struct {
+	typ          columnType
+	notNull      bool
+	hasDefault   bool
+	defaultValue string
+	auto         bool
+	primary      bool
+	unique       bool
+}
+
+type ddlTable struct {
+	rows    int64
+	columns map[string]*ddlColumn
+}
+
+// Analyze 分析 `flask db upgrade --sql` 格式的离线 SQL，依据 `-- Running upgrade` 标记确定语句所属版本
+func (a *DDLAnalyzer) Analyze(ddl string) []DDLFinding {
+	preamble, steps := parseOfflineSQL(a.Dialect, ddl)
+	if len(preamble) > 0 {
+		steps = append([]offlineStep{{Upgrade: true, Statements: preamble}}, steps...)
+	}
+	return a.analyze(steps)
+}
+
+func (a *DDLAnalyzer) analyze(steps []offlineStep) (findings []DDLFinding) {
+	tables := make(map[string]*ddlTable, len(a.Tables))
+	for name, stats := range a.Tables {
+		t := &ddlTable{rows: stats.Rows, columns: make(map[string]*ddlColumn, len(stats.Columns))}
+		for column, c := range stats.Columns {
+			t.columns[strings.ToLower(column)] = &ddlColumn{typ: parseColumnType(newDDLTokens(c.Type)), notNull: !c.Nullable}
+		}
+		tables[strings.ToLower(name)] = t
+	}
+	for _, step := range steps {
+		revision := step.To
+		if !step.Upgrade {
+			revision = step.From
+		}
+		for _, stmt := range step.Statements {
+			for _, f := range a.statement(tables, stmt) {
+				f.Revision = revision
+				f.Statement = stmt
+				findings = append(findings, f)
+			}
+		}
+	}
+	return
+}
+
+func (a *DDLAnalyzer) large(t *ddlTable) bool {
+	return a.LargeTableRows > 0 && t != nil && t.rows >= a.LargeTableRows
+}
+
+// statement 分析单条语句，无法识别的语句视为安全
+func (a *DDLAnalyzer) statement(tables map[string]*ddlTable, stmt string) (findings []DDLFinding) {
+	ts := newDDLTokens(stmt)
+	switch {
+	case ts.accept("CREATE", "TABLE"):
+		ts.accept("IF", "NOT", "EXISTS")
+		name := ts.name()
+		t := &ddlTable{columns: make(map[string]*ddlColumn)}
+		if ts.accept("(") {
+			for _, def := range ts.list() {
+				if column, c := def.columnDefinition(); c != nil {
+					t.columns[column] = c
+				}
+			}
+		}
+		tables[name] = t
+	case ts.accept("CREATE"):
+		ts.acceptAny("UNIQUE", "FULLTEXT", "SPATIAL")
+		if !ts.accept("INDEX") {
+			return
+		}
+		index := ts.name()
+		if !ts.accept("ON") {
+			return
+		}
+		name := ts.name()
+		if a.large(tables[name]) {
+			findings = append(findings, DDLFinding{Kind: DDLIndexOnLargeTable, Table: name,
+				Detail: fmt.Sprintf("create index %s on table with %d rows", index, tables[name].rows)})
+		}
+	case ts.accept("DROP", "TABLE"):
+		ts.accept("IF", "EXISTS")
+		for {
+			name := ts.name()
+			if name == "" {
+				break
+			}
+			delete(tables, name)
+			findings = append(findings, DDLFinding{Kind: DDLDropTable, Table: name})
+			if !ts.accept(",") {
+				break
+			}
+		}
+	case ts.accept("ALTER", "TABLE"):
+		name := ts.name()
+		if name == alembicVersionTable {
+			return
+		}
+		t := tables[name]
+		for _, clause := range ts.list() {
+			findings = append(findings, a.alterClause(t, name, clause)...)
+		}
+	}
+	return
+}
+
+// alterClause 分析 ALTER TABLE 中以逗号分隔的单个子句，t 为空表示表结构未知
+func (a *DDLAnalyzer) alterClause(t *ddlTable, table string, ts *ddlTokens) (findings []DDLFinding) {
+	switch {
+	case ts.accept("DROP"):
+		if ts.acceptAny("INDEX", "KEY", "FOREIGN", "PRIMARY", "CONSTRAINT", "CHECK", "PARTITION") != "" {
+			return
+		}
+		ts.accept("COLUMN")
+		column := ts.ident()
+		if t != nil {
+			delete(t.columns, column)
+		}
+		findings = append(findings, DDLFinding{Kind: DDLDropColumn, Table: table, Column: column})
+	case ts.accept("ADD"):
+		if kw := ts.acceptAny("INDEX", "KEY", "UNIQUE", "PRIMARY", "FULLTEXT", "SPATIAL", "CONSTRAINT"); kw != "" {
+			if a.large(t) {
+				findings = append(findings, DDLFinding{Kind: DDLIndexOnLargeTable, Table: table,
+					Detail: fmt.Sprintf("add %s on table with %d rows", strings.ToLower(kw), t.rows)})
+			}
+			return
+		}
+		ts.accept("COLUMN")
+		column, c := ts.columnDefinition()
+		if c == nil {
+			return
+		}
+		// 表中已有数据(或表结构未知)时，新增的 NOT NULL 列没有默认值会导致失败或写入隐式零值
+		if c.notNull && !c.hasDefault && !c.auto && (t == nil || t.rows > 0) {
+			findings = append(findings, DDLFinding{Kind: DDLNotNullWithoutDefault, Table: table, Column: column,
+				Detail: "add NOT NULL column without default"})
+		}
+		if t != nil {
+			t.columns[column] = c
+		}
+	case ts.accept("MODIFY"):
+		ts.accept("COLUMN")
+		column, c := ts.columnDefinition()
+		findings = append(findings, a.changeColumn(t, table, column, column, c)...)
+	case ts.accept("CHANGE"):
+		ts.accept("COLUMN")
+		old := ts.ident()
+		column, c := ts.columnDefinition()
+		findings = append(findings, a.changeColumn(t, table, old, column, c)...)
+	case ts.accept("ALTER"):
+		// PostgreSQL 以 ALTER COLUMN 逐项修改列，MySQL 的 ALTER COLUMN 只能修改默认值
+		ts.accept("COLUMN")
+		column := ts.ident()
+		var prev *ddlColumn
+		if t != nil {
+			prev = t.columns[column]
+		}
+		switch {
+		case ts.accept("TYPE"), ts.accept("SET", "DATA", "TYPE"):
+			typ := parseColumnType(ts)
+			if prev == nil {
+				return
+			}
+			if narrow, detail := narrowing(prev.typ, typ); narrow {
+				findings = append(findings, DDLFinding{Kind: DDLNarrowingType, Table: table, Column: column, Detail: detail})
+			}
+			prev.typ = typ
+		case ts.accept("SET", "NOT", "NULL"):
+			// 默认值不会回填已有的 NULL，表中已有数据(或表结构未知)时可能失败
+			if (prev == nil || !prev.notNull) && (t == nil || t.rows > 0) {
+				findings = append(findings, DDLFinding{Kind: DDLNotNullWithoutDefault, Table: table, Column: column,
+					Detail: "set NOT NULL on existing column"})
+			}
+			if prev != nil {
+				prev.notNull = true
+			}
+		case ts.accept("DROP", "NOT", "NULL"):
+			if prev != nil {
+				prev.notNull = false
+			}
+		case ts.accept("SET", "DEFAULT"):
+			if prev != nil {
+				prev.hasDefault, prev.defaultValue = true, ts.expression()
+			}
+		case ts.accept("DROP", "DEFAULT"):
+			if prev != nil {
+				prev.hasDefault, prev.defaultValue = false, ""
+			}
+		}
+	}
+	return
+}
+
+func (a *DDLAnalyzer) changeColumn(t *ddlTable, table, old, column string, c *ddlColumn) (findings []DDLFinding) {
+	if c == nil {
+		return
+	}
+	var prev *ddlColumn
+	if t != nil {
+		prev = t.columns[old]
+		delete(t.columns, old)
+		t.columns[column] = c
+	}
+	if prev == nil {
+		return
+	}
+	if narrow, detail := narrowing(prev.typ, c.typ); narrow {
+		findings = append(findings, DDLFinding{Kind: DDLNarrowingType, Table: table, Column: column, Detail: detail})
+	}
+	if c.notNull && !prev.notNull && !c.hasDefault && t.rows > 0 {
+		findings = append(findings, DDLFinding{Kind: DDLNotNullWithoutDefault, Table: table, Column: column,
+			Detail: "modify nullable column to NOT NULL without default"})
+	}
+	return
+}
+
+// columnType 解析后的列类型
+type columnType struct {
+	name     string
+	args     []string
+	unsigned bool
+}
+
+func (c columnType) String() string {
+	s := c.name
+	if len(c.args) > 0 {
+		s += "(" + strings.Join(c.args, ",") + ")"
+	}
+	if c.unsigned {
+		s += " unsigned"
+	}
+	return s
+}
+
+var (
+	integerRanks = map[string]int{"tinyint": 1, "bool": 1, "boolean": 1, "smallint": 2, "mediumint": 3, "int": 4, "integer": 4, "bigint": 5}
+	// integerDigits 整数类型的十进制位数，用于判断转换为 decimal 时是否会溢出
+	integerDigits = map[int]int{1: 3, 2: 5, 3: 8, 4: 10, 5: 20}
+	floatRanks    = map[string]int{"float": 1, "double": 2, "real": 2}
+	// 字符串类型的最大长度，varchar/char 的长度取自参数
+	textCapacities   = map[string]int64{"tinytext": 255, "text": 65535, "mediumtext": 16777215, "longtext": 4294967295}
+	binaryCapacities = map[string]int64{"tinyblob": 255, "blob": 65535, "mediumblob": 16777215, "longblob": 4294967295}
+	timeRanks        = map[string]int{"date": 1, "timestamp": 2, "datetime": 3}
+)
+
+// capacity 字符串或二进制类型的最大长度，family 为 text 或 binary
+func (c columnType) capacity() (family string, capacity int64, ok bool) {
+	switch c.name {
+	case "char", "varchar":
+		return "text", c.intArg(0, 1), true
+	case "binary", "varbinary":
+		return "binary", c.intArg(0, 1), true
+	}
+	if n, ok := textCapacities[c.name]; ok {
+		return "text", n, true
+	}
+	if n, ok := binaryCapacities[c.name]; ok {
+		return "binary", n, true
+	}
+	return "", 0, false
+}
+
+func (c columnType) intArg(i int, def int64) int64 {
+	if i >= len(c.args) {
+		return def
+	}
+	n, err := strconv.ParseInt(c.args[i], 10, 64)
+	if err != nil {
+		return def
+	}
+	return n
+}
+
+// narrowing 列类型由 from 修改为 to 是否可能截断或拒绝已有数据
+func narrowing(from, to columnType) (bool, string) {
+	if from.name == "" || to.name == "" || from.String() == to.String() {
+		return false, ""
+	}
+	detail := fmt.Sprintf("%s -> %s", from, to)
+	fi, fInt := integerRanks[from.name]
+	ti, tInt := integerRanks[to.name]
+	switch {
+	case fInt && tInt:
+		if ti < fi || (from.unsigned != to.unsigned && ti <= fi) {
+			return true, detail
+		}
+		return false, ""
+	case fInt && (to.name == "decimal" || to.name == "numeric"):
+		return to.intArg(0, 10)-to.intArg(1, 0) < int64(integerDigits[fi]), detail
+	case (from.name == "decimal" || from.name == "numeric") && (to.name == "decimal" || to.name == "numeric"):
+		fp, fs := from.intArg(0, 10), from.intArg(1, 0)
+		tp, tsc := to.intArg(0, 10), to.intArg(1, 0)
+		return tsc < fs || tp-tsc < fp-fs, detail
+	}
+	if fr, ok := floatRanks[from.name]; ok {
+		tr, ok := floatRanks[to.name]
+		return !ok || tr < fr, detail
+	}
+	if ff, fc, ok := from.capacity(); ok {
+		tf, tc, ok := to.capacity()
+		return !ok || tf != ff || tc < fc, detail
+	}
+	if fr, ok := timeRanks[from.name]; ok {
+		tr, ok := timeRanks[to.name]
+		if !ok || tr < fr {
+			return true, detail
+		}
+		// 小数秒精度降低
+		return to.intArg(0, 0) < from.intArg(0, 0), detail
+	}
+	if from.name == "enum" || from.name == "set" {
+		if to.name != from.name {
+			return true, detail
+		}
+		values := make(map[string]bool, len(to.args))
+		for _, v := range to.args {
+			values[v] = true
+		}
+		for _, v := range from.args {
+			if !values[v] {
+				return true, fmt.Sprintf("%s, value %s removed", detail, v)
+			}
+		}
+		return false, ""
+	}
+	if from.name != to.name {
+		return true, detail
+	}
+	// 同名类型，任一数值参数变小即视为收窄
+	for i := range from.args {
+		if to.intArg(i, 0) < from.intArg(i, 0) {
+			return true, detail
+		}
+	}
+	return false, ""
+}
+
+// ddlTokens 简单的 SQL 词法分析结果，引号包裹的标识符会去掉引号，关键字比较不区分大小写
+type ddlTokens struct {
+	tokens []string
+	pos    int
+}
+
+func newDDLTokens(stmt string) *ddlTokens {
+	ts := &ddlTokens{}
+	runes := []rune(stmt)
+	for i := 0; i < len(runes); {
+		c := runes[i]
+		switch {
+		case isSpace(c):
+			i++
+		case c == '`' || c == '"':
+			j := i + 1
+			for j < len(runes) && runes[j] != c {
+				j++
+			}
+			ts.tokens = append(ts.tokens, string(runes[i+1:minInt(j, len(runes))]))
+			i = j + 1
+		case c == '\'':
+			j := i + 1
+			for j < len(runes) && runes[j] != '\'' {
+				if runes[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			ts.tokens = append(ts.tokens, string(runes[i:minInt(j+1, len(runes))]))
+			i = j + 1
+		case c == '(' || c == ')' || c == ',' || c == '.' || c == '=' || c == ';':
+			ts.tokens = append(ts.tokens, string(c))
+			i++
+		default:
+			j := i
+			for j < len(runes) && !isSpace(runes[j]) && !strings.ContainsRune("()`\"',.=;", runes[j]) {
+				j++
+			}
+			ts.tokens = append(ts.tokens, string(runes[i:j]))
+			i = j
+		}
+	}
+	return ts
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func (ts *ddlTokens) peek() string {
+	if ts.pos < len(ts.tokens) {
+		return ts.tokens[ts.pos]
+	}
+	return ""
+}
+
+func (ts *ddlTokens) next() string {
+	t := ts.peek()
+	if ts.pos < len(ts.tokens) {
+		ts.pos++
+	}
+	return t
+}
+
+// accept 依次匹配 words，全部匹配时前进并返回 true
+func (ts *ddlTokens) accept(words ...string) bool {
+	if ts.pos+len(words) > len(ts.tokens) {
+		return false
+	}
+	for i, w := range words {
+		if !strings.EqualFold(ts.tokens[ts.pos+i], w) {
+			return false
+		}
+	}
+	ts.pos += len(words)
+	return true
+}
+
+// acceptAny 匹配 words 中的任一个，返回匹配到的关键字
+func (ts *ddlTokens) acceptAny(words ...string) string {
+	for _, w := range words {
+		if ts.accept(w) {
+			return w
+		}
+	}
+	return ""
+}
+
+// ident 标识符，统一转为小写
+func (ts *ddlTokens) ident() string {
+	t := ts.peek()
+	if t == "" || t == "(" || t == ")" || t == "," || t == ";" {
+		return ""
+	}
+	ts.pos++
+	return strings.ToLower(t)
+}
+
+// name 表名或索引名，忽略 schema 前缀
+func (ts *ddlTokens) name() string {
+	name := ts.ident()
+	for ts.accept(".") {
+		name = ts.ident()
+	}
+	return name
+}
+
+// list 读取括号内或语句剩余部分中以顶层逗号分隔的各项，读取到匹配的右括号为止
+func (ts *ddlTokens) list() (items []*ddlTokens) {
+	depth := 0
+	current := &ddlTokens{}
+	for ts.pos < len(ts.tokens) {
+		t := ts.next()
+		switch {
+		case t == "(":
+			depth++
+		case t == ")":
+			if depth == 0 {
+				return append(items, current)
+			}
+			depth--
+		case t == "," && depth == 0:
+			items = append(items, current)
+			current = &ddlTokens{}
+			continue
+		}
+		current.tokens = append(current.tokens, t)
+	}
+	return append(items, current)
+}
+
+// expression 读取一个值或表达式，如 'a'、0、now()、(uuid())
+func (ts *ddlTokens) expression() string {
+	t := ts.next()
+	if t == "(" {
+		var parts []string
+		for _, item := range ts.list() {
+			parts = append(parts, strings.Join(item.tokens, ""))
+		}
+		return "(" + strings.Join(parts, ",") + ")"
+	}
+	if ts.accept("(") {
+		var parts []string
+		for _, item := range ts.list() {
+			parts = append(parts, strings.Join(item.tokens, ""))
+		}
+		return t + "(" + strings.Join(parts, ",") + ")"
+	}
+	return t
+}
+
+// parseColumnType 解析列类型，如 VARCHAR(32)、INT UNSIGNED、ENUM('a','b')，PostgreSQL 的 CHARACTER VARYING(32) 视为 varchar(32)
+func parseColumnType(ts *ddlTokens) (c columnType) {
+	c.name = strings.ToLower(ts.next())
+	if c.name == "character" {
+		c.name = "char"
+		if ts.accept("VARYING") {
+			c.name = "varchar"
+		}
+	}
+	if ts.accept("(") {
+		for _, arg := range ts.list() {
+			c.args = append(c.args, strings.Join(arg.tokens, ""))
+		}
+	}
+	if ts.accept("UNSIGNED") {
+		c.unsigned = true
+	}
+	if c.name == "double" {
+		ts.accept("PRECISION")
+	}
+	if !ts.accept("WITH", "TIME", "ZONE") {
+		ts.accept("WITHOUT", "TIME", "ZONE")
+	}
+	return
+}
+
+// columnDefinition 解析列定义，非列定义(如索引、约束)返回 nil
+func (ts *ddlTokens) columnDefinition() (column string, c *ddlColumn) {
+	if ts.acceptAny("PRIMARY", "KEY", "INDEX", "UNIQUE", "CONSTRAINT", "FOREIGN", "FULLTEXT", "SPATIAL", "CHECK") != "" {
+		return
+	}
+	if column = ts.ident(); column == "" {
+		return
+	}
+	c = &ddlColumn{typ: parseColumnType(ts)}
+	for ts.pos < len(ts.tokens) {
+		switch {
+		case ts.accept("NOT", "NULL"):
+			c.notNull = true
+		case ts.accept("DEFAULT"):
+			c.hasDefault = true
+			c.defaultValue = ts.expression()
+		case ts.accept("AUTO_INCREMENT"), ts.accept("AS"), ts.accept("GENERATED"):
+			c.auto = true
+		case ts.accept("PRIMARY", "KEY"):
+			c.notNull = true
+			c.primary = true
+		case ts.accept("UNIQUE"):
+			ts.accept("KEY")
+			c.unique = true
+		default:
+			ts.next()
+		}
+	}
+	return
+}
+
+// tableStats 读取表信息，行数及列信息只对 MySQL 读取，其他方言仅依据 SQL 本身判断
+func (g *core) tableStats(ctx context.Context, db *sql.DB) (map[string]TableStats, error) {
+	if g.requireMySQL("table stats") != nil {
+		return nil, nil
+	}
+	return loadTableStats(ctx, db)
+}
+
+// loadTableStats 从 information_schema 读取当前库中各表的行数(估算值)及列信息
+func loadTableStats(ctx context.Context, db *sql.DB) (tables map[string]TableStats, err error) {
+	tables = make(map[string]TableStats)
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, "SELECT TABLE_NAME, COALESCE(TABLE_ROWS, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE()"); err != nil {
+		return nil, connectionError(err)
+	}
+	for rows.Next() {
+		var name string
+		var count int64
+		if err = rows.Scan(&name, &count); err != nil {
+			_ = rows.Close()
+			return
+		}
+		tables[name] = TableStats{Rows: count, Columns: make(map[string]ColumnStats)}
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+	if rows, err = db.QueryContext(ctx, "SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE()"); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var table, column, typ, nullable string
+		if err = rows.Scan(&table, &column, &typ, &nullable); err != nil {
+			return
+		}
+		if t, ok := tables[table]; ok {
+			t.Columns[column] = ColumnStats{Type: typ, Nullable: nullable == "YES"}
+		}
+	}
+	err = rows.Err()
+	return
+}
+
+// checkDDL 分析待执行的版本步骤，并按 DDLPolicy 处理其中的破坏性 DDL
+// db 为空时自行连接数据库读取表信息，无法读取时仅依据 SQL 本身判断
+func (g *core) checkDDL(ctx context.Context, db *sql.DB, steps []offlineStep) (findings []DDLFinding, err error) {
+	policy := g.conf.GetDDLPolicy()
+	if policy == DDLPolicyOff || len(steps) == 0 {
+		return
+	}
+	if policy != DDLPolicyWarn && policy != DDLPolicyBlock && policy != DDLPolicyAllowList {
+		return nil, fmt.Errorf("unknown ddl policy '%s'", policy)
+	}
+	var statsErr error
+	if db == nil {
+		if db, statsErr = g.openDatabase(); statsErr == nil {
+			defer func() { _ = db.Close() }()
+		}
+	}
+	analyzer := &DDLAnalyzer{LargeTableRows: g.conf.GetDDLLargeTableRows(), Dialect: g.dialectName()}
+	if statsErr == nil {
+		analyzer.Tables, statsErr = g.tableStats(ctx, db)
+	}
+	if statsErr != nil {
+		g.logger.WarnContext(ctx, "load table stats for ddl analysis failed, analyze without them", stageField(contextStage(ctx)), Field{Key: FieldError, Value: statsErr})
+	}
+	findings = analyzer.analyze(steps)
+
+	allowed := make(map[string]bool)
+	for _, id := range g.conf.GetDDLAllowRevisions() {
+		allowed[id] = true
+	}
+	var rejected []DDLFinding
+	for _, f := range findings {
+		switch {
+		case policy == DDLPolicyBlock, policy == DDLPolicyAllowList && !allowed[f.Revision]:
+			rejected = append(rejected, f)
+		default:
+			g.logger.WarnContext(ctx, "destructive DDL", stageField(contextStage(ctx)), revisionField(f.Revision), Field{Key: "finding", Value: f.String()}, Field{Key: "statement", Value: f.Statement})
+		}
+	}
+	if len(rejected) > 0 {
+		err = &DestructiveDDLError{Policy: policy, Findings: rejected}
+	}
+	return
+}
@@ -0,0 +1,68 @@
+package migration
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestDDLAnalyzer(t *testing.T) {
+	tables := map[string]TableStats{
+		"user": {Rows: 100, Columns: map[string]ColumnStats{
+			"id":    {Type: "int(11)"},
+			"name":  {Type: "varchar(32)", Nullable: true},
+			"score": {Type: "bigint(20)", Nullable: true},
+		}},
+		"empty": {Columns: map[string]ColumnStats{"name": {Type: "varchar(32)", Nullable: true}}},
+	}
+	for _, tc := range []struct {
+		name    string
+		dialect string
+		sql     string
+		want    []DDLKind
+	}{
+		{name: "drop table", sql: "DROP TABLE user;", want: []DDLKind{DDLDropTable}},
+		{name: "drop column", sql: "ALTER TABLE user DROP COLUMN name;", want: []DDLKind{DDLDropColumn}},
+		{name: "drop index", sql: "ALTER TABLE user DROP INDEX ix_name;"},
+		{name: "mysql modify narrowing", sql: "ALTER TABLE user MODIFY name VARCHAR(16) NULL;", want: []DDLKind{DDLNarrowingType}},
+		{name: "mysql modify widening", sql: "ALTER TABLE user MODIFY name VARCHAR(64) NULL;"},
+		{name: "mysql change narrowing", sql: "ALTER TABLE user CHANGE score points INT NULL;", want: []DDLKind{DDLNarrowingType}},
+		{name: "mysql modify not null", sql: "ALTER TABLE user MODIFY name VARCHAR(32) NOT NULL;", want: []DDLKind{DDLNotNullWithoutDefault}},
+		{name: "mysql modify not null with default", sql: "ALTER TABLE user MODIFY name VARCHAR(32) NOT NULL DEFAULT '';"},
+		{name: "add not null", sql: "ALTER TABLE user ADD COLUMN age INT NOT NULL;", want: []DDLKind{DDLNotNullWithoutDefault}},
+		{name: "narrowing in same sql", sql: "CREATE TABLE log (msg VARCHAR(255));\nALTER TABLE log MODIFY msg VARCHAR(64);", want: []DDLKind{DDLNarrowingType}},
+		{name: "postgres alter column type", dialect: DialectPostgres, sql: `ALTER TABLE "user" ALTER COLUMN name TYPE VARCHAR(16);`, want: []DDLKind{DDLNarrowingType}},
+		{name: "postgres set data type", dialect: DialectPostgres, sql: "ALTER TABLE user ALTER COLUMN score SET DATA TYPE INTEGER USING score::integer;", want: []DDLKind{DDLNarrowingType}},
+		{name: "postgres character varying", dialect: DialectPostgres, sql: "ALTER TABLE user ALTER name TYPE CHARACTER VARYING(64);"},
+		{name: "postgres set not null", dialect: DialectPostgres, sql: "ALTER TABLE user ALTER COLUMN name SET NOT NULL;", want: []DDLKind{DDLNotNullWithoutDefault}},
+		{name: "postgres set not null on empty table", dialect: DialectPostgres, sql: "ALTER TABLE empty ALTER COLUMN name SET NOT NULL;"},
+		{name: "postgres set not null on unknown table", dialect: DialectPostgres, sql: "ALTER TABLE orders ALTER COLUMN status SET NOT NULL;", want: []DDLKind{DDLNotNullWithoutDefault}},
+		{name: "postgres set default", dialect: DialectPostgres, sql: "ALTER TABLE user ALTER COLUMN name SET DEFAULT '';"},
+		{name: "postgres drop column", dialect: DialectPostgres, sql: `ALTER TABLE "user" DROP COLUMN score;`, want: []DDLKind{DDLDropColumn}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []DDLKind
+			for _, f := range (&DDLAnalyzer{Tables: tables, Dialect: tc.dialect}).Analyze(tc.sql) {
+				got = append(got, f.Kind)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("Analyze(%q) = %v, want %v", tc.sql, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckDDLWithoutDatabase(t *testing.T) {
+	h := &recordHandler{}
+	g := newCore(nil, WithScriptRoot(t.TempDir()), WithDDLPolicy(DDLPolicyWarn), WithLogHandler(h))
+	findings, err := g.checkDDL(context.Background(), nil, []offlineStep{{Upgrade: true, To: "a", Statements: []string{"DROP TABLE legacy"}}})
+	if err != nil {
+		t.Fatalf("checkDDL() = %v, want nil", err)
+	}
+	if len(findings) != 1 || findings[0].Kind != DDLDropTable || findings[0].Revision != "a" {
+		t.Fatalf("checkDDL() findings = %v, want drop_table in revision a", findings)
+	}
+	if len(h.records) == 0 || h.records[0].Level != LevelWarn || h.records[0].field(FieldError) == nil {
+		t.Fatalf("records %+v, want the table stats warning", h.records)
+	}
+}
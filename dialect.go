@@ -0,0 +1,191 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 内置的数据库方言，对应 DSN.Dialect
+const (
+	DialectMySQL    = "mysql"
+	DialectPostgres = "postgres"
+	DialectSQLite   = "sqlite"
+)
+
+// Dialect 数据库方言，隔离连接串格式、建库、元数据查询及迁移锁等与数据库相关的差异
+type Dialect interface {
+	// Name 方言名，与 DSN.Dialect 对应
+	Name() string
+	// DriverName database/sql 的驱动名，MySQL 之外的驱动需由使用方导入，如 _ "github.com/lib/pq"
+	DriverName() string
+	// DefaultPort DSN 中没有设置端口时使用的端口
+	DefaultPort() int
+	// FormatDSN database/sql 驱动使用的连接串，withDB 为 false 时不连接 DSN 中的库，用于在库不存在时建库
+	FormatDSN(d *DSN, withDB bool) (string, error)
+	// SQLAlchemyURL migration 脚本中 SQLALCHEMY_DATABASE_URI 使用的 URL
+	SQLAlchemyURL(d *DSN) (string, error)
+	// Placeholder 第 n 个(从1开始)参数的占位符
+	Placeholder(n int) string
+	// QuoteIdent 引用标识符
+	QuoteIdent(name string) string
+	// CreateDatabase 创建 DSN 中的库，已存在时不报错
+	CreateDatabase(ctx context.Context, d *DSN) error
+	// TableExistsQuery 查询当前库中指定表数量的语句，唯一的参数为表名
+	TableExistsQuery() string
+	// NewLocker 默认的迁移锁
+	NewLocker(d *DSN) (Locker, error)
+	// TransactionalDDL DDL 是否可以在事务中执行并回滚，为 true 时 native 后端在同一个事务中执行一个版本
+	TransactionalDDL() bool
+}
+
+var (
+	dialectsMu sync.RWMutex
+	dialects   = map[string]Dialect{}
+)
+
+func init() {
+	RegisterDialect(mysqlDialect{})
+	RegisterDialect(postgresDialect{})
+	RegisterDialect(sqliteDialect{})
+}
+
+// RegisterDialect 注册方言，同名方言会被覆盖
+func RegisterDialect(d Dialect) {
+	dialectsMu.Lock()
+	defer dialectsMu.Unlock()
+	dialects[d.Name()] = d
+}
+
+// LookupDialect 查找方言，name 为空时为 MySQL
+func LookupDialect(name string) (Dialect, error) {
+	if name == "" {
+		name = DialectMySQL
+	}
+	dialectsMu.RLock()
+	defer dialectsMu.RUnlock()
+	if d, ok := dialects[name]; ok {
+		return d, nil
+	}
+	return nil, fmt.Errorf("%w: '%s'", ErrUnsupportedDialect, name)
+}
+
+// rebind 将语句中的 ? 占位符替换为方言的占位符，语句中的字符串字面量不能包含 ?
+func rebind(dialect Dialect, query string) string {
+	if dialect.Placeholder(1) == "?" {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(dialect.Placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// dialectOpener 需要自行打开连接的方言，如保持内存库的 SQLite
+type dialectOpener interface {
+	Open(d *DSN, withDB bool) (*sql.DB, error)
+}
+
+// openDSN 以方言的驱动打开 DSN
+func openDSN(dialect Dialect, d *DSN, withDB bool) (*sql.DB, error) {
+	if opener, ok := dialect.(dialectOpener); ok {
+		return opener.Open(d, withDB)
+	}
+	dsn, err := dialect.FormatDSN(d, withDB)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open(dialect.DriverName(), dsn)
+}
+
+// dialect 当前数据库连接配置对应的方言
+func (g *core) dialect() (Dialect, error) {
+	database, err := g.database()
+	if err != nil {
+		return nil, err
+	}
+	return database.dialect()
+}
+
+// dialectName 当前方言名，数据库连接配置无效时为 MySQL，配置错误由连接数据库的操作返回
+func (g *core) dialectName() string {
+	dialect, err := g.dialect()
+	if err != nil {
+		return DialectMySQL
+	}
+	return dialect.Name()
+}
+
+// requireMySQL 仅支持 MySQL 的功能(在线表结构变更、drift 检测)在其他方言下返回 ErrUnsupportedDialect
+func (g *core) requireMySQL(feature string) error {
+	dialect, err := g.dialect()
+	if err != nil {
+		return err
+	}
+	if dialect.Name() != DialectMySQL {
+		return fmt.Errorf("%w: %s is not supported for '%s'", ErrUnsupportedDialect, feature, dialect.Name())
+	}
+	return nil
+}
+
+// mysqlDialect MySQL 方言，使用 go-sql-driver/mysql
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string       { return DialectMySQL }
+func (mysqlDialect) DriverName() string { return "mysql" }
+func (mysqlDialect) DefaultPort() int   { return defaultMySQLPort }
+
+func (mysqlDialect) FormatDSN(d *DSN, withDB bool) (string, error) {
+	cfg, err := d.MySQLConfig()
+	if err != nil {
+		return "", err
+	}
+	if !withDB {
+		cfg.DBName = ""
+	}
+	return cfg.FormatDSN(), nil
+}
+
+func (mysqlDialect) SQLAlchemyURL(d *DSN) (string, error) { return d.mysqlSQLAlchemyURL() }
+
+func (mysqlDialect) Placeholder(int) string { return "?" }
+
+func (mysqlDialect) QuoteIdent(name string) string { return quoteIdent(name) }
+
+func (dialect mysqlDialect) CreateDatabase(ctx context.Context, d *DSN) (err error) {
+	var db *sql.DB
+	if db, err = openDSN(dialect, d, false); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	_, err = db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS "+quoteIdent(d.DBName))
+	return connectionError(err)
+}
+
+func (mysqlDialect) TableExistsQuery() string {
+	return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+}
+
+// TransactionalDDL MySQL 的 DDL 会隐式提交事务
+func (mysqlDialect) TransactionalDDL() bool { return false }
+
+func (dialect mysqlDialect) NewLocker(d *DSN) (Locker, error) {
+	dsn, err := dialect.FormatDSN(d, true)
+	if err != nil {
+		return nil, err
+	}
+	return NewMySQLLocker(dsn), nil
+}
+
+// postgresPlaceholder PostgreSQL 的 $n 占位符
+func postgresPlaceholder(n int) string { return "$" + strconv.Itoa(n) }
@@ -0,0 +1,197 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+)
+
+// DriftKind 表结构差异的类型
+type DriftKind string
+
+const (
+	// DriftMissingTable head 版本中存在而数据库中不存在的表
+	DriftMissingTable DriftKind = "missing_table"
+	// DriftExtraTable 数据库中存在而 head 版本中不存在的表
+	DriftExtraTable DriftKind = "extra_table"
+	// DriftMissingColumn head 版本中存在而数据库中不存在的列
+	DriftMissingColumn DriftKind = "missing_column"
+	// DriftExtraColumn 数据库中存在而 head 版本中不存在的列
+	DriftExtraColumn DriftKind = "extra_column"
+	// DriftColumnType 列类型不一致
+	DriftColumnType DriftKind = "column_type_mismatch"
+	// DriftColumnNullable 列是否可为 NULL 不一致
+	DriftColumnNullable DriftKind = "column_nullable_mismatch"
+	// DriftColumnDefault 列默认值不一致
+	DriftColumnDefault DriftKind = "column_default_mismatch"
+	// DriftMissingIndex head 版本中存在而数据库中不存在的索引
+	DriftMissingIndex DriftKind = "missing_index"
+	// DriftExtraIndex 数据库中存在而 head 版本中不存在的索引
+	DriftExtraIndex DriftKind = "extra_index"
+	// DriftIndexMismatch 同名索引的列或唯一性不一致
+	DriftIndexMismatch DriftKind = "index_mismatch"
+)
+
+// DriftItem 一处表结构差异，Expected 为 head 版本推导出的定义，Actual 为数据库中的定义
+type DriftItem struct {
+	Kind     DriftKind `json:"kind"`
+	Table    string    `json:"table"`
+	Column   string    `json:"column,omitempty"`
+	Index    string    `json:"index,omitempty"`
+	Expected string    `json:"expected,omitempty"`
+	Actual   string    `json:"actual,omitempty"`
+}
+
+func (d DriftItem) String() string {
+	target := d.Table
+	switch {
+	case d.Column != "":
+		target += "." + d.Column
+	case d.Index != "":
+		target += " index " + d.Index
+	}
+	s := fmt.Sprintf("%s %s", d.Kind, target)
+	if d.Expected != "" || d.Actual != "" {
+		s += fmt.Sprintf(": expected %s, actual %s", orNone(d.Expected), orNone(d.Actual))
+	}
+	return s
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
+
+// DriftReport 数据库表结构与本地 head 版本推导出的表结构的差异
+type DriftReport struct {
+	// Head 本地 head 版本号
+	Head string `json:"head"`
+	// DatabaseRevision 数据库当前版本号，与 Head 不一致时差异中会包含尚未升级的版本带来的变更
+	DatabaseRevision string      `json:"database_revision"`
+	Items            []DriftItem `json:"items"`
+}
+
+// HasDrift 是否存在差异
+func (r DriftReport) HasDrift() bool { return len(r.Items) > 0 }
+
+func (r DriftReport) String() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "head: %s, database revision: %s\n", orNone(r.Head), orNone(r.DatabaseRevision))
+	if !r.HasDrift() {
+		b.WriteString("no drift detected\n")
+	}
+	for _, item := range r.Items {
+		b.WriteString(item.String() + "\n")
+	}
+	return b.String()
+}
+
+// drift 以 statements 推导出期望的表结构，与数据库中的表结构对比
+func (g *core) drift(ctx context.Context, db *sql.DB, statements []string) (items []DriftItem, err error) {
+	if err = g.requireMySQL("drift"); err != nil {
+		return
+	}
+	if db == nil {
+		if db, err = g.openDatabase(); err != nil {
+			return
+		}
+		defer func() { _ = db.Close() }()
+	}
+	expected := newSchema()
+	for _, stmt := range statements {
+		expected.apply(stmt)
+	}
+	var actual *schema
+	if actual, err = loadSchema(ctx, db); err != nil {
+		return
+	}
+	return diffSchema(expected, actual), nil
+}
+
+// diffSchema 对比两个表结构，结果按表名排序
+func diffSchema(expected, actual *schema) (items []DriftItem) {
+	for _, name := range expected.tableNames() {
+		if isInternalTable(name) {
+			continue
+		}
+		et, at := expected.tables[name], actual.tables[name]
+		if at == nil {
+			items = append(items, DriftItem{Kind: DriftMissingTable, Table: name})
+			continue
+		}
+		items = append(items, diffTable(et, at)...)
+	}
+	for _, name := range actual.tableNames() {
+		if _, ok := expected.tables[name]; !ok && !isInternalTable(name) {
+			items = append(items, DriftItem{Kind: DriftExtraTable, Table: name})
+		}
+	}
+	return
+}
+
+func diffTable(et, at *schemaTable) (items []DriftItem) {
+	table := et.name
+	for _, ec := range et.columns {
+		_, ac := at.column(ec.name)
+		if ac == nil {
+			items = append(items, DriftItem{Kind: DriftMissingColumn, Table: table, Column: ec.name, Expected: ec.typ})
+			continue
+		}
+		if ec.typ != ac.typ {
+			items = append(items, DriftItem{Kind: DriftColumnType, Table: table, Column: ec.name, Expected: ec.typ, Actual: ac.typ})
+		}
+		if ec.nullable != ac.nullable {
+			items = append(items, DriftItem{Kind: DriftColumnNullable, Table: table, Column: ec.name,
+				Expected: nullability(ec.nullable), Actual: nullability(ac.nullable)})
+		}
+		if ed, ad := defaultString(ec.def), defaultString(ac.def); ed != ad {
+			items = append(items, DriftItem{Kind: DriftColumnDefault, Table: table, Column: ec.name, Expected: ed, Actual: ad})
+		}
+	}
+	for _, ac := range at.columns {
+		if _, ec := et.column(ac.name); ec == nil {
+			items = append(items, DriftItem{Kind: DriftExtraColumn, Table: table, Column: ac.name, Actual: ac.typ})
+		}
+	}
+	for _, ei := range et.indexes {
+		_, ai := at.index(ei.name)
+		if ai == nil {
+			items = append(items, DriftItem{Kind: DriftMissingIndex, Table: table, Index: ei.name, Expected: ei.String()})
+			continue
+		}
+		if ei.String() != ai.String() {
+			items = append(items, DriftItem{Kind: DriftIndexMismatch, Table: table, Index: ei.name, Expected: ei.String(), Actual: ai.String()})
+		}
+	}
+	for _, ai := range at.indexes {
+		if _, ei := et.index(ai.name); ei == nil {
+			items = append(items, DriftItem{Kind: DriftExtraIndex, Table: table, Index: ai.name, Actual: ai.String()})
+		}
+	}
+	return
+}
+
+func (idx *schemaIndex) String() string {
+	s := "(" + strings.Join(idx.columns, ", ") + ")"
+	if idx.unique {
+		s = "UNIQUE " + s
+	}
+	return s
+}
+
+func nullability(nullable bool) string {
+	if nullable {
+		return "NULL"
+	}
+	return "NOT NULL"
+}
+
+func defaultString(def *string) string {
+	if def == nil {
+		return ""
+	}
+	return "DEFAULT " + *def
+}
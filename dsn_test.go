@@ -0,0 +1,106 @@
+package migration
+
+import (
+	"github.com/go-sql-driver/mysql"
+	"reflect"
+	"testing"
+)
+
+func TestDSNSQLAlchemyURLRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		dsn  *DSN
+		url  string
+	}{
+		{
+			name: "mysql",
+			dsn:  &DSN{Host: "db", Port: 3307, User: "root", Password: "p@ss:w/rd", DBName: "app", Params: map[string]string{"charset": "utf8mb4"}},
+			url:  "mysql://root:p%40ss%3Aw%2Frd@db:3307/app?charset=utf8mb4",
+		},
+		{
+			name: "mysql driver tls timeout",
+			dsn:  &DSN{Host: "db", Port: 3306, User: "u", DBName: "app", Driver: "pymysql", TLS: "true", Params: map[string]string{"timeout": "5s"}},
+			url:  "mysql+pymysql://u@db:3306/app?connect_timeout=5&ssl_mode=VERIFY_IDENTITY",
+		},
+		{
+			name: "mysql socket",
+			dsn:  &DSN{User: "u", DBName: "app", Socket: "/var/run/mysqld/mysqld.sock"},
+			url:  "mysql://u@/app?unix_socket=%2Fvar%2Frun%2Fmysqld%2Fmysqld.sock",
+		},
+		{
+			name: "postgres",
+			dsn:  &DSN{Dialect: DialectPostgres, Host: "pg", Port: 5432, User: "u", Password: "p", DBName: "app", Driver: "psycopg2", TLS: "require"},
+			url:  "postgresql+psycopg2://u:p@pg:5432/app?sslmode=require",
+		},
+		{
+			name: "sqlite",
+			dsn:  &DSN{Dialect: DialectSQLite, DBName: "/data/app.db"},
+			url:  "sqlite:////data/app.db",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			url, err := tc.dsn.SQLAlchemyURL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if url != tc.url {
+				t.Fatalf("SQLAlchemyURL() = %s, want %s", url, tc.url)
+			}
+			parsed, err := ParseSQLAlchemyURL(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(parsed, tc.dsn) {
+				t.Fatalf("ParseSQLAlchemyURL(%s) = %+v, want %+v", url, parsed, tc.dsn)
+			}
+		})
+	}
+}
+
+func TestDSNGoDriverRoundTrip(t *testing.T) {
+	for _, dsn := range []string{
+		"root:secret@tcp(127.0.0.1:3306)/app?charset=utf8mb4&parseTime=true",
+		"u@unix(/tmp/mysql.sock)/app?timeout=5s",
+		"u:p@tcp(db:3307)/app?tls=skip-verify",
+	} {
+		t.Run(dsn, func(t *testing.T) {
+			d, err := ParseDSN(dsn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			formatted, err := d.FormatDSN()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := normalizeMySQLDSN(t, formatted), normalizeMySQLDSN(t, dsn); got != want {
+				t.Fatalf("FormatDSN() = %s, want %s", got, want)
+			}
+			// 经由 SQLAlchemy URL 往返后，两侧共有的参数保持不变
+			url, err := d.SQLAlchemyURL()
+			if err != nil {
+				t.Fatal(err)
+			}
+			back, err := ParseDSN(url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			delete(d.Params, "parseTime")
+			if len(d.Params) == 0 {
+				d.Params = nil
+			}
+			if !reflect.DeepEqual(back, d) {
+				t.Fatalf("ParseDSN(%s) = %+v, want %+v", url, back, d)
+			}
+		})
+	}
+}
+
+// normalizeMySQLDSN 以 go-sql-driver 解析后重新格式化，消除参数顺序等差异
+func normalizeMySQLDSN(t *testing.T, dsn string) string {
+	t.Helper()
+	cfg, err := mysql.ParseDSN(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg.FormatDSN()
+}
@@ -0,0 +1,189 @@
+package migration
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"net"
+	"strings"
+)
+
+// 可通过 errors.Is 判断的错误类型
+var (
+	// ErrNoSchemaChanges 没有检测到 schema 变更，未生成新版本
+	ErrNoSchemaChanges = errors.New("no changes in schema detected")
+	// ErrDatabaseNotUpToDate 数据库版本不是最新，需要先 Upgrade 才能生成新版本
+	ErrDatabaseNotUpToDate = errors.New("target database is not up to date")
+	// ErrMultipleHeads 存在多个 head 版本
+	ErrMultipleHeads = errors.New("multiple head revisions are present")
+	// ErrRevisionNotFound 找不到指定的版本
+	ErrRevisionNotFound = errors.New("revision not found")
+	// ErrConnection 无法连接数据库
+	ErrConnection = errors.New("database connection failed")
+	// ErrNoDatabase 未配置 Database/Dsn，migration 脚本中也没有可解析的 SQLALCHEMY_DATABASE_URI，依赖数据库连接的附加功能(锁、审计等)会被跳过
+	ErrNoDatabase = errors.New("no database configured")
+	// ErrMigrationsAlreadyExists migrations 目录已初始化
+	ErrMigrationsAlreadyExists = errors.New("migrations directory already exists")
+	// ErrTimeout 阶段执行超时，具体信息见 *TimeoutError
+	ErrTimeout = errors.New("migration stage timed out")
+	// ErrLockHeld 迁移锁被其他持有者占用，具体信息见 *LockHeldError
+	ErrLockHeld = errors.New("migration lock held")
+	// ErrDestructiveDDL 破坏性 DDL 被 DDLPolicy 拒绝执行，具体信息见 *DestructiveDDLError
+	ErrDestructiveDDL = errors.New("destructive DDL rejected")
+	// ErrVetoed 操作被 Hook 否决，具体信息见 *VetoError
+	ErrVetoed = errors.New("vetoed by hook")
+	// ErrUnsupportedDialect 方言未注册或功能不支持该方言
+	ErrUnsupportedDialect = errors.New("unsupported database dialect")
+	// ErrAlreadyVersioned 本地已有版本或数据库已有版本号，不能执行 Baseline
+	ErrAlreadyVersioned = errors.New("migrations are already versioned")
+	// ErrBaselineMismatch Baseline 生成的版本与数据库中的表结构不一致，具体信息见 *BaselineMismatchError
+	ErrBaselineMismatch = errors.New("baseline revision does not match the live schema")
+)
+
+// commandErrorKinds 依据 flask db 的输出识别错误类型
+var commandErrorKinds = []struct {
+	kind     error
+	patterns []string
+}{
+	{ErrNoSchemaChanges, []string{SchemaNoChanges}},
+	{ErrDatabaseNotUpToDate, []string{dbNotUpToDate}},
+	{ErrMultipleHeads, []string{"Multiple head revisions are present", "Multiple heads are present"}},
+	{ErrRevisionNotFound, []string{"Can't locate revision identified by", "No such revision or branch"}},
+	{ErrMigrationsAlreadyExists, []string{migrationsAlreadyExists}},
+	{ErrConnection, []string{
+		"Can't connect to MySQL server",
+		"Unknown MySQL server host",
+		"Lost connection to MySQL server",
+		"Access denied for user",
+		"could not connect to server",
+		"Connection refused",
+	}},
+	// Python 2 下 flask db init 对已初始化的目录只输出 logging 的告警，排在最后以免掩盖同时输出的其他错误
+	{ErrMigrationsAlreadyExists, []string{migrationsAlreadyDone}},
+}
+
+// CommandError 外部命令执行失败，Kind 为识别出的错误类型，可通过 errors.Is 判断
+type CommandError struct {
+	Command  string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Kind     error
+	Err      error
+}
+
+func (e *CommandError) Error() string {
+	return fmt.Sprintf("command '%s' failed, exit code: %d, error: %v, stderr:%s", e.Command, e.ExitCode, e.Err, e.Stderr)
+}
+
+func (e *CommandError) Unwrap() error { return e.Err }
+
+func (e *CommandError) Is(target error) bool { return e.Kind != nil && e.Kind == target }
+
+// classifyOutput 识别命令输出中的错误类型
+func classifyOutput(outputs ...[]byte) error {
+	for _, k := range commandErrorKinds {
+		for _, output := range outputs {
+			for _, pattern := range k.patterns {
+				if strings.Contains(string(output), pattern) {
+					return k.kind
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// newCommandError 构建 CommandError，err 为空时以识别出的错误类型作为 Err
+func newCommandError(cmd Cmd, stdout, stderr []byte, err error) *CommandError {
+	e := &CommandError{
+		Command: cmd.String(),
+		Stdout:  string(stdout),
+		Stderr:  string(stderr),
+		Kind:    classifyOutput(stderr, stdout),
+		Err:     err,
+	}
+	var ec interface{ ExitCode() int }
+	if errors.As(err, &ec) {
+		e.ExitCode = ec.ExitCode()
+	}
+	if e.Err == nil {
+		e.Err = e.Kind
+	}
+	return e
+}
+
+// noNewRevision 是否为未生成新版本的错误
+func noNewRevision(err error) bool {
+	return errors.Is(err, ErrNoSchemaChanges) || errors.Is(err, ErrDatabaseNotUpToDate)
+}
+
+// RevisionNotFoundError 找不到指定的版本
+type RevisionNotFoundError struct {
+	Revision string
+	Reason   string
+}
+
+func (e *RevisionNotFoundError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("can't locate revision identified by '%s', %s", e.Revision, e.Reason)
+	}
+	return fmt.Sprintf("can't locate revision identified by '%s'", e.Revision)
+}
+
+func (e *RevisionNotFoundError) Is(target error) bool { return target == ErrRevisionNotFound }
+
+// ConnectionError 数据库连接失败
+type ConnectionError struct {
+	Err error
+}
+
+func (e *ConnectionError) Error() string { return fmt.Sprintf("database connection failed: %v", e.Err) }
+
+func (e *ConnectionError) Unwrap() error { return e.Err }
+
+func (e *ConnectionError) Is(target error) bool { return target == ErrConnection }
+
+// postgresConnectionStates 视为连接失败的 PostgreSQL SQLSTATE
+var postgresConnectionStates = map[string]bool{
+	"28000": true, // invalid authorization specification
+	"28P01": true, // invalid password
+	"3D000": true, // invalid catalog name(库不存在)
+	"08001": true, // unable to establish connection
+	"08006": true, // connection failure
+}
+
+// connectionError 若 err 为连接类错误，则包装为 *ConnectionError
+func connectionError(err error) error {
+	if err == nil || errors.Is(err, ErrConnection) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	var netErr net.Error
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.As(err, &netErr), errors.Is(err, driver.ErrBadConn), errors.Is(err, mysql.ErrInvalidConn):
+		return &ConnectionError{Err: err}
+	case errors.As(err, &mysqlErr) && (mysqlErr.Number == 1045 || mysqlErr.Number == 1044 || mysqlErr.Number == 1049):
+		// 1045 access denied, 1044 access denied to database, 1049 unknown database
+		return &ConnectionError{Err: err}
+	case postgresConnectionStates[sqlState(err)]:
+		return &ConnectionError{Err: err}
+	}
+	return err
+}
+
+// VetoError Hook 在可否决的事件中返回了错误
+type VetoError struct {
+	Event Event
+	Err   error
+}
+
+func (e *VetoError) Error() string {
+	return fmt.Sprintf("%s vetoed by hook: %v", e.Event.String(), e.Err)
+}
+
+func (e *VetoError) Unwrap() error { return e.Err }
+
+func (e *VetoError) Is(target error) bool { return target == ErrVetoed }
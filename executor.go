@@ -0,0 +1,188 @@
+package migration
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Cmd 待执行的命令
+type Cmd struct {
+	Name string
+	Args []string
+	// Env 命令的环境变量，为 nil 时继承当前进程的环境变量
+	Env []string
+	// Dir 命令的工作目录，为空时使用当前进程的工作目录
+	Dir string
+}
+
+// String 命令行形式
+func (c Cmd) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Executor 命令执行器，可通过 WithExecutor 替换，以便在容器、远程节点中执行 flask db 命令或在测试中回放输出
+type Executor interface {
+	// Execute 执行命令，返回标准输出与标准错误
+	// 命令以非0状态码退出时，返回的 error 需实现 ExitCode() int
+	Execute(ctx context.Context, cmd Cmd) (stdout []byte, stderr []byte, err error)
+}
+
+// ExecutorFunc 函数形式的 Executor
+type ExecutorFunc func(ctx context.Context, cmd Cmd) (stdout []byte, stderr []byte, err error)
+
+// Execute 实现 Executor
+func (f ExecutorFunc) Execute(ctx context.Context, cmd Cmd) ([]byte, []byte, error) {
+	return f(ctx, cmd)
+}
+
+type localExecutor struct{}
+
+// NewLocalExecutor 本地进程执行器，默认的 Executor
+func NewLocalExecutor() Executor { return localExecutor{} }
+
+func (localExecutor) Execute(ctx context.Context, c Cmd) (stdout []byte, stderr []byte, err error) {
+	var outBuf, errBuf bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.Name, c.Args...)
+	cmd.Env = c.Env
+	cmd.Dir = c.Dir
+	cmd.Stdout = &outBuf
+	cmd.Stderr = &errBuf
+	err = cmd.Run()
+	return outBuf.Bytes(), errBuf.Bytes(), err
+}
+
+// Reply 命令的输出，用于 ReplayExecutor 回放
+type Reply struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+}
+
+// Record 一次命令执行记录
+type Record struct {
+	Cmd   Cmd
+	Reply Reply
+}
+
+// exitError 模拟 *exec.ExitError
+type exitError struct {
+	code int
+}
+
+func (e *exitError) Error() string { return fmt.Sprintf("exit status %d", e.code) }
+func (e *exitError) ExitCode() int { return e.code }
+
+// ReplayExecutor 回放预置输出的 Executor，并记录所有执行过的命令
+// 用于在没有 Python/Flask 环境时测试 Migrate/Upgrade 等流程的输出解析与错误处理
+type ReplayExecutor struct {
+	mu      sync.Mutex
+	replies []replayRule
+	records []Record
+}
+
+type replayRule struct {
+	args    string
+	replies []Reply
+}
+
+// NewReplayExecutor 创建 ReplayExecutor
+func NewReplayExecutor() *ReplayExecutor { return &ReplayExecutor{} }
+
+// On 为参数以 args 开头的命令预置输出(如 "db upgrade --sql")，多个输出依次回放，最后一个会被重复使用
+// 先注册的规则优先匹配
+func (e *ReplayExecutor) On(args string, replies ...Reply) *ReplayExecutor {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.replies = append(e.replies, replayRule{args: args, replies: replies})
+	return e
+}
+
+// Records 返回执行过的命令
+func (e *ReplayExecutor) Records() []Record {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Record(nil), e.records...)
+}
+
+// Execute 实现 Executor
+func (e *ReplayExecutor) Execute(ctx context.Context, cmd Cmd) (stdout []byte, stderr []byte, err error) {
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	args := strings.Join(cmd.Args, " ")
+	for i := range e.replies {
+		rule := &e.replies[i]
+		if !strings.HasPrefix(args, rule.args) || len(rule.replies) == 0 {
+			continue
+		}
+		reply := rule.replies[0]
+		if len(rule.replies) > 1 {
+			rule.replies = rule.replies[1:]
+		}
+		e.records = append(e.records, Record{Cmd: cmd, Reply: reply})
+		if reply.ExitCode != 0 {
+			err = &exitError{code: reply.ExitCode}
+		}
+		return []byte(reply.Stdout), []byte(reply.Stderr), err
+	}
+	return nil, nil, fmt.Errorf("replay executor: no reply for command '%s'", cmd)
+}
+
+// RecordingExecutor 记录被包装的 Executor 的命令与输出，记录可用于构建 ReplayExecutor
+type RecordingExecutor struct {
+	Executor
+	mu      sync.Mutex
+	records []Record
+}
+
+// NewRecordingExecutor 包装 next，next 为 nil 时使用本地进程执行器
+func NewRecordingExecutor(next Executor) *RecordingExecutor {
+	if next == nil {
+		next = NewLocalExecutor()
+	}
+	return &RecordingExecutor{Executor: next}
+}
+
+// Execute 实现 Executor
+func (e *RecordingExecutor) Execute(ctx context.Context, cmd Cmd) (stdout []byte, stderr []byte, err error) {
+	stdout, stderr, err = e.Executor.Execute(ctx, cmd)
+	reply := Reply{Stdout: string(stdout), Stderr: string(stderr)}
+	if ec, ok := err.(interface{ ExitCode() int }); ok {
+		reply.ExitCode = ec.ExitCode()
+	}
+	e.mu.Lock()
+	e.records = append(e.records, Record{Cmd: cmd, Reply: reply})
+	e.mu.Unlock()
+	return
+}
+
+// Records 返回记录的命令与输出
+func (e *RecordingExecutor) Records() []Record {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Record(nil), e.records...)
+}
+
+// Replay 以记录的输出构建 ReplayExecutor
+func (e *RecordingExecutor) Replay() *ReplayExecutor {
+	var order []string
+	replies := make(map[string][]Reply)
+	for _, r := range e.Records() {
+		args := strings.Join(r.Cmd.Args, " ")
+		if _, ok := replies[args]; !ok {
+			order = append(order, args)
+		}
+		replies[args] = append(replies[args], r.Reply)
+	}
+	replay := NewReplayExecutor()
+	for _, args := range order {
+		replay.On(args, replies[args]...)
+	}
+	return replay
+}
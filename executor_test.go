@@ -0,0 +1,205 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// newTestMigrate 以 replay 回放 flask 命令的 Alembic 后端，ScriptRoot 为临时目录
+func newTestMigrate(t *testing.T, replay Executor, opts ...ConfOption) *migrate {
+	t.Helper()
+	opts = append([]ConfOption{WithScriptRoot(t.TempDir()), WithExecutor(replay), WithLockEnabled(false)}, opts...)
+	return New(log.New(io.Discard, "", 0), opts...).(*migrate)
+}
+
+func TestPrepare(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		reply   Reply
+		wantErr error
+	}{
+		{name: "init", reply: Reply{Stdout: "Creating directory migrations ...  done"}},
+		{name: "already exists", reply: Reply{Stderr: "Error: " + migrationsAlreadyExists, ExitCode: 1}},
+		{name: "python2 logging", reply: Reply{Stderr: migrationsAlreadyDone + ` "alembic.env"`, ExitCode: 1}},
+		{name: "connection", reply: Reply{Stderr: "(2003, \"Can't connect to MySQL server on 'db'\")", ExitCode: 1}, wantErr: ErrConnection},
+		{name: "unknown", reply: Reply{Stderr: "Traceback (most recent call last):", ExitCode: 1}, wantErr: &CommandError{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			replay := NewReplayExecutor().On("db init", tc.reply)
+			g := newTestMigrate(t, replay)
+			err := g.prepare(context.Background())
+			switch target := tc.wantErr.(type) {
+			case nil:
+				if err != nil {
+					t.Fatalf("prepare() = %v, want nil", err)
+				}
+			case *CommandError:
+				if !errors.As(err, &target) || target.ExitCode != tc.reply.ExitCode || target.Kind != nil {
+					t.Fatalf("prepare() = %v, want unclassified *CommandError", err)
+				}
+			default:
+				if !errors.Is(err, tc.wantErr) {
+					t.Fatalf("prepare() = %v, want %v", err, tc.wantErr)
+				}
+			}
+			records := replay.Records()
+			if len(records) != 1 {
+				t.Fatalf("executed %d commands, want 1", len(records))
+			}
+			cmd := records[0].Cmd
+			if cmd.Name != "flask" || cmd.Dir != g.migrationBuildDir() {
+				t.Fatalf("command '%s' in '%s', want flask in '%s'", cmd, cmd.Dir, g.migrationBuildDir())
+			}
+			if len(cmd.Env) == 0 || cmd.Env[0] != "FLASK_APP="+g.conf.GetFileName() {
+				t.Fatalf("command env %v, want FLASK_APP", cmd.Env)
+			}
+		})
+	}
+}
+
+func TestGenerateRevisionScript(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		reply   Reply
+		wantErr error
+	}{
+		{name: "generated", reply: Reply{Stderr: "Generating migrations/versions/abc_.py ...  done"}},
+		{name: "no changes", reply: Reply{Stderr: "INFO  [alembic.env] " + SchemaNoChanges + "."}, wantErr: ErrNoSchemaChanges},
+		{name: "not up to date", reply: Reply{Stderr: "ERROR [flask_migrate] Error: " + dbNotUpToDate, ExitCode: 1}, wantErr: ErrDatabaseNotUpToDate},
+		{name: "unknown revision", reply: Reply{Stderr: "Can't locate revision identified by 'f00'", ExitCode: 1}, wantErr: ErrRevisionNotFound},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			replay := NewReplayExecutor().On("db migrate", tc.reply)
+			g := newTestMigrate(t, replay, WithCommitID("abc"))
+			// migrations 目录由 flask db init 创建
+			if err := os.Mkdir(filepath.Join(g.migrationBuildDir(), "migrations"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			err := g.generateRevisionScript(context.Background(), "add users")
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("generateRevisionScript() = %v, want %v", err, tc.wantErr)
+			}
+			// git 不会提交空目录，flask db migrate 之前需创建 versions 目录
+			if info, err := os.Stat(filepath.Join(g.migrationBuildDir(), migrationsVersionsDir)); err != nil || !info.IsDir() {
+				t.Fatalf("versions directory not created: %v", err)
+			}
+			args := replay.Records()[0].Cmd.Args
+			if want := []string{"db", "migrate", "--message=add users", "--rev-id=abc"}; strings.Join(args, " ") != strings.Join(want, " ") {
+				t.Fatalf("command args %q, want %q", args, want)
+			}
+		})
+	}
+}
+
+func TestClassifyOutput(t *testing.T) {
+	for _, tc := range []struct {
+		output string
+		want   error
+	}{
+		{output: SchemaNoChanges, want: ErrNoSchemaChanges},
+		{output: "Error: " + dbNotUpToDate, want: ErrDatabaseNotUpToDate},
+		{output: "ERROR [root] Error: Multiple head revisions are present for given argument 'head'", want: ErrMultipleHeads},
+		{output: "Multiple heads are present; please specify the head revision", want: ErrMultipleHeads},
+		{output: "ERROR [root] Error: Can't locate revision identified by 'f00'", want: ErrRevisionNotFound},
+		{output: "No such revision or branch 'f00'", want: ErrRevisionNotFound},
+		{output: "Error: " + migrationsAlreadyExists, want: ErrMigrationsAlreadyExists},
+		{output: "(2003, \"Can't connect to MySQL server on 'db' ([Errno 111] Connection refused)\")", want: ErrConnection},
+		{output: "(1045, \"Access denied for user 'root'@'localhost' (using password: YES)\")", want: ErrConnection},
+		{output: "could not connect to server: No such file or directory", want: ErrConnection},
+		{output: migrationsAlreadyDone + ` "alembic.env"`, want: ErrMigrationsAlreadyExists},
+		{output: migrationsAlreadyDone + " \"sqlalchemy\"\nCan't connect to MySQL server on 'db'", want: ErrConnection},
+		{output: "Traceback (most recent call last):", want: nil},
+	} {
+		if got := classifyOutput(nil, []byte(tc.output)); got != tc.want {
+			t.Errorf("classifyOutput(%q) = %v, want %v", tc.output, got, tc.want)
+		}
+	}
+}
+
+func TestRecordingExecutorReplay(t *testing.T) {
+	replay := NewReplayExecutor().
+		On("db current", Reply{Stdout: "a"}, Reply{Stdout: "b"}).
+		On("db upgrade", Reply{Stderr: "boom", ExitCode: 2})
+	recording := NewRecordingExecutor(replay)
+	ctx := context.Background()
+	for _, args := range [][]string{{"db", "current"}, {"db", "upgrade"}, {"db", "current"}} {
+		_, _, _ = recording.Execute(ctx, Cmd{Name: "flask", Args: args})
+	}
+
+	again := recording.Replay()
+	for i, want := range []Reply{{Stdout: "a"}, {Stderr: "boom", ExitCode: 2}, {Stdout: "b"}, {Stdout: "b"}} {
+		args := []string{"db", "current"}
+		if i == 1 {
+			args = []string{"db", "upgrade"}
+		}
+		stdout, stderr, err := again.Execute(ctx, Cmd{Name: "flask", Args: args})
+		var ec interface{ ExitCode() int }
+		code := 0
+		if errors.As(err, &ec) {
+			code = ec.ExitCode()
+		}
+		if got := (Reply{Stdout: string(stdout), Stderr: string(stderr), ExitCode: code}); got != want {
+			t.Fatalf("reply %d = %+v, want %+v", i, got, want)
+		}
+	}
+	if _, _, err := again.Execute(ctx, Cmd{Name: "flask", Args: []string{"db", "history"}}); err == nil {
+		t.Fatal("unexpected reply for unregistered command")
+	}
+}
+
+func TestParallelMigrations(t *testing.T) {
+	// 多个 Migration 共用一个 Executor 并发执行，以 go test -race 检查共享状态，命令的工作目录必须是各自的 ScriptRoot
+	head := Revision{RevisionId: "a", IsHead: true, Path: "a_.py", Doc: "a"}.LogEntry()
+	replay := NewReplayExecutor().
+		On("db init", Reply{}).
+		On("db history --verbose", Reply{Stdout: head}).
+		On("db show", Reply{Stdout: head}).
+		On("db current --verbose", Reply{}).
+		On("db upgrade", Reply{})
+	metrics := NewMetrics("test")
+	const n = 8
+	dirs := make(map[string]bool, n)
+	migrations := make([]*migrate, n)
+	for i := range migrations {
+		migrations[i] = newTestMigrate(t, replay, WithMetrics(metrics), WithAuditEnabled(false), WithDDLPolicy(DDLPolicyOff))
+		dirs[migrations[i].migrationBuildDir()] = true
+	}
+	var wg sync.WaitGroup
+	errs := make([]error, n)
+	for i, g := range migrations {
+		wg.Add(1)
+		go func(i int, g *migrate) {
+			defer wg.Done()
+			if errs[i] = g.Upgrade(); errs[i] == nil {
+				_, errs[i] = g.ShowLocalRevision("")
+			}
+		}(i, g)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("migration %d: %v", i, err)
+		}
+	}
+	upgrades := make(map[string]int, n)
+	for _, r := range replay.Records() {
+		if !dirs[r.Cmd.Dir] {
+			t.Fatalf("command '%s' ran in %q, want one of the script roots", r.Cmd, r.Cmd.Dir)
+		}
+		if len(r.Cmd.Args) > 1 && r.Cmd.Args[1] == "upgrade" {
+			upgrades[r.Cmd.Dir]++
+		}
+	}
+	for dir := range dirs {
+		if upgrades[dir] != 1 {
+			t.Fatalf("%s upgraded %d times, want 1", dir, upgrades[dir])
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FleetContinue 目标数据库执行失败时继续执行其他目标
+	FleetContinue = "continue"
+	// FleetStop 目标数据库执行失败时不再开始新的目标，已开始的目标会执行完成
+	FleetStop = "stop"
+)
+
+// Fleet 操作名，用于 FleetReport.Operation 及日志
+const (
+	FleetOperationUpgrade              = "upgrade"
+	FleetOperationShowDatabaseRevision = "show_database_revision"
+	FleetOperationHistory              = "history"
+)
+
+// FleetTarget 一个目标数据库，Options 在 FleetConf.SharedOptions 之后应用，通常设置 WithDatabase/WithDsn
+type FleetTarget struct {
+	Name    string
+	Options []ConfOption
+}
+
+// FleetDiscoverFunc 动态发现目标数据库，如从配置中心读取分片列表
+type FleetDiscoverFunc func(ctx context.Context) ([]FleetTarget, error)
+
+// FleetFunc 在一个目标数据库上执行的操作，result 中的 Head/DatabaseRevision/Pending 由 Fleet 在操作之后填充
+type FleetFunc func(ctx context.Context, m Migration, result *FleetResult) error
+
+// FleetResult 一个目标数据库的执行结果
+type FleetResult struct {
+	Target string `json:"target"`
+	// Err 执行失败的原因
+	Err error `json:"-"`
+	// Skipped 因 stop 策略或 ctx 结束而没有执行
+	Skipped  bool          `json:"skipped,omitempty"`
+	Duration time.Duration `json:"duration"`
+	// Head 本地 head 版本号
+	Head string `json:"head,omitempty"`
+	// DatabaseRevision 执行之后数据库的版本号
+	DatabaseRevision string `json:"database_revision,omitempty"`
+	// Pending 数据库尚未执行的版本，按升级顺序排列
+	Pending []string `json:"pending,omitempty"`
+	// History History 操作的结果
+	History []Revision `json:"history,omitempty"`
+}
+
+// Behind 数据库版本是否落后于 head
+func (r FleetResult) Behind() bool { return len(r.Pending) > 0 }
+
+func (r FleetResult) String() string {
+	switch {
+	case r.Skipped:
+		return fmt.Sprintf("%s: skipped", r.Target)
+	case r.Err != nil:
+		return fmt.Sprintf("%s: failed after %s: %v", r.Target, r.Duration, r.Err)
+	case r.Behind():
+		return fmt.Sprintf("%s: %s, behind head %s by %d revision(s): %s",
+			r.Target, orNone(r.DatabaseRevision), r.Head, len(r.Pending), strings.Join(r.Pending, ", "))
+	}
+	return fmt.Sprintf("%s: %s, up to date", r.Target, orNone(r.DatabaseRevision))
+}
+
+// FleetReport 所有目标数据库的执行结果，顺序与目标顺序一致
+type FleetReport struct {
+	Operation string        `json:"operation"`
+	Results   []FleetResult `json:"results"`
+}
+
+func (r FleetReport) filter(f func(FleetResult) bool) (out []FleetResult) {
+	for _, result := range r.Results {
+		if f(result) {
+			out = append(out, result)
+		}
+	}
+	return
+}
+
+// Failed 执行失败的目标
+func (r FleetReport) Failed() []FleetResult {
+	return r.filter(func(result FleetResult) bool { return result.Err != nil })
+}
+
+// Skipped 没有执行的目标
+func (r FleetReport) Skipped() []FleetResult {
+	return r.filter(func(result FleetResult) bool { return result.Skipped })
+}
+
+// Behind 数据库版本落后于 head 的目标
+func (r FleetReport) Behind() []FleetResult {
+	return r.filter(func(result FleetResult) bool { return result.Err == nil && result.Behind() })
+}
+
+// Err 存在失败或跳过的目标时返回 *FleetError
+func (r FleetReport) Err() error {
+	failed, skipped := r.Failed(), r.Skipped()
+	if len(failed) == 0 && len(skipped) == 0 {
+		return nil
+	}
+	return &FleetError{Operation: r.Operation, Failed: failed, Skipped: len(skipped), Total: len(r.Results)}
+}
+
+func (r FleetReport) String() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "%s: %d target(s), %d failed, %d skipped, %d behind head\n",
+		r.Operation, len(r.Results), len(r.Failed()), len(r.Skipped()), len(r.Behind()))
+	for _, result := range r.Results {
+		b.WriteString(result.String() + "\n")
+	}
+	return b.String()
+}
+
+// FleetError Fleet 中存在失败或跳过的目标
+type FleetError struct {
+	Operation string
+	Failed    []FleetResult
+	Skipped   int
+	Total     int
+}
+
+func (e *FleetError) Error() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "fleet %s: %d of %d target(s) failed, %d skipped", e.Operation, len(e.Failed), e.Total, e.Skipped)
+	for _, result := range e.Failed {
+		_, _ = fmt.Fprintf(&b, "; %s: %v", result.Target, result.Err)
+	}
+	return b.String()
+}
+
+// Unwrap 第一个失败目标的错误，便于以 errors.Is 判断失败原因
+func (e *FleetError) Unwrap() error {
+	if len(e.Failed) == 0 {
+		return nil
+	}
+	return e.Failed[0].Err
+}
+
+// Fleet 在多个结构相同的数据库(如分片、租户库)上执行迁移操作
+type Fleet struct {
+	std    *log.Logger
+	logger *Logger
+	conf   *FleetConf
+}
+
+// NewFleet 创建 Fleet，logger 同时作为各目标 Migration 的日志输出，并以目标名作为前缀，
+// SharedOptions 中设置了 LogHandler 时 Fleet 及各目标均使用该 Handler，目标名作为 target 字段
+func NewFleet(logger *log.Logger, opts ...FleetConfOption) *Fleet {
+	if logger == nil {
+		logger = log.Default()
+	}
+	f := &Fleet{std: logger, logger: NewLogger(logger), conf: NewFleetConf(opts...)}
+	if h := NewConf(f.conf.GetSharedOptions()...).GetLogHandler(); h != nil {
+		f.logger = NewLoggerWithHandler(h)
+	}
+	return f
+}
+
+// targets Targets 与 Discover 发现的目标，目标名不能为空且不能重复
+func (f *Fleet) targets(ctx context.Context) (targets []FleetTarget, err error) {
+	targets = append(targets, f.conf.GetTargets()...)
+	if discover := f.conf.GetDiscover(); discover != nil {
+		var discovered []FleetTarget
+		if discovered, err = discover(ctx); err != nil {
+			return nil, fmt.Errorf("discover fleet targets: %w", err)
+		}
+		targets = append(targets, discovered...)
+	}
+	seen := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if target.Name == "" {
+			return nil, fmt.Errorf("fleet target name is empty")
+		}
+		if seen[target.Name] {
+			return nil, fmt.Errorf("duplicate fleet target '%s'", target.Name)
+		}
+		seen[target.Name] = true
+	}
+	return
+}
+
+// concurrency 并发数，不大于目标数
+func (f *Fleet) concurrency(targets []FleetTarget) int {
+	n := f.conf.GetConcurrency()
+	if n <= 0 {
+		n = 1
+	}
+	if n > len(targets) {
+		n = len(targets)
+	}
+	return n
+}
+
+// migration 目标对应的 Migration
+func (f *Fleet) migration(target FleetTarget) Migration {
+	opts := f.options(target)
+	if h := NewConf(opts...).GetLogHandler(); h != nil {
+		return New(f.std, append(opts, WithLogHandler(HandlerWithFields(h, Field{Key: "target", Value: target.Name})))...)
+	}
+	logger := log.New(f.std.Writer(), f.std.Prefix()+"["+target.Name+"] ", f.std.Flags())
+	return New(logger, opts...)
+}
+
+// options 目标的 Conf 配置，目标自身的配置在共用配置之后应用
+func (f *Fleet) options(target FleetTarget) []ConfOption {
+	return append(append([]ConfOption(nil), f.conf.GetSharedOptions()...), target.Options...)
+}
+
+// Run 在所有目标上执行 fn，按 FailurePolicy 处理失败，返回的 error 为目标发现失败或 FleetReport.Err()
+func (f *Fleet) Run(ctx context.Context, operation string, fn FleetFunc) (report FleetReport, err error) {
+	f.logger.Info("fleet "+operation+"...", stageField(operation))
+	defer func() {
+		f.logger.InfoWithFlag(err, "fleet "+operation, stageField(operation), Field{Key: "targets", Value: len(report.Results)},
+			Field{Key: "failed", Value: len(report.Failed())}, Field{Key: "skipped", Value: len(report.Skipped())}, Field{Key: "behind", Value: len(report.Behind())})
+	}()
+	report.Operation = operation
+	var targets []FleetTarget
+	if targets, err = f.targets(ctx); err != nil {
+		return
+	}
+	report.Results = make([]FleetResult, len(targets))
+	if len(targets) == 0 {
+		return
+	}
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		stopped bool
+		sem     = make(chan struct{}, f.concurrency(targets))
+	)
+	stop := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return stopped || ctx.Err() != nil
+	}
+	for i, target := range targets {
+		report.Results[i] = FleetResult{Target: target.Name}
+		sem <- struct{}{}
+		if stop() {
+			<-sem
+			report.Results[i].Skipped = true
+			continue
+		}
+		wg.Add(1)
+		go func(result *FleetResult, target FleetTarget) {
+			defer func() { <-sem; wg.Done() }()
+			f.run(ctx, operation, target, fn, result)
+			if result.Err != nil && f.conf.GetFailurePolicy() == FleetStop {
+				mu.Lock()
+				stopped = true
+				mu.Unlock()
+			}
+		}(&report.Results[i], target)
+	}
+	wg.Wait()
+	err = report.Err()
+	return
+}
+
+func (f *Fleet) run(ctx context.Context, operation string, target FleetTarget, fn FleetFunc, result *FleetResult) {
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+		f.logger.InfoWithFlag(result.Err, "fleet target", stageField(operation), Field{Key: "target", Value: target.Name}, Field{Key: "database", Value: result.DatabaseRevision},
+			Field{Key: "head", Value: result.Head}, Field{Key: "pending", Value: len(result.Pending)}, Field{Key: FieldDuration, Value: result.Duration})
+	}()
+	m := f.migration(target)
+	if result.Err = fn(ctx, m, result); result.Err != nil {
+		return
+	}
+	result.Err = f.state(ctx, m, result)
+}
+
+// state 填充目标的 head、数据库版本及尚未执行的版本
+func (f *Fleet) state(ctx context.Context, m Migration, result *FleetResult) (err error) {
+	result.Head, result.DatabaseRevision, result.Pending, err = pendingRevisions(ctx, m, result.History)
+	return
+}
+
+// pendingRevisions 本地 head、数据库版本及数据库尚未执行的版本，revisions 为空时通过 History 获取版本列表
+func pendingRevisions(ctx context.Context, m Migration, revisions []Revision) (head, current string, pending []string, err error) {
+	if revisions == nil {
+		if revisions, err = m.HistoryContext(ctx); err != nil {
+			return
+		}
+	}
+	var graph *RevisionGraph
+	if graph, err = NewRevisionGraph(revisions); err != nil {
+		return
+	}
+	var revision Revision
+	if revision, err = m.ShowDatabaseRevisionContext(ctx); err != nil {
+		return
+	}
+	current = revision.RevisionId
+	if head, err = graph.Head(); err != nil || head == "" {
+		return
+	}
+	pending, err = graph.Path(current, head)
+	return
+}
+
+// Upgrade 将所有目标升级到 head
+func (f *Fleet) Upgrade() (FleetReport, error) {
+	return f.UpgradeContext(context.Background())
+}
+
+// UpgradeContext Upgrade with context.
+func (f *Fleet) UpgradeContext(ctx context.Context) (FleetReport, error) {
+	return f.Run(ctx, FleetOperationUpgrade, func(ctx context.Context, m Migration, _ *FleetResult) error {
+		return m.UpgradeContext(ctx)
+	})
+}
+
+// ShowDatabaseRevision 所有目标的数据库版本，FleetReport.Behind 为落后于 head 的目标
+func (f *Fleet) ShowDatabaseRevision() (FleetReport, error) {
+	return f.ShowDatabaseRevisionContext(context.Background())
+}
+
+// ShowDatabaseRevisionContext ShowDatabaseRevision with context.
+func (f *Fleet) ShowDatabaseRevisionContext(ctx context.Context) (FleetReport, error) {
+	return f.Run(ctx, FleetOperationShowDatabaseRevision, func(context.Context, Migration, *FleetResult) error {
+		return nil
+	})
+}
+
+// History 所有目标的版本列表，结果在 FleetResult.History 中
+func (f *Fleet) History() (FleetReport, error) {
+	return f.HistoryContext(context.Background())
+}
+
+// HistoryContext History with context.
+func (f *Fleet) HistoryContext(ctx context.Context) (FleetReport, error) {
+	return f.Run(ctx, FleetOperationHistory, func(ctx context.Context, m Migration, result *FleetResult) (err error) {
+		result.History, err = m.HistoryContext(ctx)
+		return
+	})
+}
@@ -0,0 +1,173 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTargetFailed = errors.New("target failed")
+
+// newTestFleet 目标 t0..t(n-1) 共用 replay，本地版本为 a <- b，数据库版本为 a
+func newTestFleet(t *testing.T, n int, opts ...FleetConfOption) *Fleet {
+	t.Helper()
+	history := Revision{RevisionId: "b", Parents: []string{"a"}, IsHead: true, Path: "b_.py", Doc: "b"}.LogEntry() + "\n" +
+		Revision{RevisionId: "a", Path: "a_.py", Doc: "a"}.LogEntry()
+	replay := NewReplayExecutor().
+		On("db init", Reply{}).
+		On("db history --verbose", Reply{Stdout: history}).
+		On("db current --verbose", Reply{Stdout: Revision{RevisionId: "a", Path: "a_.py", Doc: "a"}.LogEntry()})
+	targets := make([]FleetTarget, n)
+	for i := range targets {
+		targets[i] = FleetTarget{Name: fmt.Sprintf("t%d", i)}
+	}
+	shared := []ConfOption{WithScriptRoot(t.TempDir()), WithExecutor(replay), WithLockEnabled(false), WithAuditEnabled(false)}
+	opts = append([]FleetConfOption{WithTargets(targets...), WithSharedOptions(shared...)}, opts...)
+	return NewFleet(log.New(io.Discard, "", 0), opts...)
+}
+
+// resultStates 每个目标的结果，ok/failed/skipped
+func resultStates(report FleetReport) (states []string) {
+	for _, r := range report.Results {
+		switch {
+		case r.Skipped:
+			states = append(states, r.Target+":skipped")
+		case r.Err != nil:
+			states = append(states, r.Target+":failed")
+		default:
+			states = append(states, r.Target+":ok")
+		}
+	}
+	return
+}
+
+func TestFleetRunFailurePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		opts       []FleetConfOption
+		wantStates []string
+		wantCalled []string
+		// wantBehind 执行成功且落后于 head 的目标数量
+		wantBehind int
+	}{
+		{name: "continue", opts: []FleetConfOption{WithConcurrency(1)},
+			wantStates: []string{"t0:ok", "t1:failed", "t2:ok", "t3:ok"}, wantCalled: []string{"t0", "t1", "t2", "t3"}, wantBehind: 3},
+		{name: "stop", opts: []FleetConfOption{WithConcurrency(1), WithFailurePolicy(FleetStop)},
+			wantStates: []string{"t0:ok", "t1:failed", "t2:skipped", "t3:skipped"}, wantCalled: []string{"t0", "t1"}, wantBehind: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var called []string
+			report, err := newTestFleet(t, 4, tc.opts...).Run(context.Background(), "test", func(ctx context.Context, m Migration, result *FleetResult) error {
+				called = append(called, result.Target)
+				if result.Target == "t1" {
+					return errTargetFailed
+				}
+				return nil
+			})
+			if states := resultStates(report); !reflect.DeepEqual(states, tc.wantStates) {
+				t.Fatalf("results %v, want %v", states, tc.wantStates)
+			}
+			if !reflect.DeepEqual(called, tc.wantCalled) {
+				t.Fatalf("called %v, want %v", called, tc.wantCalled)
+			}
+			var fleetErr *FleetError
+			if !errors.As(err, &fleetErr) || !errors.Is(err, errTargetFailed) || len(fleetErr.Failed) != 1 || fleetErr.Skipped != 4-len(tc.wantCalled) {
+				t.Fatalf("Run() = %v, want a FleetError wrapping the t1 failure", err)
+			}
+			ok := report.Results[0]
+			if ok.Head != "b" || ok.DatabaseRevision != "a" || !reflect.DeepEqual(ok.Pending, []string{"b"}) || len(report.Behind()) != tc.wantBehind {
+				t.Fatalf("result %+v, want head b, database a, pending [b]", ok)
+			}
+		})
+	}
+}
+
+func TestFleetRunConcurrency(t *testing.T) {
+	for _, tc := range []struct {
+		concurrency int
+		want        int
+	}{
+		{concurrency: 2, want: 2},
+		{concurrency: 0, want: 1},
+		{concurrency: 10, want: 6},
+	} {
+		var (
+			mu       sync.Mutex
+			running  int
+			maxInUse int
+		)
+		report, err := newTestFleet(t, 6, WithConcurrency(tc.concurrency)).Run(context.Background(), "test", func(context.Context, Migration, *FleetResult) error {
+			mu.Lock()
+			if running++; running > maxInUse {
+				maxInUse = running
+			}
+			mu.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			return nil
+		})
+		if err != nil || len(report.Results) != 6 {
+			t.Fatalf("Run() = %v, %d results", err, len(report.Results))
+		}
+		if maxInUse != tc.want {
+			t.Errorf("concurrency %d ran %d targets at once, want %d", tc.concurrency, maxInUse, tc.want)
+		}
+	}
+}
+
+func TestFleetRunCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	report, err := newTestFleet(t, 3).Run(ctx, "test", func(context.Context, Migration, *FleetResult) error {
+		t.Error("target run after the context is canceled")
+		return nil
+	})
+	if len(report.Skipped()) != 3 || err == nil {
+		t.Fatalf("Run() = %v, results %v, want all skipped", err, resultStates(report))
+	}
+}
+
+func TestFleetTargets(t *testing.T) {
+	discovered := func(names ...string) FleetDiscoverFunc {
+		return func(context.Context) ([]FleetTarget, error) {
+			var targets []FleetTarget
+			for _, name := range names {
+				targets = append(targets, FleetTarget{Name: name})
+			}
+			return targets, nil
+		}
+	}
+	for _, tc := range []struct {
+		name       string
+		discover   FleetDiscoverFunc
+		wantStates []string
+		wantErr    string
+	}{
+		{name: "discovered", discover: discovered("d0"), wantStates: []string{"t0:ok", "d0:ok"}},
+		{name: "duplicate", discover: discovered("t0"), wantErr: "duplicate fleet target 't0'"},
+		{name: "empty name", discover: discovered(""), wantErr: "fleet target name is empty"},
+		{name: "discover failed", discover: func(context.Context) ([]FleetTarget, error) { return nil, errTargetFailed }, wantErr: "discover fleet targets: target failed"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			report, err := newTestFleet(t, 1, WithDiscover(tc.discover)).ShowDatabaseRevision()
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("ShowDatabaseRevision() = %v, want %q", err, tc.wantErr)
+				}
+				return
+			}
+			if states := resultStates(report); err != nil || !reflect.DeepEqual(states, tc.wantStates) {
+				t.Fatalf("ShowDatabaseRevision() = %v, results %v, want %v", err, states, tc.wantStates)
+			}
+		})
+	}
+}
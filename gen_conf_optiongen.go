@@ -5,14 +5,42 @@ package migration
 
 import (
 	"sync/atomic"
+	"time"
 	"unsafe"
 )
 
 // Conf should use NewConf to initialize it
 type Conf struct {
-	FileName   string `xconf:"file_name" usage:"migration 脚本名"`
-	ScriptRoot string `xconf:"script_root" usage:"migration 脚本根路径"`
-	CommitID   string `xconf:"commit_id" usage:"repo commitID"`
+	FileName             string                   `xconf:"file_name" usage:"migration 脚本名"`
+	ScriptRoot           string                   `xconf:"script_root" usage:"migration 脚本根路径"`
+	CommitID             string                   `xconf:"commit_id" usage:"repo commitID"`
+	Timeout              time.Duration            `xconf:"timeout" usage:"每个阶段的默认超时时间，0表示不超时"`
+	StageTimeouts        map[string]time.Duration `xconf:"stage_timeouts" usage:"按阶段设置的超时时间，key为阶段名，优先于Timeout"`
+	Backend              string                   `xconf:"backend" usage:"迁移后端，可选 alembic(默认，依赖 flask db) 或 native(纯 Go 实现)"`
+	Dsn                  string                   `xconf:"dsn" usage:"数据库连接串，支持 go-sql-driver DSN 或 SQLAlchemy URL，Go 侧连接数据库(创建库、迁移锁、审计等)时需配置 Database 或 Dsn"`
+	Executor             Executor                 `xconf:"executor" usage:"命令执行器，默认在本地进程中执行"`
+	LockEnabled          bool                     `xconf:"lock_enabled" usage:"是否在 Migrate/Upgrade/Downgrade 期间持有迁移锁，避免多个副本并发执行，未配置 Locker 且没有数据库连接配置时告警并跳过"`
+	Locker               Locker                   `xconf:"locker" usage:"迁移锁实现，为 nil 时使用方言默认的实现(MySQL GET_LOCK、PostgreSQL advisory lock、SQLite 锁文件)"`
+	LockName             string                   `xconf:"lock_name" usage:"迁移锁名，为空时使用 migration:<库名>"`
+	LockWaitTimeout      time.Duration            `xconf:"lock_wait_timeout" usage:"获取迁移锁的最长等待时间"`
+	DDLPolicy            string                   `xconf:"ddl_policy" usage:"破坏性 DDL 的处理策略，可选 off、warn(默认，仅告警)、block(拒绝执行)、allowlist(仅允许 DDLAllowRevisions 中的版本)"`
+	DDLAllowRevisions    []string                 `xconf:"ddl_allow_revisions" usage:"allowlist 策略下允许包含破坏性 DDL 的版本号"`
+	DDLLargeTableRows    int64                    `xconf:"ddl_large_table_rows" usage:"行数不小于该值的表视为大表，在大表上创建索引视为破坏性 DDL，不大于0时不检查"`
+	Database             *DSN                     `xconf:"database" usage:"结构化的数据库连接配置，优先于 Dsn，同时用于 Go 侧连接及生成 migration 脚本中的 SQLALCHEMY_DATABASE_URI"`
+	DataMigrations       *DataMigrations          `xconf:"data_migrations" usage:"数据迁移注册表，为 nil 时使用 DefaultDataMigrations"`
+	OSCTableRows         int64                    `xconf:"osc_table_rows" usage:"行数不小于该值的表上的 ALTER TABLE 在 Upgrade 时以在线表结构变更(影子表+触发器+分批复制+RENAME)执行，不大于0时不按行数选择"`
+	OSCFilter            OSCFilter                `xconf:"osc_filter" usage:"按语句选择是否以在线表结构变更执行 ALTER TABLE，非 nil 时优先于 OSCTableRows"`
+	OSCChunkSize         int                      `xconf:"osc_chunk_size" usage:"在线表结构变更每批复制的行数"`
+	OSCMaxReplicationLag time.Duration            `xconf:"osc_max_replication_lag" usage:"在线表结构变更时 OSCReplicas 中任一从库的复制延迟超过该值则暂停复制，不大于0时不检查"`
+	OSCReplicas          []*DSN                   `xconf:"osc_replicas" usage:"在线表结构变更时检查复制延迟的从库"`
+	OSCProgress          OSCProgressFunc          `xconf:"osc_progress" usage:"在线表结构变更的进度回调，为 nil 时每完成 10% 输出一次日志"`
+	AuditEnabled         bool                     `xconf:"audit_enabled" usage:"是否将 Migrate/Upgrade/Downgrade 的执行记录写入 migration_audit_log 表"`
+	Operator             string                   `xconf:"operator" usage:"审计记录中的操作人，为空时使用当前系统用户"`
+	LogHandler           Handler                  `xconf:"log_handler" usage:"日志处理器，为空时以 New 传入的 log.Logger 输出默认格式的日志，可使用 NewJSONHandler、NewSlogHandler"`
+	Hooks                []Hook                   `xconf:"hooks" usage:"迁移事件的 Hook，按顺序同步调用，可在阶段或版本开始时否决执行"`
+	RewriteScript        bool                     `xconf:"rewrite_script" usage:"配置了 Database/Dsn 时，是否在 prepare 时为不支持 MIGRATION_DATABASE_URI 环境变量覆盖的旧 migration 脚本加上覆盖，关闭时旧脚本需先执行 Generate 重新生成"`
+	Metrics              *Metrics                 `xconf:"metrics" usage:"Prometheus 指标，非 nil 时 Migrate/Upgrade/Downgrade/Stamp 直接更新其中的指标，database 标签为 MetricsDatabase"`
+	MetricsDatabase      string                   `xconf:"metrics_database" usage:"指标的 database 标签，为空时使用 Database/Dsn 中的库名"`
 }
 
 // NewConf new Conf
@@ -69,6 +97,249 @@ func WithCommitID(v string) ConfOption {
 	}
 }
 
+// WithTimeout 每个阶段的默认超时时间，0表示不超时
+func WithTimeout(v time.Duration) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.Timeout
+		cc.Timeout = v
+		return WithTimeout(previous)
+	}
+}
+
+// WithStageTimeouts 按阶段设置的超时时间，key为阶段名，优先于Timeout
+func WithStageTimeouts(v map[string]time.Duration) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.StageTimeouts
+		cc.StageTimeouts = v
+		return WithStageTimeouts(previous)
+	}
+}
+
+// WithBackend 迁移后端，可选 alembic(默认，依赖 flask db) 或 native(纯 Go 实现)
+func WithBackend(v string) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.Backend
+		cc.Backend = v
+		return WithBackend(previous)
+	}
+}
+
+// WithDsn 数据库连接串，支持 go-sql-driver DSN 或 SQLAlchemy URL，Go 侧连接数据库(创建库、迁移锁、审计等)时需配置 Database 或 Dsn
+func WithDsn(v string) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.Dsn
+		cc.Dsn = v
+		return WithDsn(previous)
+	}
+}
+
+// WithExecutor 命令执行器，默认在本地进程中执行
+func WithExecutor(v Executor) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.Executor
+		cc.Executor = v
+		return WithExecutor(previous)
+	}
+}
+
+// WithLockEnabled 是否在 Migrate/Upgrade/Downgrade 期间持有迁移锁，避免多个副本并发执行，未配置 Locker 且没有数据库连接配置时告警并跳过
+func WithLockEnabled(v bool) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.LockEnabled
+		cc.LockEnabled = v
+		return WithLockEnabled(previous)
+	}
+}
+
+// WithLocker 迁移锁实现，为 nil 时使用方言默认的实现(MySQL GET_LOCK、PostgreSQL advisory lock、SQLite 锁文件)
+func WithLocker(v Locker) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.Locker
+		cc.Locker = v
+		return WithLocker(previous)
+	}
+}
+
+// WithLockName 迁移锁名，为空时使用 migration:<库名>
+func WithLockName(v string) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.LockName
+		cc.LockName = v
+		return WithLockName(previous)
+	}
+}
+
+// WithLockWaitTimeout 获取迁移锁的最长等待时间
+func WithLockWaitTimeout(v time.Duration) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.LockWaitTimeout
+		cc.LockWaitTimeout = v
+		return WithLockWaitTimeout(previous)
+	}
+}
+
+// WithDDLPolicy 破坏性 DDL 的处理策略，可选 off、warn(默认，仅告警)、block(拒绝执行)、allowlist(仅允许 DDLAllowRevisions 中的版本)
+func WithDDLPolicy(v string) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.DDLPolicy
+		cc.DDLPolicy = v
+		return WithDDLPolicy(previous)
+	}
+}
+
+// WithDDLAllowRevisions allowlist 策略下允许包含破坏性 DDL 的版本号
+func WithDDLAllowRevisions(v ...string) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.DDLAllowRevisions
+		cc.DDLAllowRevisions = v
+		return WithDDLAllowRevisions(previous...)
+	}
+}
+
+// WithDDLLargeTableRows 行数不小于该值的表视为大表，在大表上创建索引视为破坏性 DDL，不大于0时不检查
+func WithDDLLargeTableRows(v int64) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.DDLLargeTableRows
+		cc.DDLLargeTableRows = v
+		return WithDDLLargeTableRows(previous)
+	}
+}
+
+// WithDatabase 结构化的数据库连接配置，优先于 Dsn，同时用于 Go 侧连接及生成 migration 脚本中的 SQLALCHEMY_DATABASE_URI
+func WithDatabase(v *DSN) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.Database
+		cc.Database = v
+		return WithDatabase(previous)
+	}
+}
+
+// WithDataMigrations 数据迁移注册表，为 nil 时使用 DefaultDataMigrations
+func WithDataMigrations(v *DataMigrations) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.DataMigrations
+		cc.DataMigrations = v
+		return WithDataMigrations(previous)
+	}
+}
+
+// WithOSCTableRows 行数不小于该值的表上的 ALTER TABLE 在 Upgrade 时以在线表结构变更(影子表+触发器+分批复制+RENAME)执行，不大于0时不按行数选择
+func WithOSCTableRows(v int64) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.OSCTableRows
+		cc.OSCTableRows = v
+		return WithOSCTableRows(previous)
+	}
+}
+
+// WithOSCFilter 按语句选择是否以在线表结构变更执行 ALTER TABLE，非 nil 时优先于 OSCTableRows
+func WithOSCFilter(v OSCFilter) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.OSCFilter
+		cc.OSCFilter = v
+		return WithOSCFilter(previous)
+	}
+}
+
+// WithOSCChunkSize 在线表结构变更每批复制的行数
+func WithOSCChunkSize(v int) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.OSCChunkSize
+		cc.OSCChunkSize = v
+		return WithOSCChunkSize(previous)
+	}
+}
+
+// WithOSCMaxReplicationLag 在线表结构变更时 OSCReplicas 中任一从库的复制延迟超过该值则暂停复制，不大于0时不检查
+func WithOSCMaxReplicationLag(v time.Duration) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.OSCMaxReplicationLag
+		cc.OSCMaxReplicationLag = v
+		return WithOSCMaxReplicationLag(previous)
+	}
+}
+
+// WithOSCReplicas 在线表结构变更时检查复制延迟的从库
+func WithOSCReplicas(v ...*DSN) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.OSCReplicas
+		cc.OSCReplicas = v
+		return WithOSCReplicas(previous...)
+	}
+}
+
+// WithOSCProgress 在线表结构变更的进度回调，为 nil 时每完成 10% 输出一次日志
+func WithOSCProgress(v OSCProgressFunc) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.OSCProgress
+		cc.OSCProgress = v
+		return WithOSCProgress(previous)
+	}
+}
+
+// WithAuditEnabled 是否将 Migrate/Upgrade/Downgrade 的执行记录写入 migration_audit_log 表
+func WithAuditEnabled(v bool) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.AuditEnabled
+		cc.AuditEnabled = v
+		return WithAuditEnabled(previous)
+	}
+}
+
+// WithOperator 审计记录中的操作人，为空时使用当前系统用户
+func WithOperator(v string) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.Operator
+		cc.Operator = v
+		return WithOperator(previous)
+	}
+}
+
+// WithLogHandler 日志处理器，为空时以 New 传入的 log.Logger 输出默认格式的日志，可使用 NewJSONHandler、NewSlogHandler
+func WithLogHandler(v Handler) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.LogHandler
+		cc.LogHandler = v
+		return WithLogHandler(previous)
+	}
+}
+
+// WithHooks 迁移事件的 Hook，按顺序同步调用，可在阶段或版本开始时否决执行
+func WithHooks(v ...Hook) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.Hooks
+		cc.Hooks = v
+		return WithHooks(previous...)
+	}
+}
+
+// WithRewriteScript 配置了 Database/Dsn 时，是否在 prepare 时为不支持 MIGRATION_DATABASE_URI 环境变量覆盖的旧 migration 脚本加上覆盖，关闭时旧脚本需先执行 Generate 重新生成
+func WithRewriteScript(v bool) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.RewriteScript
+		cc.RewriteScript = v
+		return WithRewriteScript(previous)
+	}
+}
+
+// WithMetrics Prometheus 指标，非 nil 时 Migrate/Upgrade/Downgrade/Stamp 直接更新其中的指标，database 标签为 MetricsDatabase
+func WithMetrics(v *Metrics) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.Metrics
+		cc.Metrics = v
+		return WithMetrics(previous)
+	}
+}
+
+// WithMetricsDatabase 指标的 database 标签，为空时使用 Database/Dsn 中的库名
+func WithMetricsDatabase(v string) ConfOption {
+	return func(cc *Conf) ConfOption {
+		previous := cc.MetricsDatabase
+		cc.MetricsDatabase = v
+		return WithMetricsDatabase(previous)
+	}
+}
+
 // InstallConfWatchDog the installed func will called when NewConf  called
 func InstallConfWatchDog(dog func(cc *Conf)) { watchDogConf = dog }
 
@@ -83,6 +354,33 @@ func newDefaultConf() *Conf {
 		WithFileName("migration"),
 		WithScriptRoot("."),
 		WithCommitID(""),
+		WithTimeout(time.Duration(0)),
+		WithStageTimeouts(nil),
+		WithBackend(BackendAlembic),
+		WithDsn(""),
+		WithExecutor(NewLocalExecutor()),
+		WithLockEnabled(true),
+		WithLocker(nil),
+		WithLockName(""),
+		WithLockWaitTimeout(time.Minute),
+		WithDDLPolicy(DDLPolicyWarn),
+		WithDDLAllowRevisions(nil...),
+		WithDDLLargeTableRows(1000000),
+		WithDatabase(nil),
+		WithDataMigrations(nil),
+		WithOSCTableRows(0),
+		WithOSCFilter(nil),
+		WithOSCChunkSize(1000),
+		WithOSCMaxReplicationLag(time.Second * 10),
+		WithOSCReplicas(nil...),
+		WithOSCProgress(nil),
+		WithAuditEnabled(true),
+		WithOperator(""),
+		WithLogHandler(nil),
+		WithHooks(nil...),
+		WithRewriteScript(false),
+		WithMetrics(nil),
+		WithMetricsDatabase(""),
 	} {
 		opt(cc)
 	}
@@ -128,15 +426,69 @@ func AtomicConf() ConfVisitor {
 }
 
 // all getter func
-func (cc *Conf) GetFileName() string   { return cc.FileName }
-func (cc *Conf) GetScriptRoot() string { return cc.ScriptRoot }
-func (cc *Conf) GetCommitID() string   { return cc.CommitID }
+func (cc *Conf) GetFileName() string                        { return cc.FileName }
+func (cc *Conf) GetScriptRoot() string                      { return cc.ScriptRoot }
+func (cc *Conf) GetCommitID() string                        { return cc.CommitID }
+func (cc *Conf) GetTimeout() time.Duration                  { return cc.Timeout }
+func (cc *Conf) GetStageTimeouts() map[string]time.Duration { return cc.StageTimeouts }
+func (cc *Conf) GetBackend() string                         { return cc.Backend }
+func (cc *Conf) GetDsn() string                             { return cc.Dsn }
+func (cc *Conf) GetExecutor() Executor                      { return cc.Executor }
+func (cc *Conf) GetLockEnabled() bool                       { return cc.LockEnabled }
+func (cc *Conf) GetLocker() Locker                          { return cc.Locker }
+func (cc *Conf) GetLockName() string                        { return cc.LockName }
+func (cc *Conf) GetLockWaitTimeout() time.Duration          { return cc.LockWaitTimeout }
+func (cc *Conf) GetDDLPolicy() string                       { return cc.DDLPolicy }
+func (cc *Conf) GetDDLAllowRevisions() []string             { return cc.DDLAllowRevisions }
+func (cc *Conf) GetDDLLargeTableRows() int64                { return cc.DDLLargeTableRows }
+func (cc *Conf) GetDatabase() *DSN                          { return cc.Database }
+func (cc *Conf) GetDataMigrations() *DataMigrations         { return cc.DataMigrations }
+func (cc *Conf) GetOSCTableRows() int64                     { return cc.OSCTableRows }
+func (cc *Conf) GetOSCFilter() OSCFilter                    { return cc.OSCFilter }
+func (cc *Conf) GetOSCChunkSize() int                       { return cc.OSCChunkSize }
+func (cc *Conf) GetOSCMaxReplicationLag() time.Duration     { return cc.OSCMaxReplicationLag }
+func (cc *Conf) GetOSCReplicas() []*DSN                     { return cc.OSCReplicas }
+func (cc *Conf) GetOSCProgress() OSCProgressFunc            { return cc.OSCProgress }
+func (cc *Conf) GetAuditEnabled() bool                      { return cc.AuditEnabled }
+func (cc *Conf) GetOperator() string                        { return cc.Operator }
+func (cc *Conf) GetLogHandler() Handler                     { return cc.LogHandler }
+func (cc *Conf) GetHooks() []Hook                           { return cc.Hooks }
+func (cc *Conf) GetRewriteScript() bool                     { return cc.RewriteScript }
+func (cc *Conf) GetMetrics() *Metrics                       { return cc.Metrics }
+func (cc *Conf) GetMetricsDatabase() string                 { return cc.MetricsDatabase }
 
 // ConfVisitor visitor interface for Conf
 type ConfVisitor interface {
 	GetFileName() string
 	GetScriptRoot() string
 	GetCommitID() string
+	GetTimeout() time.Duration
+	GetStageTimeouts() map[string]time.Duration
+	GetBackend() string
+	GetDsn() string
+	GetExecutor() Executor
+	GetLockEnabled() bool
+	GetLocker() Locker
+	GetLockName() string
+	GetLockWaitTimeout() time.Duration
+	GetDDLPolicy() string
+	GetDDLAllowRevisions() []string
+	GetDDLLargeTableRows() int64
+	GetDatabase() *DSN
+	GetDataMigrations() *DataMigrations
+	GetOSCTableRows() int64
+	GetOSCFilter() OSCFilter
+	GetOSCChunkSize() int
+	GetOSCMaxReplicationLag() time.Duration
+	GetOSCReplicas() []*DSN
+	GetOSCProgress() OSCProgressFunc
+	GetAuditEnabled() bool
+	GetOperator() string
+	GetLogHandler() Handler
+	GetHooks() []Hook
+	GetRewriteScript() bool
+	GetMetrics() *Metrics
+	GetMetricsDatabase() string
 }
 
 // ConfInterface visitor + ApplyOption interface for Conf
@@ -10,13 +10,19 @@ import (
 
 // GenerateConf should use NewGenerateConf to initialize it
 type GenerateConf struct {
-	MysqlDbName           string `xconf:"mysql_db_name" usage:"migration db名"`
-	MysqlUser             string `xconf:"mysql_user" usage:"migration 数据库用户名"`
-	MysqlPassword         string `xconf:"mysql_password" usage:"migration 数据库用户密码"`
-	MysqlHost             string `xconf:"mysql_host" usage:"migration 数据库地址"`
-	MysqlPort             int    `xconf:"mysql_port" usage:"migration 数据库端口号"`
+	MysqlDbName           string `xconf:"mysql_db_name" usage:"Deprecated: 使用 DbName，DbName 为空时生效"`
+	MysqlUser             string `xconf:"mysql_user" usage:"Deprecated: 使用 DbUser，DbUser 为空时生效"`
+	MysqlPassword         string `xconf:"mysql_password" usage:"Deprecated: 使用 DbPassword，DbPassword 为空时生效"`
+	MysqlHost             string `xconf:"mysql_host" usage:"Deprecated: 使用 DbHost，DbHost 为空时生效"`
+	MysqlPort             int    `xconf:"mysql_port" usage:"Deprecated: 使用 DbPort，DbPort 为 0 时生效"`
 	ProtokitGoSettingPath string `xconf:"protokit_go_setting_path" usage:"protokitgo 配置文件路径"`
 	ProtokitPath          string `xconf:"protokit_path" usage:"protokitgo 路径"`
+	Dialect               string `xconf:"dialect" usage:"数据库方言，可选 mysql(默认)、postgres、sqlite，Db* 配置用于所有方言"`
+	DbName                string `xconf:"db_name" usage:"数据库名，sqlite 下为库文件路径，为空时使用 MysqlDbName，均为空时为 migration"`
+	DbUser                string `xconf:"db_user" usage:"数据库用户名，为空时使用 MysqlUser，均为空时为 root"`
+	DbPassword            string `xconf:"db_password" usage:"数据库用户密码，为空时使用 MysqlPassword"`
+	DbHost                string `xconf:"db_host" usage:"数据库地址，为空时使用 MysqlHost，均为空时为 127.0.0.1"`
+	DbPort                int    `xconf:"db_port" usage:"数据库端口号，为 0 时使用 MysqlPort，均为 0 时使用方言的默认端口"`
 }
 
 // NewGenerateConf new GenerateConf
@@ -46,7 +52,7 @@ func (cc *GenerateConf) ApplyOption(opts ...GenerateConfOption) []GenerateConfOp
 // GenerateConfOption option func
 type GenerateConfOption func(cc *GenerateConf) GenerateConfOption
 
-// WithMysqlDbName migration db名
+// WithMysqlDbName Deprecated: 使用 DbName，DbName 为空时生效
 func WithMysqlDbName(v string) GenerateConfOption {
 	return func(cc *GenerateConf) GenerateConfOption {
 		previous := cc.MysqlDbName
@@ -55,7 +61,7 @@ func WithMysqlDbName(v string) GenerateConfOption {
 	}
 }
 
-// WithMysqlUser migration 数据库用户名
+// WithMysqlUser Deprecated: 使用 DbUser，DbUser 为空时生效
 func WithMysqlUser(v string) GenerateConfOption {
 	return func(cc *GenerateConf) GenerateConfOption {
 		previous := cc.MysqlUser
@@ -64,7 +70,7 @@ func WithMysqlUser(v string) GenerateConfOption {
 	}
 }
 
-// WithMysqlPassword migration 数据库用户密码
+// WithMysqlPassword Deprecated: 使用 DbPassword，DbPassword 为空时生效
 func WithMysqlPassword(v string) GenerateConfOption {
 	return func(cc *GenerateConf) GenerateConfOption {
 		previous := cc.MysqlPassword
@@ -73,7 +79,7 @@ func WithMysqlPassword(v string) GenerateConfOption {
 	}
 }
 
-// WithMysqlHost migration 数据库地址
+// WithMysqlHost Deprecated: 使用 DbHost，DbHost 为空时生效
 func WithMysqlHost(v string) GenerateConfOption {
 	return func(cc *GenerateConf) GenerateConfOption {
 		previous := cc.MysqlHost
@@ -82,7 +88,7 @@ func WithMysqlHost(v string) GenerateConfOption {
 	}
 }
 
-// WithMysqlPort migration 数据库端口号
+// WithMysqlPort Deprecated: 使用 DbPort，DbPort 为 0 时生效
 func WithMysqlPort(v int) GenerateConfOption {
 	return func(cc *GenerateConf) GenerateConfOption {
 		previous := cc.MysqlPort
@@ -109,6 +115,60 @@ func WithProtokitPath(v string) GenerateConfOption {
 	}
 }
 
+// WithDialect 数据库方言，可选 mysql(默认)、postgres、sqlite，Db* 配置用于所有方言
+func WithDialect(v string) GenerateConfOption {
+	return func(cc *GenerateConf) GenerateConfOption {
+		previous := cc.Dialect
+		cc.Dialect = v
+		return WithDialect(previous)
+	}
+}
+
+// WithDbName 数据库名，sqlite 下为库文件路径，为空时使用 MysqlDbName，均为空时为 migration
+func WithDbName(v string) GenerateConfOption {
+	return func(cc *GenerateConf) GenerateConfOption {
+		previous := cc.DbName
+		cc.DbName = v
+		return WithDbName(previous)
+	}
+}
+
+// WithDbUser 数据库用户名，为空时使用 MysqlUser，均为空时为 root
+func WithDbUser(v string) GenerateConfOption {
+	return func(cc *GenerateConf) GenerateConfOption {
+		previous := cc.DbUser
+		cc.DbUser = v
+		return WithDbUser(previous)
+	}
+}
+
+// WithDbPassword 数据库用户密码，为空时使用 MysqlPassword
+func WithDbPassword(v string) GenerateConfOption {
+	return func(cc *GenerateConf) GenerateConfOption {
+		previous := cc.DbPassword
+		cc.DbPassword = v
+		return WithDbPassword(previous)
+	}
+}
+
+// WithDbHost 数据库地址，为空时使用 MysqlHost，均为空时为 127.0.0.1
+func WithDbHost(v string) GenerateConfOption {
+	return func(cc *GenerateConf) GenerateConfOption {
+		previous := cc.DbHost
+		cc.DbHost = v
+		return WithDbHost(previous)
+	}
+}
+
+// WithDbPort 数据库端口号，为 0 时使用 MysqlPort，均为 0 时使用方言的默认端口
+func WithDbPort(v int) GenerateConfOption {
+	return func(cc *GenerateConf) GenerateConfOption {
+		previous := cc.DbPort
+		cc.DbPort = v
+		return WithDbPort(previous)
+	}
+}
+
 // InstallGenerateConfWatchDog the installed func will called when NewGenerateConf  called
 func InstallGenerateConfWatchDog(dog func(cc *GenerateConf)) { watchDogGenerateConf = dog }
 
@@ -120,13 +180,19 @@ func newDefaultGenerateConf() *GenerateConf {
 	cc := &GenerateConf{}
 
 	for _, opt := range [...]GenerateConfOption{
-		WithMysqlDbName("migration"),
-		WithMysqlUser("root"),
+		WithMysqlDbName(""),
+		WithMysqlUser(""),
 		WithMysqlPassword(""),
-		WithMysqlHost("127.0.0.1"),
-		WithMysqlPort(3306),
+		WithMysqlHost(""),
+		WithMysqlPort(0),
 		WithProtokitGoSettingPath(""),
 		WithProtokitPath(""),
+		WithDialect("mysql"),
+		WithDbName(""),
+		WithDbUser(""),
+		WithDbPassword(""),
+		WithDbHost(""),
+		WithDbPort(0),
 	} {
 		opt(cc)
 	}
@@ -181,6 +247,12 @@ func (cc *GenerateConf) GetMysqlHost() string             { return cc.MysqlHost
 func (cc *GenerateConf) GetMysqlPort() int                { return cc.MysqlPort }
 func (cc *GenerateConf) GetProtokitGoSettingPath() string { return cc.ProtokitGoSettingPath }
 func (cc *GenerateConf) GetProtokitPath() string          { return cc.ProtokitPath }
+func (cc *GenerateConf) GetDialect() string               { return cc.Dialect }
+func (cc *GenerateConf) GetDbName() string                { return cc.DbName }
+func (cc *GenerateConf) GetDbUser() string                { return cc.DbUser }
+func (cc *GenerateConf) GetDbPassword() string            { return cc.DbPassword }
+func (cc *GenerateConf) GetDbHost() string                { return cc.DbHost }
+func (cc *GenerateConf) GetDbPort() int                   { return cc.DbPort }
 
 // GenerateConfVisitor visitor interface for GenerateConf
 type GenerateConfVisitor interface {
@@ -191,6 +263,12 @@ type GenerateConfVisitor interface {
 	GetMysqlPort() int
 	GetProtokitGoSettingPath() string
 	GetProtokitPath() string
+	GetDialect() string
+	GetDbName() string
+	GetDbUser() string
+	GetDbPassword() string
+	GetDbHost() string
+	GetDbPort() int
 }
 
 // GenerateConfInterface visitor + ApplyOption interface for GenerateConf
@@ -0,0 +1,245 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MultipleHeadsError 版本图中存在多个 head，需要先合并(flask db merge)才能升级
+type MultipleHeadsError struct {
+	Heads []string
+}
+
+func (e *MultipleHeadsError) Is(target error) bool { return target == ErrMultipleHeads }
+
+func (e *MultipleHeadsError) Error() string {
+	return fmt.Sprintf("multiple head revisions are present: %s, merge them before upgrading", strings.Join(e.Heads, ", "))
+}
+
+// NewRevisionGraphFromHistory 以 m.History 的结果构建版本图
+func NewRevisionGraphFromHistory(ctx context.Context, m Migration) (*RevisionGraph, error) {
+	revisions, err := m.HistoryContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewRevisionGraph(revisions)
+}
+
+// RevisionGraph 版本的有向无环图，由 History 的结果构建
+type RevisionGraph struct {
+	revisions map[string]Revision
+	index     map[string]int
+	parents   map[string][]string
+	children  map[string][]string
+	order     []string
+}
+
+// NewRevisionGraph 由版本列表构建版本图
+func NewRevisionGraph(revisions []Revision) (*RevisionGraph, error) {
+	g := &RevisionGraph{
+		revisions: make(map[string]Revision, len(revisions)),
+		index:     make(map[string]int, len(revisions)),
+		parents:   make(map[string][]string, len(revisions)),
+		children:  make(map[string][]string, len(revisions)),
+	}
+	for i, r := range revisions {
+		id := r.RevisionId
+		if id == "" {
+			return nil, fmt.Errorf("revision without id at index %d", i)
+		}
+		if _, ok := g.revisions[id]; ok {
+			return nil, fmt.Errorf("revision '%s' is present more than once", id)
+		}
+		g.revisions[id] = r
+		g.index[id] = i
+		g.parents[id] = append([]string(nil), r.Parents...)
+	}
+	for _, r := range revisions {
+		id := r.RevisionId
+		for _, p := range g.parents[id] {
+			if _, ok := g.revisions[p]; !ok {
+				return nil, &RevisionNotFoundError{Revision: p, Reason: fmt.Sprintf("revised by '%s'", id)}
+			}
+			g.children[p] = append(g.children[p], id)
+		}
+	}
+	for _, ids := range g.children {
+		g.sort(ids)
+	}
+	if err := g.topological(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *RevisionGraph) sort(ids []string) {
+	sort.Slice(ids, func(i, j int) bool { return g.index[ids[i]] < g.index[ids[j]] })
+}
+
+// topological 计算 base -> head 的拓扑序，同时检测环
+func (g *RevisionGraph) topological() error {
+	pending := make(map[string]int, len(g.revisions))
+	var queue []string
+	for id := range g.revisions {
+		pending[id] = len(g.parents[id])
+		if pending[id] == 0 {
+			queue = append(queue, id)
+		}
+	}
+	g.sort(queue)
+	g.order = g.order[:0]
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		g.order = append(g.order, id)
+		for _, c := range g.children[id] {
+			if pending[c]--; pending[c] == 0 {
+				queue = append(queue, c)
+			}
+		}
+	}
+	if len(g.order) != len(g.revisions) {
+		return fmt.Errorf("cycle detected in revisions")
+	}
+	return nil
+}
+
+// Len 版本数量
+func (g *RevisionGraph) Len() int { return len(g.order) }
+
+// Revision 获取指定版本
+func (g *RevisionGraph) Revision(id string) (Revision, bool) {
+	r, ok := g.revisions[id]
+	return r, ok
+}
+
+// Revisions 按 base -> head 的拓扑序返回所有版本号
+func (g *RevisionGraph) Revisions() []string {
+	return append([]string(nil), g.order...)
+}
+
+// Find 按完整版本号或唯一前缀查找版本
+func (g *RevisionGraph) Find(id string) (string, error) {
+	if _, ok := g.revisions[id]; ok {
+		return id, nil
+	}
+	var found []string
+	for _, rev := range g.order {
+		if strings.HasPrefix(rev, id) {
+			found = append(found, rev)
+		}
+	}
+	if len(found) > 1 {
+		return "", fmt.Errorf("multiple revisions start with '%s': %s", id, strings.Join(found, ", "))
+	}
+	if len(found) == 0 {
+		return "", &RevisionNotFoundError{Revision: id}
+	}
+	return found[0], nil
+}
+
+// Parents 父版本
+func (g *RevisionGraph) Parents(id string) []string { return append([]string(nil), g.parents[id]...) }
+
+// Children 子版本
+func (g *RevisionGraph) Children(id string) []string { return append([]string(nil), g.children[id]...) }
+
+func (g *RevisionGraph) filter(f func(id string) bool) (out []string) {
+	for _, id := range g.order {
+		if f(id) {
+			out = append(out, id)
+		}
+	}
+	return
+}
+
+// Heads 没有子版本的版本
+func (g *RevisionGraph) Heads() []string {
+	return g.filter(func(id string) bool { return len(g.children[id]) == 0 })
+}
+
+// Bases 没有父版本的版本
+func (g *RevisionGraph) Bases() []string {
+	return g.filter(func(id string) bool { return len(g.parents[id]) == 0 })
+}
+
+// BranchPoints 有多个子版本的版本
+func (g *RevisionGraph) BranchPoints() []string {
+	return g.filter(func(id string) bool { return len(g.children[id]) > 1 })
+}
+
+// MergePoints 合并了多个父版本的版本
+func (g *RevisionGraph) MergePoints() []string {
+	return g.filter(func(id string) bool { return len(g.parents[id]) > 1 })
+}
+
+// Head 唯一的 head，存在多个 head 时返回 *MultipleHeadsError，没有版本时返回空
+func (g *RevisionGraph) Head() (string, error) {
+	heads := g.Heads()
+	if len(heads) > 1 {
+		return "", &MultipleHeadsError{Heads: heads}
+	}
+	if len(heads) == 0 {
+		return "", nil
+	}
+	return heads[0], nil
+}
+
+func (g *RevisionGraph) walk(id string, next map[string][]string) map[string]bool {
+	seen := make(map[string]bool)
+	stack := append([]string(nil), next[id]...)
+	for len(stack) > 0 {
+		cur := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[cur] {
+			continue
+		}
+		seen[cur] = true
+		stack = append(stack, next[cur]...)
+	}
+	return seen
+}
+
+// Ancestors 所有祖先版本，按拓扑序返回
+func (g *RevisionGraph) Ancestors(id string) []string {
+	seen := g.walk(id, g.parents)
+	return g.filter(func(id string) bool { return seen[id] })
+}
+
+// Descendants 所有后代版本，按拓扑序返回
+func (g *RevisionGraph) Descendants(id string) []string {
+	seen := g.walk(id, g.children)
+	return g.filter(func(id string) bool { return seen[id] })
+}
+
+// IsAncestor ancestor 是否为 id 的祖先
+func (g *RevisionGraph) IsAncestor(ancestor, id string) bool {
+	return g.walk(id, g.parents)[ancestor]
+}
+
+// Path 从 from 升级到 to 需要依次执行的版本(不包含 from，包含 to)，按拓扑序返回
+// from 为空表示 base；from 必须是 to 的祖先
+func (g *RevisionGraph) Path(from, to string) ([]string, error) {
+	if _, ok := g.revisions[to]; !ok {
+		return nil, &RevisionNotFoundError{Revision: to}
+	}
+	if from == to {
+		return nil, nil
+	}
+	target := g.walk(to, g.parents)
+	target[to] = true
+	applied := make(map[string]bool)
+	if from != "" {
+		if _, ok := g.revisions[from]; !ok {
+			return nil, &RevisionNotFoundError{Revision: from}
+		}
+		if !target[from] {
+			return nil, fmt.Errorf("revision '%s' is not an ancestor of revision '%s'", from, to)
+		}
+		applied = g.walk(from, g.parents)
+		applied[from] = true
+	}
+	return g.filter(func(id string) bool { return target[id] && !applied[id] }), nil
+}
@@ -0,0 +1,135 @@
+package migration
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mergeGraph a <- b <- (c1, c2) <- d <- e，d 合并了 c1、c2 两个分支
+func mergeGraph(t *testing.T) *RevisionGraph {
+	t.Helper()
+	graph, err := NewRevisionGraph([]Revision{
+		{RevisionId: "e", Parents: []string{"d"}},
+		{RevisionId: "d", Parents: []string{"c1", "c2"}},
+		{RevisionId: "c2", Parents: []string{"b"}},
+		{RevisionId: "c1", Parents: []string{"b"}},
+		{RevisionId: "b", Parents: []string{"a"}},
+		{RevisionId: "a"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+
+func TestNewRevisionGraph(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		revisions []Revision
+		wantErr   string
+	}{
+		{name: "empty"},
+		{name: "missing id", revisions: []Revision{{}}, wantErr: "revision without id"},
+		{name: "duplicate", revisions: []Revision{{RevisionId: "a"}, {RevisionId: "a"}}, wantErr: "present more than once"},
+		{name: "missing parent", revisions: []Revision{{RevisionId: "b", Parents: []string{"a"}}}, wantErr: "can't locate revision identified by 'a', revised by 'b'"},
+		{name: "cycle", revisions: []Revision{{RevisionId: "a", Parents: []string{"b"}}, {RevisionId: "b", Parents: []string{"a"}}}, wantErr: "cycle detected"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := NewRevisionGraph(tc.revisions)
+			if tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+				t.Fatalf("NewRevisionGraph() = %v, want %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestRevisionGraphTopology(t *testing.T) {
+	graph := mergeGraph(t)
+	for _, tc := range []struct {
+		name string
+		got  []string
+		want []string
+	}{
+		{name: "revisions", got: graph.Revisions(), want: []string{"a", "b", "c2", "c1", "d", "e"}},
+		{name: "heads", got: graph.Heads(), want: []string{"e"}},
+		{name: "bases", got: graph.Bases(), want: []string{"a"}},
+		{name: "branch points", got: graph.BranchPoints(), want: []string{"b"}},
+		{name: "merge points", got: graph.MergePoints(), want: []string{"d"}},
+		{name: "children", got: graph.Children("b"), want: []string{"c2", "c1"}},
+		{name: "ancestors", got: graph.Ancestors("d"), want: []string{"a", "b", "c2", "c1"}},
+		{name: "descendants", got: graph.Descendants("c1"), want: []string{"d", "e"}},
+	} {
+		if !reflect.DeepEqual(tc.got, tc.want) {
+			t.Errorf("%s = %v, want %v", tc.name, tc.got, tc.want)
+		}
+	}
+	if !graph.IsAncestor("c1", "e") || graph.IsAncestor("c1", "c2") || graph.IsAncestor("e", "e") {
+		t.Error("IsAncestor() mismatch")
+	}
+}
+
+func TestRevisionGraphHead(t *testing.T) {
+	graph, err := NewRevisionGraph([]Revision{{RevisionId: "c", Parents: []string{"a"}}, {RevisionId: "b", Parents: []string{"a"}}, {RevisionId: "a"}, {RevisionId: "x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bases := graph.Bases(); !reflect.DeepEqual(bases, []string{"a", "x"}) {
+		t.Fatalf("Bases() = %v, want [a x]", bases)
+	}
+	_, err = graph.Head()
+	var heads *MultipleHeadsError
+	if !errors.As(err, &heads) || !errors.Is(err, ErrMultipleHeads) || !reflect.DeepEqual(heads.Heads, []string{"x", "c", "b"}) {
+		t.Fatalf("Head() = %v, want multiple heads x, c, b", err)
+	}
+	empty, _ := NewRevisionGraph(nil)
+	if head, err := empty.Head(); head != "" || err != nil {
+		t.Fatalf("Head() of empty graph = %q, %v", head, err)
+	}
+}
+
+func TestRevisionGraphFind(t *testing.T) {
+	graph := mergeGraph(t)
+	for _, tc := range []struct {
+		id      string
+		want    string
+		wantErr string
+	}{
+		{id: "c1", want: "c1"},
+		{id: "d", want: "d"},
+		{id: "c", wantErr: "multiple revisions start with 'c': c2, c1"},
+		{id: "z", wantErr: "can't locate revision identified by 'z'"},
+	} {
+		got, err := graph.Find(tc.id)
+		if got != tc.want || tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || err.Error() != tc.wantErr) {
+			t.Errorf("Find(%q) = %q, %v, want %q, %q", tc.id, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
+
+func TestRevisionGraphPath(t *testing.T) {
+	graph := mergeGraph(t)
+	for _, tc := range []struct {
+		from, to string
+		want     []string
+		wantErr  string
+	}{
+		{from: "", to: "e", want: []string{"a", "b", "c2", "c1", "d", "e"}},
+		{from: "b", to: "e", want: []string{"c2", "c1", "d", "e"}},
+		// 跨越合并点时包含另一个分支上尚未执行的版本
+		{from: "c1", to: "e", want: []string{"c2", "d", "e"}},
+		{from: "c2", to: "d", want: []string{"c1", "d"}},
+		{from: "", to: "c1", want: []string{"a", "b", "c1"}},
+		{from: "d", to: "d"},
+		{from: "c1", to: "c2", wantErr: "revision 'c1' is not an ancestor of revision 'c2'"},
+		{from: "e", to: "b", wantErr: "revision 'e' is not an ancestor of revision 'b'"},
+		{from: "z", to: "e", wantErr: "can't locate revision identified by 'z'"},
+		{from: "", to: "z", wantErr: "can't locate revision identified by 'z'"},
+	} {
+		got, err := graph.Path(tc.from, tc.to)
+		if !reflect.DeepEqual(got, tc.want) || tc.wantErr == "" && err != nil || tc.wantErr != "" && (err == nil || err.Error() != tc.wantErr) {
+			t.Errorf("Path(%q, %q) = %v, %v, want %v, %q", tc.from, tc.to, got, err, tc.want, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// EventType 迁移过程中的事件类型
+type EventType string
+
+const (
+	// EventStageStart 阶段开始，Hook 返回错误时否决该阶段
+	EventStageStart EventType = "stage_start"
+	// EventStageEnd 阶段结束，Err 为阶段的执行结果
+	EventStageEnd EventType = "stage_end"
+	// EventRevisionStart 版本执行之前，Hook 返回错误时否决该版本及之后的版本
+	EventRevisionStart EventType = "revision_start"
+	// EventRevisionApplied 版本执行完成
+	EventRevisionApplied EventType = "revision_applied"
+	// EventWarning 不影响执行结果的告警，如审计记录写入失败、回退没有 Down 函数的数据迁移
+	EventWarning EventType = "warning"
+	// EventError 阶段执行失败
+	EventError EventType = "error"
+)
+
+// Event 迁移过程中的事件
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Stage 事件所属的阶段，版本事件为 StageUpgrade 或 StageDowngrade
+	Stage string
+	// From/To 版本事件中执行前后的版本号，base 为空
+	From string
+	To   string
+	// Duration 阶段结束、版本执行完成事件的耗时
+	Duration time.Duration
+	// Message 告警的内容
+	Message string
+	// Fields 告警的日志字段
+	Fields []Field
+	// Err 阶段结束、失败事件的错误
+	Err error
+}
+
+// Vetoable 事件是否可被 Hook 否决
+func (e Event) Vetoable() bool {
+	return e.Type == EventStageStart || e.Type == EventRevisionStart
+}
+
+func (e Event) String() string {
+	s := string(e.Type)
+	if e.Stage != "" {
+		s += " " + e.Stage
+	}
+	if e.Type == EventRevisionStart || e.Type == EventRevisionApplied {
+		s += fmt.Sprintf(" %s -> %s", orBase(e.From), orBase(e.To))
+	}
+	if e.Message != "" {
+		s += ": " + e.Message
+	}
+	if e.Err != nil {
+		s += fmt.Sprintf(", error: %v", e.Err)
+	}
+	return s
+}
+
+// Hook 同步接收迁移事件，在可否决的事件中返回错误时终止操作，其他事件中返回的错误只输出告警
+type Hook func(ctx context.Context, e Event) error
+
+// emit 通知 Hook，可否决的事件返回 *VetoError，其他事件中 Hook 返回的错误只输出告警
+func (g *core) emit(ctx context.Context, e Event) error {
+	err := g.notify(ctx, e)
+	if err == nil || e.Vetoable() {
+		return err
+	}
+	g.logger.LogContext(ctx, LevelWarn, "hook failed", stageField(e.Stage), Field{Key: "event", Value: e.String()}, Field{Key: FieldError, Value: err})
+	return nil
+}
+
+// notify 依次调用 Hook，可否决的事件在第一个返回错误的 Hook 处停止，其他事件调用所有 Hook 并返回第一个错误
+func (g *core) notify(ctx context.Context, e Event) (err error) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	for _, hook := range g.conf.GetHooks() {
+		hookErr := hook(ctx, e)
+		if hookErr == nil {
+			continue
+		}
+		if e.Vetoable() {
+			return &VetoError{Event: e, Err: hookErr}
+		}
+		if err == nil {
+			err = hookErr
+		}
+	}
+	return
+}
+
+// beforeRevision 版本执行之前通知 Hook
+func (g *core) beforeRevision(ctx context.Context, stage, from, to string) error {
+	return g.emit(ctx, Event{Type: EventRevisionStart, Stage: stage, From: from, To: to})
+}
+
+// revisionApplied 版本执行完成后通知 Hook 并更新 Metrics
+func (g *core) revisionApplied(ctx context.Context, stage, from, to string, start time.Time) {
+	g.observeApplied(stage, 1)
+	_ = g.emit(ctx, Event{Type: EventRevisionApplied, Stage: stage, From: from, To: to, Duration: time.Since(start)})
+}
+
+// hookHandler 将 Warn 级别的日志转换为 EventWarning，并更新 Metrics 中的告警数，
+// 以 LogRecord.Context 调用 Hook，Event.Stage 为日志的 stage 字段
+type hookHandler struct {
+	Handler
+	g *core
+}
+
+func (h *hookHandler) Enabled(level Level) bool {
+	return h.Handler.Enabled(level) || isWarning(level)
+}
+
+func (h *hookHandler) Handle(r LogRecord) (err error) {
+	if h.Handler.Enabled(r.Level) {
+		err = h.Handler.Handle(r)
+	}
+	if !isWarning(r.Level) {
+		return
+	}
+	h.g.observeWarning()
+	stage, _ := r.field(FieldStage).(string)
+	e := Event{Type: EventWarning, Time: r.Time, Stage: stage, Message: r.Message, Fields: r.Fields}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	// 告警事件的 Hook 失败时直接输出，不再触发告警事件
+	if hookErr := h.g.notify(ctx, e); hookErr != nil {
+		_ = h.Handler.Handle(LogRecord{Time: time.Now(), Level: LevelWarn, Message: "hook failed",
+			Fields: []Field{{Key: "event", Value: e.String()}, {Key: FieldError, Value: hookErr}}})
+	}
+	return
+}
+
+func isWarning(level Level) bool { return level >= LevelWarn && level < LevelError }
@@ -0,0 +1,59 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type hookTestKey struct{}
+
+func TestHookWarningEvent(t *testing.T) {
+	var events []Event
+	var values []interface{}
+	hook := func(ctx context.Context, e Event) error {
+		if e.Type != EventWarning {
+			return nil
+		}
+		events = append(events, e)
+		values = append(values, ctx.Value(hookTestKey{}))
+		return errors.New("hook failed")
+	}
+	h := &recordHandler{}
+	g := newCore(nil, WithScriptRoot(t.TempDir()), WithHooks(hook), WithLogHandler(h))
+
+	// 操作中的告警以操作的 ctx 调用 Hook
+	ctx := context.WithValue(context.Background(), hookTestKey{}, "op")
+	unlock, err := g.lock(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlock()
+	// 不带 context 的告警以 context.Background() 调用 Hook
+	g.logger.WarnWithFlag("plain warning", stageField(StagePlan))
+
+	if len(events) != 2 {
+		t.Fatalf("events %+v, want 2 warnings", events)
+	}
+	for i, want := range []struct {
+		stage, message string
+		value          interface{}
+	}{
+		{stage: StageLock, message: "skip migration lock", value: "op"},
+		{stage: StagePlan, message: "plain warning"},
+	} {
+		if events[i].Stage != want.stage || events[i].Message != want.message || values[i] != want.value {
+			t.Errorf("event %d = %+v with ctx value %v, want stage %s, message %q, ctx value %v", i, events[i], values[i], want.stage, want.message, want.value)
+		}
+	}
+	// Hook 失败时直接输出，不再触发告警事件
+	var failed int
+	for _, r := range h.records {
+		if r.Message == "hook failed" {
+			failed++
+		}
+	}
+	if failed != 2 {
+		t.Fatalf("records %+v, want 2 hook failures", h.records)
+	}
+}
@@ -0,0 +1,203 @@
+package migration
+
+import (
+	"context"
+	"crypto/md5"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/go-sql-driver/mysql"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	lockOwnerVariable = "migration_lock_owner"
+	lockSinceVariable = "migration_lock_since"
+	// mysql 锁名最长 64 个字符
+	mysqlLockNameMaxLen = 64
+)
+
+// Locker 迁移锁，保证多个副本同时启动时只有一个执行 Migrate/Upgrade/Downgrade
+type Locker interface {
+	// Lock 以 owner 的身份获取名为 key 的锁，最多等待 wait
+	// 锁被其他持有者占用且等待超时时，应返回 *LockHeldError
+	Lock(ctx context.Context, key string, owner string, wait time.Duration) (unlock func() error, err error)
+}
+
+// LockHeldError 锁被其他持有者占用
+type LockHeldError struct {
+	Key    string
+	Holder string
+	Since  time.Time
+	Wait   time.Duration
+}
+
+func (e *LockHeldError) Error() string {
+	holder := e.Holder
+	if holder == "" {
+		holder = "unknown"
+	}
+	since := "unknown time"
+	if !e.Since.IsZero() {
+		since = e.Since.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("migration lock '%s' held by %s since %s, gave up after waiting %s", e.Key, holder, since, e.Wait)
+}
+
+func (e *LockHeldError) Is(target error) bool { return target == ErrLockHeld }
+
+// lockOwner 当前进程的锁持有者标识
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s(pid:%d)", host, os.Getpid())
+}
+
+// lock 获取迁移锁，未开启锁时返回空操作的 unlock，未配置 Locker 且没有数据库连接配置时告警并跳过
+func (g *core) lock(ctx context.Context) (unlock func(), err error) {
+	unlock = func() {}
+	if !g.conf.GetLockEnabled() {
+		return
+	}
+	locker := g.conf.GetLocker()
+	var database *DSN
+	if locker == nil {
+		if database, err = g.database(); err != nil {
+			// 与审计一致只告警，不阻止迁移
+			if errors.Is(err, ErrNoDatabase) {
+				g.logger.WarnContext(ctx, "skip migration lock", stageField(StageLock), Field{Key: FieldError, Value: err})
+				err = nil
+			}
+			return
+		}
+	}
+	var key string
+	owner := lockOwner()
+	g.logger.Info("acquire migration lock...", stageField(StageLock))
+	defer func() {
+		g.logger.InfoWithFlag(err, "acquire migration lock", stageField(StageLock), Field{Key: "key", Value: key}, Field{Key: "owner", Value: owner})
+	}()
+	if locker == nil {
+		var dialect Dialect
+		if dialect, err = database.dialect(); err != nil {
+			return
+		}
+		if locker, err = dialect.NewLocker(database); err != nil {
+			return
+		}
+	}
+	if key, err = g.lockName(); err != nil {
+		return
+	}
+	var release func() error
+	if release, err = locker.Lock(ctx, key, owner, g.conf.GetLockWaitTimeout()); err != nil {
+		return
+	}
+	unlock = func() {
+		err := release()
+		g.logger.InfoWithFlag(err, "release migration lock", stageField(StageLock), Field{Key: "key", Value: key}, Field{Key: "owner", Value: owner})
+	}
+	return
+}
+
+// lockName 锁名，未配置时以 DSN 中的库名生成
+func (g *core) lockName() (string, error) {
+	if name := g.conf.GetLockName(); name != "" {
+		return name, nil
+	}
+	database, err := g.database()
+	if err != nil {
+		return "", err
+	}
+	name := "migration:" + database.DBName
+	if len(name) > mysqlLockNameMaxLen {
+		sum := md5.Sum([]byte(name))
+		name = "migration:" + hex.EncodeToString(sum[:])
+	}
+	return name, nil
+}
+
+type mysqlLocker struct {
+	dsn string
+}
+
+// NewMySQLLocker 基于 MySQL GET_LOCK/RELEASE_LOCK 的 Locker，dsn 为 go-sql-driver 格式
+// 锁与连接绑定，连接不指定库，因此可以在库尚不存在时保护 CREATE DATABASE
+// 持有者身份记录在锁连接的用户变量中，等待方通过 performance_schema 查询
+func NewMySQLLocker(dsn string) Locker {
+	return &mysqlLocker{dsn: dsn}
+}
+
+func (l *mysqlLocker) Lock(ctx context.Context, key string, owner string, wait time.Duration) (unlock func() error, err error) {
+	var config *mysql.Config
+	if config, err = mysql.ParseDSN(l.dsn); err != nil {
+		return
+	}
+	config.DBName = ""
+	var db *sql.DB
+	if db, err = sql.Open("mysql", config.FormatDSN()); err != nil {
+		return
+	}
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		_ = db.Close()
+		err = connectionError(err)
+		return
+	}
+	closeAll := func() {
+		_ = conn.Close()
+		_ = db.Close()
+	}
+	var got sql.NullInt64
+	if err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", key, int(math.Ceil(wait.Seconds()))).Scan(&got); err != nil {
+		closeAll()
+		err = connectionError(err)
+		return
+	}
+	if !got.Valid || got.Int64 != 1 {
+		holder, since := l.holder(ctx, conn, key)
+		closeAll()
+		return nil, &LockHeldError{Key: key, Holder: holder, Since: since, Wait: wait}
+	}
+	_, _ = conn.ExecContext(ctx, fmt.Sprintf("SET @%s = ?, @%s = ?", lockOwnerVariable, lockSinceVariable),
+		owner, time.Now().UTC().Format(time.RFC3339))
+	unlock = func() error {
+		defer closeAll()
+		_, err := conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", key)
+		return err
+	}
+	return
+}
+
+// holder 查询锁持有者，performance_schema 不可用时退化为 PROCESSLIST 中的 user@host
+func (l *mysqlLocker) holder(ctx context.Context, conn *sql.Conn, key string) (holder string, since time.Time) {
+	rows, err := conn.QueryContext(ctx, `SELECT v.VARIABLE_NAME, v.VARIABLE_VALUE
+FROM performance_schema.user_variables_by_thread v
+JOIN performance_schema.threads t ON v.THREAD_ID = t.THREAD_ID
+WHERE t.PROCESSLIST_ID = IS_USED_LOCK(?) AND v.VARIABLE_NAME IN (?, ?)`, key, lockOwnerVariable, lockSinceVariable)
+	if err == nil {
+		for rows.Next() {
+			var name, value string
+			if rows.Scan(&name, &value) != nil {
+				continue
+			}
+			switch name {
+			case lockOwnerVariable:
+				holder = value
+			case lockSinceVariable:
+				since, _ = time.Parse(time.RFC3339, value)
+			}
+		}
+		_ = rows.Close()
+	}
+	if holder == "" {
+		var id sql.NullInt64
+		var userHost sql.NullString
+		if conn.QueryRowContext(ctx, "SELECT p.ID, CONCAT(p.USER, '@', p.HOST) FROM information_schema.PROCESSLIST p WHERE p.ID = IS_USED_LOCK(?)", key).Scan(&id, &userHost) == nil {
+			holder = fmt.Sprintf("%s(connection:%d)", userHost.String, id.Int64)
+		}
+	}
+	return
+}
@@ -0,0 +1,63 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// heldLocker 锁始终被其他持有者占用
+type heldLocker struct{ calls int }
+
+func (l *heldLocker) Lock(_ context.Context, key string, _ string, wait time.Duration) (func() error, error) {
+	l.calls++
+	return nil, &LockHeldError{Key: key, Holder: "other", Wait: wait}
+}
+
+func TestMigrateOnlyLock(t *testing.T) {
+	for _, backend := range []string{BackendAlembic, BackendNative} {
+		t.Run(backend, func(t *testing.T) {
+			root := t.TempDir()
+			replay := NewReplayExecutor()
+			locker := &heldLocker{}
+			m := New(log.New(io.Discard, "", 0), WithBackend(backend), WithScriptRoot(root), WithExecutor(replay),
+				WithCommitID("abc"), WithLocker(locker), WithLockName("migration:test"))
+			if err := m.MigrateOnly(""); !errors.Is(err, ErrLockHeld) {
+				t.Fatalf("MigrateOnly() = %v, want %v", err, ErrLockHeld)
+			}
+			if locker.calls != 1 {
+				t.Fatalf("Lock called %d times, want 1", locker.calls)
+			}
+			if records := replay.Records(); len(records) != 0 {
+				t.Fatalf("executed '%s' without the lock", records[0].Cmd)
+			}
+			if _, err := os.Stat(filepath.Join(root, migrationsVersionsDir)); !os.IsNotExist(err) {
+				t.Fatalf("revision generated without the lock: %v", err)
+			}
+		})
+	}
+}
+
+func TestLockWithoutDatabase(t *testing.T) {
+	h := &recordHandler{}
+	g := newCore(nil, WithScriptRoot(t.TempDir()), WithLogHandler(h))
+	unlock, err := g.lock(context.Background())
+	if err != nil {
+		t.Fatalf("lock() = %v, want the lock skipped", err)
+	}
+	unlock()
+	if len(h.records) != 1 || h.records[0].Level != LevelWarn || h.records[0].field(FieldStage) != StageLock {
+		t.Fatalf("records %+v, want one lock warning", h.records)
+	}
+
+	// Dsn 无效时仍返回错误
+	g = newCore(nil, WithScriptRoot(t.TempDir()), WithDsn("postgres://[::1"), WithLogHandler(h))
+	if _, err = g.lock(context.Background()); err == nil || errors.Is(err, ErrNoDatabase) {
+		t.Fatalf("lock() = %v, want the DSN error", err)
+	}
+}
@@ -1,6 +1,17 @@
 package migration
 
-import "log"
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
 
 const (
 	warningFlag = "⚠️ Waring! "
@@ -9,29 +20,311 @@ const (
 	logPrefix   = "[migration] "
 )
 
+// Level 日志级别，取值与 log/slog 的级别一致
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	}
+	return "ERROR"
+}
+
+// 常用的日志字段名
+const (
+	FieldStage    = "stage"
+	FieldRevision = "revision"
+	FieldDuration = "duration"
+	FieldCommand  = "command"
+	FieldExitCode = "exit_code"
+	FieldError    = "error"
+	// FieldStatus 操作结果，success 或 failed
+	FieldStatus = "status"
+)
+
+const (
+	statusSuccess = "success"
+	statusFailed  = "failed"
+)
+
+// Field 日志的键值字段
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// LogRecord 一条日志
+type LogRecord struct {
+	Time    time.Time
+	Level   Level
+	Message string
+	Fields  []Field
+	// Context 输出日志的操作的 context，以 Log/WarnWithFlag 等不带 context 的方法输出时为 nil
+	Context context.Context
+}
+
+// field 字段值，不存在时返回 nil
+func (r LogRecord) field(key string) interface{} {
+	for _, f := range r.Fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return nil
+}
+
+// Handler 日志处理器，可通过 WithLogHandler 替换，默认为 NewPrettyHandler
+type Handler interface {
+	// Enabled 是否输出该级别的日志
+	Enabled(level Level) bool
+	// Handle 输出一条日志
+	Handle(r LogRecord) error
+}
+
+// Logger 结构化日志，以 Handler 输出
+// 内嵌的 *log.Logger 兼容旧版本的 Println 等方法，以 NewLoggerWithHandler 创建时每行作为一条 Info 日志交给 Handler
 type Logger struct {
 	*log.Logger
+	handler Handler
+	fields  []Field
 }
 
+// NewLogger 以 log.Logger 创建默认格式的 Logger，l 为 nil 时使用 log.Default()
 func NewLogger(l *log.Logger) *Logger {
 	if l == nil {
 		l = log.Default()
 	}
-	return &Logger{l}
+	return &Logger{Logger: l, handler: NewPrettyHandler(l, LevelInfo)}
+}
+
+// NewLoggerWithHandler 以 Handler 创建 Logger
+func NewLoggerWithHandler(h Handler) *Logger {
+	return &Logger{Logger: log.New(handlerWriter{h}, "", 0), handler: h}
+}
+
+// Handler 日志处理器
+func (l *Logger) Handler() Handler { return l.handler }
+
+// With 返回附加了 fields 的 Logger，fields 出现在之后的每条日志中
+func (l *Logger) With(fields ...Field) *Logger {
+	return &Logger{Logger: l.Logger, handler: l.handler, fields: append(append([]Field(nil), l.fields...), fields...)}
+}
+
+// Log 输出一条结构化日志
+func (l *Logger) Log(level Level, msg string, fields ...Field) {
+	l.log(nil, level, msg, fields)
+}
+
+// LogContext 输出一条结构化日志，ctx 为输出日志的操作的 context
+func (l *Logger) LogContext(ctx context.Context, level Level, msg string, fields ...Field) {
+	l.log(ctx, level, msg, fields)
 }
 
+func (l *Logger) log(ctx context.Context, level Level, msg string, fields []Field) {
+	if !l.handler.Enabled(level) {
+		return
+	}
+	if len(l.fields) > 0 {
+		fields = append(append([]Field(nil), l.fields...), fields...)
+	}
+	_ = l.handler.Handle(LogRecord{Time: time.Now(), Level: level, Message: msg, Fields: fields, Context: ctx})
+}
+
+// Info 输出 Info 日志，v 中的 Field 作为字段，其余参数以空格连接为消息
 func (l *Logger) Info(v ...interface{}) {
-	l.Logger.Println(append([]interface{}{logPrefix}, v...)...)
+	msg, fields := logArgs(v)
+	l.Log(LevelInfo, msg, fields...)
 }
 
+// InfoWithFlag 输出操作结果，err 不为 nil 时为 Error 日志
 func (l *Logger) InfoWithFlag(err error, v ...interface{}) {
+	msg, fields := logArgs(v)
 	if err != nil {
-		l.Logger.Println(append(append([]interface{}{logPrefix, failedFlag}, v...), " ,Error:", err)...)
-	} else {
-		l.Logger.Println(append([]interface{}{logPrefix, successFlag}, v...)...)
+		l.Log(LevelError, msg, append(fields, Field{Key: FieldStatus, Value: statusFailed}, Field{Key: FieldError, Value: err})...)
+		return
 	}
+	l.Log(LevelInfo, msg, append(fields, Field{Key: FieldStatus, Value: statusSuccess})...)
 }
 
+// WarnWithFlag 输出 Warn 日志
 func (l *Logger) WarnWithFlag(v ...interface{}) {
-	l.Logger.Println(append([]interface{}{logPrefix, warningFlag}, v...)...)
+	msg, fields := logArgs(v)
+	l.Log(LevelWarn, msg, fields...)
+}
+
+// WarnContext 输出 Warn 日志，ctx 为输出日志的操作的 context，随告警事件传给 Hook
+func (l *Logger) WarnContext(ctx context.Context, v ...interface{}) {
+	msg, fields := logArgs(v)
+	l.LogContext(ctx, LevelWarn, msg, fields...)
+}
+
+// logArgs 将日志参数拆分为消息与字段，Field 类型的参数作为字段，其余参数以空格连接为消息
+func logArgs(v []interface{}) (msg string, fields []Field) {
+	var parts []string
+	for _, arg := range v {
+		if f, ok := arg.(Field); ok {
+			fields = append(fields, f)
+			continue
+		}
+		if s := strings.TrimSpace(fmt.Sprint(arg)); s != "" {
+			parts = append(parts, s)
+		}
+	}
+	return strings.Join(parts, " "), fields
+}
+
+// stageField 阶段字段
+func stageField(stage string) Field { return Field{Key: FieldStage, Value: stage} }
+
+// revisionField 版本号字段
+func revisionField(revision string) Field { return Field{Key: FieldRevision, Value: revision} }
+
+// handlerWriter 将内嵌 *log.Logger 输出的每一行作为 Info 日志交给 Handler
+type handlerWriter struct {
+	h Handler
+}
+
+func (w handlerWriter) Write(p []byte) (int, error) {
+	if w.h.Enabled(LevelInfo) {
+		_ = w.h.Handle(LogRecord{Time: time.Now(), Level: LevelInfo, Message: strings.TrimSuffix(string(p), "\n")})
+	}
+	return len(p), nil
+}
+
+// HandlerWithFields 返回为每条日志附加 fields 的 Handler
+func HandlerWithFields(h Handler, fields ...Field) Handler {
+	return &fieldsHandler{Handler: h, fields: fields}
+}
+
+type fieldsHandler struct {
+	Handler
+	fields []Field
+}
+
+func (h *fieldsHandler) Handle(r LogRecord) error {
+	r.Fields = append(append([]Field(nil), h.fields...), r.Fields...)
+	return h.Handler.Handle(r)
+}
+
+// prettyHandler 默认的日志格式，以 emoji 标识操作结果，便于在终端中阅读
+type prettyHandler struct {
+	logger *log.Logger
+	level  Level
+}
+
+// NewPrettyHandler 以 log.Logger 输出便于阅读的日志，l 为 nil 时使用 log.Default()
+func NewPrettyHandler(l *log.Logger, level Level) Handler {
+	if l == nil {
+		l = log.Default()
+	}
+	return &prettyHandler{logger: l, level: level}
+}
+
+func (h *prettyHandler) Enabled(level Level) bool { return level >= h.level }
+
+func (h *prettyHandler) Handle(r LogRecord) error {
+	var b strings.Builder
+	b.WriteString(logPrefix)
+	switch {
+	case r.Level >= LevelError:
+		b.WriteString(failedFlag)
+	case r.Level >= LevelWarn:
+		b.WriteString(warningFlag)
+	case r.field(FieldStatus) == statusSuccess:
+		b.WriteString(successFlag)
+	}
+	b.WriteString(r.Message)
+	var errValue interface{}
+	sep := r.Message != ""
+	for _, f := range r.Fields {
+		switch f.Key {
+		case FieldStatus:
+			continue
+		case FieldError:
+			errValue = f.Value
+			continue
+		}
+		if sep {
+			b.WriteString(", ")
+		}
+		sep = true
+		_, _ = fmt.Fprintf(&b, "%s: %v", f.Key, f.Value)
+	}
+	if errValue != nil {
+		_, _ = fmt.Fprintf(&b, " ,Error: %v", errValue)
+	}
+	return h.logger.Output(2, b.String())
+}
+
+// jsonHandler 每行输出一个 JSON 对象
+type jsonHandler struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+}
+
+// NewJSONHandler 每条日志以一行 JSON 写入 w，包含 time、level、msg 及各字段
+func NewJSONHandler(w io.Writer, level Level) Handler {
+	return &jsonHandler{w: w, level: level}
+}
+
+func (h *jsonHandler) Enabled(level Level) bool { return level >= h.level }
+
+func (h *jsonHandler) Handle(r LogRecord) error {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	writeJSONField(&buf, "time", r.Time.Format(time.RFC3339Nano), true)
+	writeJSONField(&buf, "level", r.Level.String(), false)
+	writeJSONField(&buf, "msg", r.Message, false)
+	for _, f := range r.Fields {
+		writeJSONField(&buf, f.Key, f.Value, false)
+	}
+	buf.WriteString("}\n")
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.w.Write(buf.Bytes())
+	return err
+}
+
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}, first bool) {
+	if !first {
+		buf.WriteString(",")
+	}
+	k, _ := json.Marshal(key)
+	buf.Write(k)
+	buf.WriteString(":")
+	buf.Write(jsonValue(value))
+}
+
+// jsonValue 字段值的 JSON 表示，error、time.Duration 及实现了 fmt.Stringer 的值以字符串输出
+func jsonValue(value interface{}) []byte {
+	if rv := reflect.ValueOf(value); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return []byte("null")
+	}
+	switch v := value.(type) {
+	case error:
+		value = v.Error()
+	case time.Duration:
+		value = v.String()
+	case json.Marshaler:
+	case fmt.Stringer:
+		value = v.String()
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(value))
+	}
+	return b
 }
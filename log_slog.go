@@ -0,0 +1,39 @@
+//go:build go1.21
+// +build go1.21
+
+package migration
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogHandler 将日志转交给 log/slog 的 Handler
+type slogHandler struct {
+	h slog.Handler
+}
+
+// NewSlogHandler 适配 log/slog 的 Handler，如 slog.NewJSONHandler、slog.NewTextHandler 或日志平台提供的 Handler
+func NewSlogHandler(h slog.Handler) Handler {
+	return &slogHandler{h: h}
+}
+
+func (s *slogHandler) Enabled(level Level) bool {
+	return s.h.Enabled(context.Background(), slog.Level(level))
+}
+
+func (s *slogHandler) Handle(r LogRecord) error {
+	record := slog.NewRecord(r.Time, slog.Level(r.Level), r.Message, 0)
+	for _, f := range r.Fields {
+		if err, ok := f.Value.(error); ok {
+			record.AddAttrs(slog.String(f.Key, err.Error()))
+			continue
+		}
+		record.AddAttrs(slog.Any(f.Key, f.Value))
+	}
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return s.h.Handle(ctx, record)
+}
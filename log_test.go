@@ -0,0 +1,81 @@
+package migration
+
+import (
+	"bytes"
+	"errors"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordHandler 记录所有日志
+type recordHandler struct {
+	mu      sync.Mutex
+	records []LogRecord
+}
+
+func (h *recordHandler) Enabled(Level) bool { return true }
+
+func (h *recordHandler) Handle(r LogRecord) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	return nil
+}
+
+func TestLoggerFields(t *testing.T) {
+	h := &recordHandler{}
+	l := NewLoggerWithHandler(h).With(Field{Key: "db", Value: "app"})
+	l.InfoWithFlag(errors.New("boom"), "upgrade", stageField(StageUpgrade), revisionField("abc"), "extra:", 1)
+	l.Println("legacy", "line")
+
+	want := []LogRecord{
+		{Level: LevelError, Message: "upgrade extra: 1", Fields: []Field{
+			{Key: "db", Value: "app"}, stageField(StageUpgrade), revisionField("abc"),
+			{Key: FieldStatus, Value: statusFailed}, {Key: FieldError, Value: errors.New("boom")},
+		}},
+		{Level: LevelInfo, Message: "legacy line"},
+	}
+	if len(h.records) != len(want) {
+		t.Fatalf("records = %+v, want %d records", h.records, len(want))
+	}
+	for i, r := range h.records {
+		r.Time = want[i].Time
+		if !reflect.DeepEqual(r, want[i]) {
+			t.Fatalf("record %d = %+v, want %+v", i, r, want[i])
+		}
+	}
+}
+
+func TestPrettyHandler(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewLogger(log.New(&buf, "", 0))
+	l.InfoWithFlag(nil, "upgrade", stageField(StageUpgrade), revisionField("abc"))
+	l.Println("legacy")
+	if got, want := buf.String(), logPrefix+successFlag+"upgrade, stage: upgrade, revision: abc\nlegacy\n"; got != want {
+		t.Fatalf("output = %q, want %q", got, want)
+	}
+}
+
+func TestOperationLogsCarryStage(t *testing.T) {
+	h := &recordHandler{}
+	m := New(nil, WithBackend(BackendNative), WithScriptRoot(t.TempDir()), WithCommitID("abc"), WithLockEnabled(false), WithLogHandler(h))
+	if err := m.MigrateOnly(""); err != nil {
+		t.Fatal(err)
+	}
+	// 再次生成同一版本号时告警
+	_ = m.MigrateOnly("")
+	if len(h.records) == 0 {
+		t.Fatal("no logs")
+	}
+	for _, r := range h.records {
+		if stage, _ := r.field(FieldStage).(string); stage == "" {
+			t.Errorf("log %q without stage: %+v", r.Message, r.Fields)
+		}
+		if strings.HasSuffix(r.Message, ":") {
+			t.Errorf("log %q has a dangling key", r.Message)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+// 指标中 outcome 标签的取值，其余取值见 AuditSuccess、AuditFailure、AuditNoChanges
+const (
+	outcomeVetoed  = "vetoed"
+	outcomeTimeout = "timeout"
+)
+
+// metricsOperations operation 标签的取值，只统计对外的变更操作
+var metricsOperations = map[string]bool{AuditMigrate: true, AuditUpgrade: true, AuditDowngrade: true, AuditStamp: true}
+
+// Metrics Prometheus 指标，实现 prometheus.Collector，通过 WithMetrics 配置后由 Migration 统计 Migrate/Upgrade/Downgrade/Stamp 的耗时及结果，
+// 通过 Observe、ObserveDrift、ObserveFleet 更新落后于 head 的版本数及 drift
+type Metrics struct {
+	duration *prometheus.HistogramVec
+	total    *prometheus.CounterVec
+	applied  *prometheus.CounterVec
+	warnings *prometheus.CounterVec
+	behind   *prometheus.GaugeVec
+	drift    *prometheus.GaugeVec
+}
+
+// NewMetrics 创建 Metrics，namespace 为指标名前缀，为空时使用 migration
+func NewMetrics(namespace string) *Metrics {
+	if namespace == "" {
+		namespace = "migration"
+	}
+	return &Metrics{
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "operation_duration_seconds",
+			Help:      "Duration of migrate, upgrade, downgrade and stamp operations by outcome.",
+			Buckets:   []float64{.1, .5, 1, 5, 10, 30, 60, 300, 900, 3600},
+		}, []string{"database", "operation", "outcome"}),
+		total: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "operations_total",
+			Help:      "Number of migrate, upgrade, downgrade and stamp operations by outcome.",
+		}, []string{"database", "operation", "outcome"}),
+		applied: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "revisions_applied_total",
+			Help:      "Number of revisions upgraded or downgraded.",
+		}, []string{"database", "operation"}),
+		warnings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "warnings_total",
+			Help:      "Number of warnings that did not fail the operation.",
+		}, []string{"database"}),
+		behind: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "revisions_behind_head",
+			Help:      "Number of revisions the database is behind the local head.",
+		}, []string{"database"}),
+		drift: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "drift_items",
+			Help:      "Number of differences between the database schema and the head revision.",
+		}, []string{"database"}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{m.duration, m.total, m.applied, m.warnings, m.behind, m.drift}
+}
+
+// Describe 实现 prometheus.Collector
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+// Collect 实现 prometheus.Collector
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// observe 记录 operation 的耗时及结果
+func (m *Metrics) observe(database, operation string, duration time.Duration, err error) {
+	outcome := metricsOutcome(err)
+	m.duration.WithLabelValues(database, operation, outcome).Observe(duration.Seconds())
+	m.total.WithLabelValues(database, operation, outcome).Inc()
+}
+
+func metricsOutcome(err error) string {
+	switch {
+	case err == nil:
+		return AuditSuccess
+	case noNewRevision(err):
+		return AuditNoChanges
+	case errors.Is(err, ErrVetoed):
+		return outcomeVetoed
+	case errors.Is(err, ErrTimeout):
+		return outcomeTimeout
+	}
+	return AuditFailure
+}
+
+// Observe 以 ShowDatabaseRevision 与 History 计算数据库落后于 head 的版本数并更新指标
+func (m *Metrics) Observe(ctx context.Context, database string, mg Migration) (behind int, err error) {
+	var pending []string
+	if _, _, pending, err = pendingRevisions(ctx, mg, nil); err != nil {
+		return
+	}
+	behind = len(pending)
+	m.behind.WithLabelValues(database).Set(float64(behind))
+	return
+}
+
+// ObserveDrift 以 Drift 的结果更新 database 的 drift 指标
+func (m *Metrics) ObserveDrift(database string, report DriftReport) {
+	m.drift.WithLabelValues(database).Set(float64(len(report.Items)))
+}
+
+// ObserveFleet 以 Fleet 的执行结果更新各目标落后于 head 的版本数，以目标名作为 database 标签，失败或跳过的目标不更新
+func (m *Metrics) ObserveFleet(report FleetReport) {
+	for _, result := range report.Results {
+		if result.Err == nil && !result.Skipped {
+			m.behind.WithLabelValues(result.Target).Set(float64(len(result.Pending)))
+		}
+	}
+}
+
+// metrics 配置的 Metrics 及 database 标签，未配置 Metrics 时返回 nil
+func (g *core) metrics() (*Metrics, string) {
+	m := g.conf.GetMetrics()
+	if m == nil {
+		return nil, ""
+	}
+	if database := g.conf.GetMetricsDatabase(); database != "" {
+		return m, database
+	}
+	if database, err := g.database(); err == nil {
+		return m, database.DBName
+	}
+	return m, ""
+}
+
+// observeOperation 更新 operation 的耗时及结果
+func (g *core) observeOperation(operation string, start time.Time, err error) {
+	if m, database := g.metrics(); m != nil && metricsOperations[operation] {
+		m.observe(database, operation, time.Since(start), err)
+	}
+}
+
+// observeApplied 更新升级或回退的版本数，operation 为 upgrade 或 downgrade
+func (g *core) observeApplied(operation string, revisions int) {
+	if m, database := g.metrics(); m != nil && revisions > 0 {
+		m.applied.WithLabelValues(database, operation).Add(float64(revisions))
+	}
+}
+
+// observeWarning 更新告警数
+func (g *core) observeWarning() {
+	if m, database := g.metrics(); m != nil {
+		m.warnings.WithLabelValues(database).Inc()
+	}
+}
@@ -0,0 +1,36 @@
+package migration
+
+import (
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"strings"
+	"testing"
+)
+
+func TestMetricsOperations(t *testing.T) {
+	replay := NewReplayExecutor().
+		On("db init", Reply{}).
+		On("db history --verbose", Reply{Stdout: Revision{RevisionId: "a", IsHead: true, Path: "a_.py", Doc: "a"}.LogEntry()}).
+		On("db current --verbose", Reply{}).
+		On("db upgrade", Reply{}, Reply{Stderr: "Traceback (most recent call last):", ExitCode: 1})
+	m := NewMetrics("test")
+	g := newTestMigrate(t, replay, WithMetrics(m), WithMetricsDatabase("app"), WithAuditEnabled(false), WithDDLPolicy(DDLPolicyOff))
+	if err := g.Upgrade(); err != nil {
+		t.Fatalf("Upgrade() = %v", err)
+	}
+	if err := g.Upgrade(); err == nil {
+		t.Fatal("Upgrade() = nil, want error")
+	}
+	// 内部阶段(prepare、history 等)不出现在 operation 标签中
+	want := `
+# HELP test_operations_total Number of migrate, upgrade, downgrade and stamp operations by outcome.
+# TYPE test_operations_total counter
+test_operations_total{database="app",operation="upgrade",outcome="failure"} 1
+test_operations_total{database="app",operation="upgrade",outcome="success"} 1
+# HELP test_revisions_applied_total Number of revisions upgraded or downgraded.
+# TYPE test_revisions_applied_total counter
+test_revisions_applied_total{database="app",operation="upgrade"} 1
+`
+	if err := testutil.CollectAndCompare(m, strings.NewReader(want), "test_operations_total", "test_revisions_applied_total"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,45 +1,60 @@
 package migration
 
 import (
-	"bytes"
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"github.com/go-sql-driver/mysql"
 	"github.com/sandwich-go/boost/xos"
-	"github.com/sandwich-go/boost/xpanic"
-	"github.com/sandwich-go/boost/xproc"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// Migration 迁移操作，所有实现均不会切换进程的工作目录，同一个或多个 Migration 实例可以在多个 goroutine 中并发使用，
+// 作用于同一数据库的 Migrate/Upgrade/Downgrade 由迁移锁串行执行
 type Migration interface {
 	// Generate
 	// Generate migration file and initializes migration support for the application.
 	Generate(opts ...GenerateConfOption) (err error)
+	// GenerateContext
+	// Generate with context.
+	GenerateContext(ctx context.Context, opts ...GenerateConfOption) (err error)
 
 	// Migrate
 	// Create database if not exists.
 	// Generate script revision and diff from remote database for update SQL DDL.
+	// Returns an error matching ErrNoSchemaChanges or ErrDatabaseNotUpToDate(with the current head revision)
+	// when no new revision was generated, callers decide whether to treat it as success.
+	// submitComment is the revision message, "<CommitID>_<timestamp>" if empty.
 	Migrate(submitComment string) (revision Revision, err error)
+	// MigrateContext
+	// Migrate with context.
+	MigrateContext(ctx context.Context, submitComment string) (revision Revision, err error)
 
 	// MigrateOnly
-	// "flask db migrate" only
+	// "flask db migrate" only, submitComment is the revision message, "<CommitID>_<timestamp>" if empty.
 	MigrateOnly(submitComment string) (err error)
+	// MigrateOnlyContext
+	// MigrateOnly with context.
+	MigrateOnlyContext(ctx context.Context, submitComment string) (err error)
 
 	// ShowLocalRevision
 	// Show the revision denoted by the given symbol.
 	ShowLocalRevision(version string) (revision Revision, err error)
+	// ShowLocalRevisionContext
+	// ShowLocalRevision with context.
+	ShowLocalRevisionContext(ctx context.Context, version string) (revision Revision, err error)
 
 	// ShowDatabaseRevision
 	// Shows the current revision of the database.
 	ShowDatabaseRevision() (revision Revision, err error)
+	// ShowDatabaseRevisionContext
+	// ShowDatabaseRevision with context.
+	ShowDatabaseRevisionContext(ctx context.Context) (revision Revision, err error)
 
 	// ShowDDL
 	// Use The --sql option present in several commands performs an ‘offline’ mode migration.
@@ -48,196 +63,278 @@ type Migration interface {
 	// ddlFileName - The name of the generated ddl file
 	// latest      -  Write only the latest version of the update to the ddl file
 	ShowDDL(ddlFileName string, latest bool) (ddl string, err error)
+	// ShowDDLContext
+	// ShowDDL with context.
+	ShowDDLContext(ctx context.Context, ddlFileName string, latest bool) (ddl string, err error)
 
 	// Upgrade
 	// Upgrades the database.
+	// Unless DDLPolicy is off (the default is warn), the pending SQL is rendered first with an extra
+	// "flask db upgrade --sql" to check destructive DDL, nothing is run when the database is already up to date.
 	Upgrade() (err error)
+	// UpgradeContext
+	// Upgrade with context.
+	UpgradeContext(ctx context.Context) (err error)
+
+	// UpgradeTo
+	// Upgrades the database to the target revision.
+	// target can be a revision id(or unique prefix), a relative step like "+1", or "head".
+	// The target is validated against History before the database is touched.
+	UpgradeTo(target string) (err error)
+	// UpgradeToContext
+	// UpgradeTo with context.
+	UpgradeToContext(ctx context.Context, target string) (err error)
 
 	// Downgrade
 	// Downgrades the database.
 	Downgrade() (err error)
+	// DowngradeContext
+	// Downgrade with context.
+	DowngradeContext(ctx context.Context) (err error)
+
+	// DowngradeTo
+	// Downgrades the database to the target revision.
+	// target can be a revision id(or unique prefix), a relative step like "-2", or "base".
+	// The target is validated against History before the database is touched.
+	DowngradeTo(target string) (err error)
+	// DowngradeToContext
+	// DowngradeTo with context.
+	DowngradeToContext(ctx context.Context, target string) (err error)
 
 	// History
 	// Shows the list of migrations.
 	History() (revisions []Revision, err error)
+	// HistoryContext
+	// History with context.
+	HistoryContext(ctx context.Context) (revisions []Revision, err error)
+
+	// Drift
+	// Compares the live database schema(information_schema) with the schema implied by the local head revision.
+	// The returned report lists missing/extra tables, columns, indexes and type/nullable/default mismatches.
+	Drift() (report DriftReport, err error)
+	// DriftContext
+	// Drift with context.
+	DriftContext(ctx context.Context) (report DriftReport, err error)
+
+	// Plan
+	// Shows the pending revisions between the database revision and head, each with its SQL statements,
+	// the alembic_version bookkeeping statements and the risk assessed from destructive DDL.
+	// The plan can be rendered as text, Markdown or JSON.
+	Plan() (plan Plan, err error)
+	// PlanContext
+	// Plan with context.
+	PlanContext(ctx context.Context) (plan Plan, err error)
+
+	// AuditLog
+	// Shows the audit log of Migrate/Upgrade/Downgrade/Stamp/Baseline recorded in the migration_audit_log table, newest first.
+	// limit <= 0 returns all entries.
+	AuditLog(limit int) (entries []AuditEntry, err error)
+	// AuditLogContext
+	// AuditLog with context.
+	AuditLogContext(ctx context.Context, limit int) (entries []AuditEntry, err error)
+
+	// Status
+	// Compares the local head with the database revision in one call: the pending revisions, whether the database is
+	// up to date, behind, ahead, diverged or at an unknown revision, and the uncommitted revision files in git.
+	// The status can be rendered as a table or JSON.
+	Status() (status Status, err error)
+	// StatusContext
+	// Status with context.
+	StatusContext(ctx context.Context) (status Status, err error)
+
+	// Stamp
+	// Sets the database revision to the given revision without running any migrations.
+	// revision can be a revision id(or unique prefix), "head" or "base".
+	Stamp(revision string) (err error)
+	// StampContext
+	// Stamp with context.
+	StampContext(ctx context.Context, revision string) (err error)
+
+	// Baseline
+	// Brings an existing database under migration control: generates an initial revision from the live schema
+	// (SHOW CREATE TABLE of every table) and stamps the database with it without executing any DDL.
+	// Requires no local revisions and an unstamped database, returns an error matching ErrAlreadyVersioned otherwise.
+	// The schema implied by the revision is compared with the live schema first, on any difference the revision
+	// is removed and an error matching ErrBaselineMismatch is returned.
+	// Only MySQL is supported: the live schema is exported with SHOW CREATE TABLE, which PostgreSQL and SQLite lack,
+	// so an error matching ErrUnsupportedDialect is returned for them before anything is locked or generated.
+	Baseline() (revision Revision, err error)
+	// BaselineContext
+	// Baseline with context.
+	BaselineContext(ctx context.Context) (revision Revision, err error)
 
 	// Command
 	// Exec command.
 	Command(env string, name string, arg ...string) (output []byte, err error)
+	// CommandContext
+	// Exec command with context, the process will be killed if the context is done before the command completes.
+	CommandContext(ctx context.Context, env string, name string, arg ...string) (output []byte, err error)
+
+	// Close
+	// Release the connection keeping a sqlite in-memory database alive, the data is lost and is shared with
+	// other migrations opened with the same dsn in this process. Nothing to release for other databases.
+	Close() (err error)
 }
 
+// migrate 基于 Flask-Migrate(Alembic) 的迁移后端
 type migrate struct {
-	logger *Logger
-	conf   ConfInterface
+	*core
 }
 
 func New(logger *log.Logger, opts ...ConfOption) Migration {
-	return &migrate{logger: NewLogger(logger), conf: NewConf(opts...)}
+	c := newCore(logger, opts...)
+	if c.conf.GetBackend() == BackendNative {
+		return &native{core: c}
+	}
+	return &migrate{core: c}
 }
 
-func (g *migrate) flaskEnv() string {
-	return fmt.Sprintf("FLASK_APP=%s", g.conf.GetFileName())
+// flaskEnv flask 命令的环境变量，配置了 Database/Dsn 时通过 MIGRATION_DATABASE_URI 覆盖 migration 脚本中的连接配置
+func (g *migrate) flaskEnv() (env []string, err error) {
+	env = []string{fmt.Sprintf("FLASK_APP=%s", g.conf.GetFileName())}
+	if g.conf.GetDatabase() == nil && g.conf.GetDsn() == "" {
+		return
+	}
+	var database *DSN
+	if database, err = g.database(); err != nil {
+		return
+	}
+	var uri string
+	if uri, err = database.scriptURL(); err != nil {
+		return
+	}
+	return append(env, databaseURIEnv+"="+uri), nil
 }
 
-func (g *migrate) migrationBuildDir() (migrationBuildDir string) {
-	return g.conf.GetScriptRoot()
+// flask 执行 flask 命令
+func (g *migrate) flask(ctx context.Context, arg ...string) (output []byte, err error) {
+	var env []string
+	if env, err = g.flaskEnv(); err != nil {
+		return
+	}
+	output, _, err = g.exec(ctx, g.migrationBuildDir(), env, "flask", arg...)
+	return
 }
 
 func (g *migrate) Generate(opts ...GenerateConfOption) error {
-	g.logger.Info("generate migration python script file...")
+	return g.GenerateContext(context.Background(), opts...)
+}
+
+func (g *migrate) GenerateContext(ctx context.Context, opts ...GenerateConfOption) (err error) {
+	g.logger.Info("generate migration python script file...", stageField(StageGenerate))
 	conf := NewGenerateConf(opts...)
+	var database *DSN
+	defer func() {
+		g.logger.InfoWithFlag(err, "generate migration python script file", stageField(StageGenerate), Field{Key: "dsn", Value: database})
+	}()
+	ctx, cancel, err := g.stageContext(ctx, StageGenerate)
+	defer func() { err = g.stageError(ctx, StageGenerate, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	if database, err = g.generateDatabase(conf); err != nil {
+		return
+	}
 	args := []string{
 		"migration",
 		"--dir", g.migrationBuildDir(),
 		"--file_name", g.conf.GetFileName(),
-		"--db_host", conf.GetMysqlHost(),
-		"--db_port", strconv.Itoa(conf.GetMysqlPort()),
-		"--db_user", conf.GetMysqlUser(),
-		"--db_pass", conf.GetMysqlPassword(),
-		"--db_name", conf.GetMysqlDbName(),
+		"--db_host", database.Host,
+		"--db_port", strconv.Itoa(database.port()),
+		"--db_user", database.User,
+		"--db_pass", database.Password,
+		"--db_name", database.DBName,
 		"--config", conf.GetProtokitGoSettingPath(),
 		"--log_level=4",
 	}
-
-	var err error
-	xpanic.Try(func() {
-		_, err = xproc.Run(conf.GetProtokitPath(), xproc.WithArgs(args...))
-	}).Catch(func(err xpanic.E) {
-		err = fmt.Errorf("panic as error:%v", err)
-	})
-	g.logger.InfoWithFlag(err, "generate migration python script file", ", args:", args)
-	return err
+	if _, err = g.CommandContext(ctx, "", conf.GetProtokitPath(), args...); err != nil {
+		return
+	}
+	// protokitgo 只支持 host/port/user/password/db，以完整的 SQLAlchemy URL 覆盖生成的 SQLALCHEMY_DATABASE_URI，
+	// 使 unix socket、TLS 及其他连接参数与 Go 侧保持一致
+	return g.writeDatabaseURI(database)
 }
 
-func (g *migrate) Command(env string, name string, arg ...string) (output []byte, err error) {
-	var stderr bytes.Buffer
-	var stdout bytes.Buffer
-	xpanic.Try(func() {
-		cmd := exec.Command(name, arg...)
-
-		if env != "" {
-			cmd.Env = append(cmd.Env, env)
-		}
-
-		cmd.Stdout = &stdout
-		cmd.Stderr = &stderr
-		err = cmd.Run()
-		output = stdout.Bytes()
-	}).Catch(func(err xpanic.E) {
-		err = fmt.Errorf("panic as error:%v", err)
-	})
-	if stderr.String() != "" {
-		if err != nil {
-			err = fmt.Errorf("error: %v, stderr:%s", err, stderr.String())
-		}
+// generateDatabase 生成 migration 脚本使用的数据库连接配置，Conf 中的 Database/Dsn 优先于 GenerateConf 中的 Dialect 及 Db* 配置，
+// Db* 为空时使用已废弃的 Mysql* 配置，均为空时使用默认值，端口默认为方言的默认端口
+func (g *migrate) generateDatabase(conf GenerateConfInterface) (*DSN, error) {
+	if database := g.conf.GetDatabase(); database != nil {
+		return database, nil
 	}
-	return
+	if dsn := g.conf.GetDsn(); dsn != "" {
+		return ParseDSN(dsn)
+	}
+	database := &DSN{
+		Dialect:  conf.GetDialect(),
+		Host:     firstNonEmpty(conf.GetDbHost(), conf.GetMysqlHost(), defaultHost),
+		Port:     conf.GetDbPort(),
+		User:     firstNonEmpty(conf.GetDbUser(), conf.GetMysqlUser(), defaultUser),
+		Password: firstNonEmpty(conf.GetDbPassword(), conf.GetMysqlPassword()),
+		DBName:   firstNonEmpty(conf.GetDbName(), conf.GetMysqlDbName(), defaultDBName),
+	}
+	dialect, err := database.dialect()
+	if err != nil {
+		return nil, err
+	}
+	if database.Port == 0 {
+		database.Port = conf.GetMysqlPort()
+	}
+	if database.Port == 0 {
+		database.Port = dialect.DefaultPort()
+	}
+	return database, nil
 }
 
-func (g *migrate) prepare() (deferFunc func(), err error) {
-	g.logger.Info("prepare...")
+// prepare 初始化 migrations 目录，flask 命令均以 ScriptRoot 为工作目录执行，不会切换进程的工作目录
+func (g *migrate) prepare(ctx context.Context) (err error) {
+	g.logger.Info("prepare...", stageField(StagePrepare))
 	var (
 		output []byte
 		dir    string
 	)
 	defer func() {
-		g.logger.InfoWithFlag(err, "prepare", ", dir:", dir, ", file:", g.conf.GetFileName(), ", output:\n", string(output))
+		g.logger.InfoWithFlag(err, "prepare", stageField(StagePrepare), Field{Key: "dir", Value: dir}, Field{Key: "file", Value: g.conf.GetFileName()}, Field{Key: "output", Value: string(output)})
 	}()
-	dir = g.migrationBuildDir()
-	deferFunc, err = Chdir(dir)
+	ctx, cancel, err := g.stageContext(ctx, StagePrepare)
+	defer func() { err = g.stageError(ctx, StagePrepare, err); cancel() }()
 	if err != nil {
 		return
 	}
-	output, err = g.Command(g.flaskEnv(), "flask", "db", "init")
-	if err != nil {
-		if strings.Contains(err.Error(), migrationsAlreadyExists) {
-			g.logger.WarnWithFlag(migrationsAlreadyExists)
-			err = nil
-		} else if strings.Contains(err.Error(), migrationsAlreadyDone) {
-			g.logger.WarnWithFlag(migrationsAlreadyDone)
-			err = nil
-		}
-	}
-	return
-}
 
-func (g *migrate) fetchDsnFromFile() (dsn string, err error) {
-	g.logger.Info("fetch DSN from migration python script...")
-	var migrationBuildDir string
-	defer func() {
-		g.logger.InfoWithFlag(err, "fetch DSN from migration python script", ", migrationBuildDir:", migrationBuildDir, ", file:", g.conf.GetFileName(), ", dsn:", dsn)
-	}()
-
-	migrationBuildDir = g.migrationBuildDir()
-	file := filepath.Join(migrationBuildDir, g.conf.GetFileName())
-	// 需要解析下migration.py中的`SQLALCHEMY_DATABASE_URI`
-	if !xos.ExistsFile(file) {
-		err = fmt.Errorf("not found '%s' migration python script", file)
-		return
+	dir = g.migrationBuildDir()
+	// 配置了 Database/Dsn 时需要 migration 脚本支持通过环境变量覆盖数据库连接
+	if g.conf.GetDatabase() != nil || g.conf.GetDsn() != "" {
+		if err = g.hookDatabaseURI(); err != nil {
+			return
+		}
 	}
-	var content []byte
-	content, err = xos.FileGetContents(file)
+	output, err = g.flask(ctx, "db", "init")
 	if err != nil {
-		return
-	}
-	reg := regexp.MustCompile(`app.config\['SQLALCHEMY_DATABASE_URI'] = '\s*(.*)\s*'`)
-	all := reg.FindAllStringSubmatch(string(content), -1)
-	if len(all) < 1 || len(all[0]) < 2 {
-		err = fmt.Errorf("invalid migration file, not found 'SQLALCHEMY_DATABASE_URI' in '%s'", file)
-		return
-	}
-	// 需要查看dsn，是否有这样的库
-	dsn = strings.TrimPrefix(all[0][1], mysqlDSNPrefix)
-	dsns := strings.Split(dsn, ":")
-	for i, j := range dsns {
-		if strings.Contains(j, "@") {
-			dsns[i] = strings.Replace(j, "@", "@tcp(", 1)
-			ss := strings.Split(dsns[i+1], "/")
-			ss[0] += ")"
-			dsns[i+1] = strings.Join(ss, "/")
-			dsn = strings.Join(dsns, ":")
-			break
+		if errors.Is(err, ErrMigrationsAlreadyExists) {
+			g.logger.WarnContext(ctx, migrationsAlreadyExists, stageField(StagePrepare))
+			err = nil
 		}
 	}
 	return
 }
 
-func (g *migrate) createDatabaseIfNotExists() (err error) {
-	g.logger.Info("create database if not exists...")
-	var dsn, dbName string
+func (g *migrate) generateRevisionScript(ctx context.Context, submitComment string) (err error) {
+	g.logger.Info("execute flask db migrate...", stageField(StageGenerateRevision))
+	var output []byte
 	defer func() {
-		g.logger.InfoWithFlag(err, "create database if not exists", ", dbName:", dbName)
+		g.logger.InfoWithFlag(err, "execute flask db migrate", stageField(StageGenerateRevision), Field{Key: "output", Value: string(output)})
 	}()
-	dsn, err = g.fetchDsnFromFile()
+	ctx, cancel, err := g.stageContext(ctx, StageGenerateRevision)
+	defer func() { err = g.stageError(ctx, StageGenerateRevision, err); cancel() }()
 	if err != nil {
 		return
 	}
-	var config *mysql.Config
-	if config, err = mysql.ParseDSN(dsn); err != nil {
-		return
-	}
-	dbName = config.DBName
-	config.DBName = ""
-
-	var mdb *sql.DB
-	if mdb, err = sql.Open("mysql", config.FormatDSN()); err != nil {
-		return err
-	}
-	_, err = mdb.ExecContext(context.Background(), fmt.Sprintf("CREATE DATABASE IF NOT EXISTS `%s`", dbName))
-	return
-}
-
-func (g *migrate) generateRevisionScript(_ string) (err error) {
-	g.logger.Info("execute flask db migrate...")
-	var output []byte
-	defer func() {
-		g.logger.InfoWithFlag(err, "execute flask db migrate", ", output:\n", string(output))
-	}()
 
 	// 检查是否有migrations/versions目录，versions目录为空的时候，git不会上传空目录
 	// 需要手动创建一次 以免migrate报错
 	// 检查目录是否存在
-	dirPath := "./migrations/versions"
+	dirPath := filepath.Join(g.migrationBuildDir(), migrationsVersionsDir)
 	_, err = os.Stat(dirPath)
 	if os.IsNotExist(err) {
 		err = os.Mkdir(dirPath, 0755)
@@ -246,121 +343,97 @@ func (g *migrate) generateRevisionScript(_ string) (err error) {
 		}
 	}
 
-	message := fmt.Sprintf(`--message=%s`, fmt.Sprintf("%s_%d", g.conf.GetCommitID(), time.Now().Unix())) // 用时"间戳+CommitID"作为本次migrate的提交内容(因为无法支持中文，且提交内容对用户无用)
-	revisionId := fmt.Sprintf(`--rev-id=%s`, g.conf.GetCommitID())                                        // 用CommitID作为本次migrate的版本号
+	message := fmt.Sprintf(`--message=%s`, g.revisionMessage(submitComment)) // 未指定时用"CommitID+时间戳"作为本次migrate的提交内容
+	revisionId := fmt.Sprintf(`--rev-id=%s`, g.conf.GetCommitID())           // 用CommitID作为本次migrate的版本号
 
-	output, err = g.Command(g.flaskEnv(), "flask", "db", "migrate", message, revisionId)
-	if err != nil {
-		if strings.Contains(err.Error(), dbNotUpToDate) {
-			g.logger.WarnWithFlag(dbNotUpToDate)
-			err = nil
-		} else if strings.Contains(err.Error(), SchemaNoChanges) {
-			g.logger.WarnWithFlag(SchemaNoChanges)
-			err = nil
-		}
+	var env []string
+	if env, err = g.flaskEnv(); err != nil {
+		return
+	}
+	var stderr []byte
+	output, stderr, err = g.exec(ctx, g.migrationBuildDir(), env, "flask", "db", "migrate", message, revisionId)
+	// 没有变更时 flask db migrate 只会输出日志而不会失败，需要识别输出
+	if err == nil && classifyOutput(stderr, output) == ErrNoSchemaChanges {
+		err = newCommandError(Cmd{Name: "flask", Args: []string{"db", "migrate", message, revisionId}, Dir: g.migrationBuildDir()}, output, stderr, nil)
+	}
+	if errors.Is(err, ErrDatabaseNotUpToDate) {
+		g.logger.WarnContext(ctx, dbNotUpToDate, stageField(StageGenerateRevision))
+	} else if errors.Is(err, ErrNoSchemaChanges) {
+		g.logger.WarnContext(ctx, SchemaNoChanges, stageField(StageGenerateRevision))
 	}
 	return
 }
 
 func (g *migrate) Migrate(submitComment string) (revision Revision, err error) {
-	var deferFunc func()
-	deferFunc, err = g.prepare()
-	defer deferFunc()
-	if err != nil {
+	return g.MigrateContext(context.Background(), submitComment)
+}
+
+func (g *migrate) MigrateContext(ctx context.Context, submitComment string) (revision Revision, err error) {
+	start := time.Now()
+	defer func() { g.auditMigrate(start, revision, err) }()
+	var unlock func()
+	if unlock, err = g.lock(ctx); err != nil {
 		return
 	}
-	// 创建远程版本库
-	err = g.createDatabaseIfNotExists()
-	if err != nil {
+	defer unlock()
+	if err = g.prepare(ctx); err != nil {
 		return
 	}
-	err = g.generateRevisionScript(submitComment)
+	// 创建远程版本库
+	err = g.createDatabaseIfNotExists(ctx)
 	if err != nil {
 		return
 	}
-	return g.ShowLocalRevision("")
-}
-
-func (g *migrate) MigrateOnly(submitComment string) (err error) {
-	var deferFunc func()
-	deferFunc, err = g.prepare()
-	defer deferFunc()
-	if err != nil {
+	err = g.generateRevisionScript(ctx, submitComment)
+	if err != nil && !noNewRevision(err) {
 		return
 	}
-	return g.generateRevisionScript(submitComment)
+	// 没有生成新版本时仍返回当前 head 版本，由调用方决定 ErrNoSchemaChanges/ErrDatabaseNotUpToDate 是否视为成功
+	var headErr error
+	if revision, headErr = g.ShowLocalRevisionContext(ctx, ""); headErr != nil {
+		err = headErr
+	}
+	return
 }
 
-type Revision struct {
-	Rev        string
-	Parent     string
-	Path       string
-	Message    string
-	RevisionId string
-	Revises    string
-	CreateDate string
-	Content    string
+func (g *migrate) MigrateOnly(submitComment string) (err error) {
+	return g.MigrateOnlyContext(context.Background(), submitComment)
 }
 
-func parseRevisions(s string) ([]Revision, error) {
-	var err error
-	var valid bool
-	var out []Revision
-	var index = -1
-	ss := strings.Split(s, "\n")
-	for _, str := range ss {
-		str = strings.TrimSpace(str)
-		if len(str) == 0 {
-			continue
-		}
-		strs := strings.SplitN(str, ":", 2)
-		if strs[0] == "Rev" {
-			valid = true
-			index++
-			out = append(out, Revision{Content: s})
-		}
-		if !valid {
-			continue
-		}
-		switch strs[0] {
-		case "Rev":
-			out[index].Rev = strings.TrimSpace(strs[1])
-		case "Parent":
-			out[index].Parent = strings.TrimSpace(strs[1])
-		case "Path":
-			out[index].Path = strings.TrimSpace(strs[1])
-		case "Revision ID":
-			out[index].RevisionId = strings.TrimSpace(strs[1])
-		case "Revises":
-			out[index].Revises = strings.TrimSpace(strs[1])
-		case "Create Date":
-			source := strings.TrimSpace(strs[1])
-			var target time.Time
-			target, err = time.Parse("2006-01-02 15:04:05.999999", source)
-			out[index].CreateDate = target.Format("2006-01-02 15:04:05")
-		default:
-			out[index].Message = strings.TrimSpace(strs[0])
-		}
+func (g *migrate) MigrateOnlyContext(ctx context.Context, submitComment string) (err error) {
+	var unlock func()
+	if unlock, err = g.lock(ctx); err != nil {
+		return
 	}
-	return out, err
+	defer unlock()
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	return g.generateRevisionScript(ctx, submitComment)
 }
 
 func (g *migrate) ShowLocalRevision(version string) (revision Revision, err error) {
-	g.logger.Info("show local revision...")
+	return g.ShowLocalRevisionContext(context.Background(), version)
+}
+
+func (g *migrate) ShowLocalRevisionContext(ctx context.Context, version string) (revision Revision, err error) {
+	g.logger.Info("show local revision...", stageField(StageShowLocalRevision))
 	var output []byte
 	defer func() {
-		g.logger.InfoWithFlag(err, "show local revision", ", revision:", revision, ", output:\n", string(output))
+		g.logger.InfoWithFlag(err, "show local revision", stageField(StageShowLocalRevision), revisionField(revision.RevisionId), Field{Key: "output", Value: string(output)})
 	}()
-	var deferFunc func()
-	deferFunc, err = g.prepare()
-	defer deferFunc()
+	ctx, cancel, err := g.stageContext(ctx, StageShowLocalRevision)
+	defer func() { err = g.stageError(ctx, StageShowLocalRevision, err); cancel() }()
 	if err != nil {
 		return
 	}
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
 	if len(version) > 0 {
-		output, err = g.Command(g.flaskEnv(), "flask", "db", "show", version)
+		output, err = g.flask(ctx, "db", "show", version)
 	} else {
-		output, err = g.Command(g.flaskEnv(), "flask", "db", "show")
+		output, err = g.flask(ctx, "db", "show")
 	}
 	if err != nil {
 		return
@@ -376,18 +449,30 @@ func (g *migrate) ShowLocalRevision(version string) (revision Revision, err erro
 }
 
 func (g *migrate) ShowDatabaseRevision() (revision Revision, err error) {
-	g.logger.Info("show remote revision...")
+	return g.ShowDatabaseRevisionContext(context.Background())
+}
+
+func (g *migrate) ShowDatabaseRevisionContext(ctx context.Context) (revision Revision, err error) {
+	g.logger.Info("show remote revision...", stageField(StageShowDatabaseRevision))
 	var output []byte
 	defer func() {
-		g.logger.InfoWithFlag(err, "show remote revision", ", revision:", revision, ", output:\n", string(output))
+		g.logger.InfoWithFlag(err, "show remote revision", stageField(StageShowDatabaseRevision), revisionField(revision.RevisionId), Field{Key: "output", Value: string(output)})
 	}()
-	var deferFunc func()
-	deferFunc, err = g.prepare()
-	defer deferFunc()
+	ctx, cancel, err := g.stageContext(ctx, StageShowDatabaseRevision)
+	defer func() { err = g.stageError(ctx, StageShowDatabaseRevision, err); cancel() }()
 	if err != nil {
 		return
 	}
-	output, err = g.Command(g.flaskEnv(), "flask", "db", "current", "--verbose")
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	revision, output, err = g.databaseRevision(ctx)
+	return
+}
+
+// databaseRevision 执行 flask db current，调用前需已 prepare
+func (g *migrate) databaseRevision(ctx context.Context) (revision Revision, output []byte, err error) {
+	output, err = g.flask(ctx, "db", "current", "--verbose")
 	if err != nil {
 		return
 	}
@@ -399,30 +484,58 @@ func (g *migrate) ShowDatabaseRevision() (revision Revision, err error) {
 	if len(revisions) == 0 {
 		return
 	}
-	return revisions[0], nil
+	return revisions[0], output, nil
+}
+
+// revisionState 获取本地版本图及数据库当前版本号，调用前需已 prepare
+func (g *migrate) revisionState(ctx context.Context) (graph *RevisionGraph, current string, err error) {
+	var revisions []Revision
+	if revisions, err = g.history(ctx); err != nil {
+		return
+	}
+	if graph, err = NewRevisionGraph(revisions); err != nil {
+		return
+	}
+	var revision Revision
+	if revision, _, err = g.databaseRevision(ctx); err != nil {
+		return
+	}
+	current = revision.RevisionId
+	return
 }
 
 func (g *migrate) ShowDDL(ddlFileName string, latest bool) (ddl string, err error) {
-	g.logger.Info("show ddl...")
+	return g.ShowDDLContext(context.Background(), ddlFileName, latest)
+}
+
+func (g *migrate) ShowDDLContext(ctx context.Context, ddlFileName string, latest bool) (ddl string, err error) {
+	g.logger.Info("show ddl...", stageField(StageShowDDL))
 	var output []byte
 	defer func() {
-		g.logger.InfoWithFlag(err, "show ddl", ", output:\n", string(output))
+		g.logger.InfoWithFlag(err, "show ddl", stageField(StageShowDDL), Field{Key: "output", Value: string(output)})
 	}()
-	var deferFunc func()
-	deferFunc, err = g.prepare()
-	defer deferFunc()
+	ctx, cancel, err := g.stageContext(ctx, StageShowDDL)
+	defer func() { err = g.stageError(ctx, StageShowDDL, err); cancel() }()
 	if err != nil {
 		return
 	}
-	output, err = g.Command(g.flaskEnv(), "flask", "db", "upgrade", "--sql")
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	output, err = g.flask(ctx, "db", "upgrade", "--sql")
 	if err != nil {
 		return
 	}
+	// 破坏性 DDL 被 DDLPolicy 拒绝时仍返回 DDL，但不写入文件
+	if err = g.checkShowDDL(ctx, output); err != nil {
+		ddl = string(output)
+		return
+	}
 	var migrationBuildDir = g.migrationBuildDir()
 	if len(ddlFileName) > 0 {
 		filePath := filepath.Join(migrationBuildDir, ddlFileName)
 		if latest {
-			output, err = g.generateUpdateDDLFile(output)
+			output, err = g.generateUpdateDDLFile(ctx, output)
 			if err != nil {
 				return
 			}
@@ -441,31 +554,70 @@ func (g *migrate) ShowDDL(ddlFileName string, latest bool) (ddl string, err erro
 	return
 }
 
-func (g *migrate) generateUpdateDDLFile(content []byte) (updateContent []byte, err error) {
-	var deferFunc func()
-	deferFunc, err = g.prepare()
-	defer deferFunc()
-	if err != nil {
+// checkShowDDL 按 DDLPolicy 检查离线 SQL 中的破坏性 DDL，只检查数据库当前版本之后的版本，已执行的版本无需再告警或拒绝，
+// 数据库不可连接时检查所有版本，调用前需已 prepare
+func (g *migrate) checkShowDDL(ctx context.Context, output []byte) (err error) {
+	if g.conf.GetDDLPolicy() == DDLPolicyOff {
 		return
 	}
-	// 获取远程数据库的版本号
-	var dbRevision, scriptRevision Revision
-	if dbRevision, err = g.ShowDatabaseRevision(); err != nil {
-		return
+	_, steps := parseOfflineSQL(g.dialectName(), string(output))
+	var (
+		graph   *RevisionGraph
+		current string
+	)
+	if graph, current, err = g.revisionState(ctx); err != nil {
+		if !errors.Is(err, ErrConnection) {
+			return
+		}
+		g.logger.WarnContext(ctx, "database revision unavailable, checking DDL of all revisions", stageField(StageShowDDL), Field{Key: FieldError, Value: err})
+		current = ""
 	}
-	// 获取当前脚本的版本号
-	if scriptRevision, err = g.ShowLocalRevision(""); err != nil {
-		return
+	if current != "" {
+		pending := make(map[string]bool)
+		for _, id := range graph.Descendants(current) {
+			pending[id] = true
+		}
+		var filtered []offlineStep
+		for _, step := range steps {
+			if pending[step.To] {
+				filtered = append(filtered, step)
+			}
+		}
+		steps = filtered
 	}
-	if scriptRevision.RevisionId == dbRevision.RevisionId {
-		// 远程数据库最新，无需生成
+	_, err = g.checkDDL(ctx, nil, steps)
+	return
+}
+
+// generateUpdateDDLFile 从离线 SQL 中截取数据库当前版本之后的版本，调用前需已 prepare
+func (g *migrate) generateUpdateDDLFile(ctx context.Context, content []byte) (updateContent []byte, err error) {
+	var (
+		graph   *RevisionGraph
+		current string
+	)
+	if graph, current, err = g.revisionState(ctx); err != nil {
 		return
 	}
-	contents := strings.SplitN(string(content), scriptRevision.RevisionId, 2)
-	if len(contents) > 1 {
-		updateContent = []byte(contents[1])
+	if current == "" {
+		// 数据库尚未升级过，所有版本均需执行
+		return content, nil
 	}
-	return
+	pending := make(map[string]bool)
+	for _, id := range graph.Descendants(current) {
+		pending[id] = true
+	}
+	_, steps := parseOfflineSQL(g.dialectName(), string(content))
+	var b strings.Builder
+	for _, step := range steps {
+		if !pending[step.To] {
+			continue
+		}
+		b.WriteString(fmt.Sprintf(runningUpgradeComment, step.From, step.To) + "\n\n")
+		for _, stmt := range step.Statements {
+			b.WriteString(stmt + ";\n\n")
+		}
+	}
+	return []byte(b.String()), nil
 }
 
 func (g *migrate) deleteAlembicVersionUpdateAndInsertContent(content []byte) (ddlContent []byte, err error) {
@@ -485,52 +637,523 @@ func (g *migrate) deleteAlembicVersionUpdateAndInsertContent(content []byte) (dd
 }
 
 func (g *migrate) Upgrade() (err error) {
-	g.logger.Info("upgrade...")
-	var output []byte
+	return g.UpgradeContext(context.Background())
+}
+
+func (g *migrate) UpgradeContext(ctx context.Context) (err error) {
+	return g.UpgradeToContext(ctx, TargetHead)
+}
+
+func (g *migrate) UpgradeTo(target string) (err error) {
+	return g.UpgradeToContext(context.Background(), target)
+}
+
+func (g *migrate) UpgradeToContext(ctx context.Context, target string) (err error) {
+	g.logger.Info("upgrade...", stageField(StageUpgrade))
+	var (
+		output   []byte
+		current  string
+		revision string
+		start    = time.Now()
+	)
 	defer func() {
-		g.logger.InfoWithFlag(err, "upgrade", ", output:\n", string(output))
+		g.logger.InfoWithFlag(err, "upgrade", stageField(StageUpgrade), Field{Key: "target", Value: target}, Field{Key: "from", Value: current}, revisionField(revision), Field{Key: "output", Value: string(output)})
+		g.observeOperation(AuditUpgrade, start, err)
+		if err != nil || revision != current {
+			g.audit(AuditUpgrade, start, current, revision, err)
+		}
 	}()
-	var deferFunc func()
-	deferFunc, err = g.prepare()
-	defer deferFunc()
+	ctx, cancel, err := g.stageContext(ctx, StageUpgrade)
+	defer func() { err = g.stageError(ctx, StageUpgrade, err); cancel() }()
 	if err != nil {
 		return
 	}
-	output, err = g.Command(g.flaskEnv(), "flask", "db", "upgrade")
+	var unlock func()
+	if unlock, err = g.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	// 先依据本地版本图校验目标版本，存在多个 head、目标版本不存在等情况下不会执行 flask db upgrade
+	var graph *RevisionGraph
+	if graph, current, err = g.revisionState(ctx); err != nil {
+		return
+	}
+	if revision, err = resolveTarget(graph, current, target, true); err != nil {
+		return
+	}
+	if revision == current {
+		return
+	}
+	// 检查破坏性 DDL 及在线表结构变更都需要先以离线模式获取待执行的 SQL，多执行一次 flask db upgrade --sql，DDLPolicy 为 off 时跳过
+	var (
+		preamble []string
+		steps    []offlineStep
+	)
+	if g.conf.GetDDLPolicy() != DDLPolicyOff || g.oscEnabled() {
+		if preamble, steps, err = g.upgradeSQL(ctx, current, revision); err != nil {
+			return
+		}
+		if _, err = g.checkDDL(ctx, nil, steps); err != nil {
+			return
+		}
+	}
+	if g.oscEnabled() {
+		err = g.upgradeOnline(ctx, preamble, steps)
+		return
+	}
+	var path []string
+	if path, err = graph.Path(current, revision); err != nil {
+		return
+	}
+	if !g.dataMigrations().Has(path...) && len(g.conf.GetHooks()) == 0 {
+		if output, err = g.flask(ctx, "db", "upgrade", revision); err == nil {
+			g.observeApplied(StageUpgrade, len(path))
+		}
+		return
+	}
+	// 存在数据迁移或 Hook 时逐个版本升级，每个版本的 DDL 执行之后执行其数据迁移
+	var db *sql.DB
+	if db, err = g.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	from := current
+	for _, id := range path {
+		revisionStart := time.Now()
+		if err = g.beforeRevision(ctx, StageUpgrade, from, id); err != nil {
+			return
+		}
+		var out []byte
+		out, err = g.flask(ctx, "db", "upgrade", id)
+		output = append(output, out...)
+		if err != nil {
+			return
+		}
+		if err = g.applyDataMigrations(ctx, db, id); err != nil {
+			return
+		}
+		g.revisionApplied(ctx, StageUpgrade, from, id, revisionStart)
+		from = id
+	}
 	return
 }
 
-func (g *migrate) Downgrade() (err error) {
-	g.logger.Info("downgrade...")
+// upgradeSQL 以离线模式获取 current 到 revision 的 SQL，调用前需已 prepare
+func (g *migrate) upgradeSQL(ctx context.Context, current, revision string) (preamble []string, steps []offlineStep, err error) {
+	rng := revision
+	if current != "" {
+		rng = current + ":" + revision
+	}
 	var output []byte
+	if output, err = g.flask(ctx, "db", "upgrade", "--sql", rng); err != nil {
+		return
+	}
+	preamble, steps = parseOfflineSQL(g.dialectName(), string(output))
+	return
+}
+
+// upgradeOnline 由 Go 逐个版本执行离线 SQL，符合条件的 ALTER TABLE 以在线表结构变更执行，每个版本之后执行其数据迁移
+func (g *migrate) upgradeOnline(ctx context.Context, preamble []string, steps []offlineStep) (err error) {
+	var db *sql.DB
+	if db, err = g.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	if err = execStatements(ctx, db, preamble); err != nil {
+		return
+	}
+	for _, step := range steps {
+		revisionStart := time.Now()
+		if err = g.beforeRevision(ctx, StageUpgrade, step.From, step.To); err != nil {
+			return
+		}
+		if err = g.execOnline(ctx, db, step.Statements); err != nil {
+			return fmt.Errorf("upgrade %s -> %s failed: %w", step.From, step.To, err)
+		}
+		if err = g.applyDataMigrations(ctx, db, step.To); err != nil {
+			return
+		}
+		g.revisionApplied(ctx, StageUpgrade, step.From, step.To, revisionStart)
+	}
+	return
+}
+
+func (g *migrate) Downgrade() (err error) {
+	return g.DowngradeContext(context.Background())
+}
+
+func (g *migrate) DowngradeContext(ctx context.Context) (err error) {
+	return g.DowngradeToContext(ctx, "-1")
+}
+
+func (g *migrate) DowngradeTo(target string) (err error) {
+	return g.DowngradeToContext(context.Background(), target)
+}
+
+func (g *migrate) DowngradeToContext(ctx context.Context, target string) (err error) {
+	g.logger.Info("downgrade...", stageField(StageDowngrade))
+	var (
+		output   []byte
+		current  string
+		revision string
+		start    = time.Now()
+	)
 	defer func() {
-		g.logger.InfoWithFlag(err, "downgrade", ", output:\n", string(output))
+		g.logger.InfoWithFlag(err, "downgrade", stageField(StageDowngrade), Field{Key: "target", Value: target}, Field{Key: "from", Value: current}, revisionField(revision), Field{Key: "output", Value: string(output)})
+		g.observeOperation(AuditDowngrade, start, err)
+		if err != nil || revision != current {
+			g.audit(AuditDowngrade, start, current, revision, err)
+		}
 	}()
-	var deferFunc func()
-	deferFunc, err = g.prepare()
-	defer deferFunc()
+	ctx, cancel, err := g.stageContext(ctx, StageDowngrade)
+	defer func() { err = g.stageError(ctx, StageDowngrade, err); cancel() }()
 	if err != nil {
 		return
 	}
-	output, err = g.Command(g.flaskEnv(), "flask", "db", "downgrade")
+	var unlock func()
+	if unlock, err = g.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	var graph *RevisionGraph
+	if graph, current, err = g.revisionState(ctx); err != nil {
+		return
+	}
+	if revision, err = resolveTarget(graph, current, target, false); err != nil {
+		return
+	}
+	if revision == current {
+		return
+	}
+	to := revision
+	if to == "" {
+		to = TargetBase
+	}
+	var reverted []string
+	if reverted, err = graph.Path(revision, current); err != nil {
+		return
+	}
+	if !g.dataMigrations().Has(reverted...) && len(g.conf.GetHooks()) == 0 {
+		if output, err = g.flask(ctx, "db", "downgrade", to); err == nil {
+			g.observeApplied(StageDowngrade, len(reverted))
+		}
+		return
+	}
+	// 存在数据迁移或 Hook 时逐个版本回退，回退每个版本的 DDL 之前先回退其数据迁移
+	var db *sql.DB
+	if db, err = g.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	for i := len(reverted) - 1; i >= 0; i-- {
+		step, previous, revisionStart := to, revision, time.Now()
+		if i > 0 {
+			step, previous = reverted[i-1], reverted[i-1]
+		}
+		if err = g.beforeRevision(ctx, StageDowngrade, reverted[i], previous); err != nil {
+			return
+		}
+		if err = g.revertDataMigrations(ctx, db, reverted[i]); err != nil {
+			return
+		}
+		var out []byte
+		out, err = g.flask(ctx, "db", "downgrade", step)
+		output = append(output, out...)
+		if err != nil {
+			return
+		}
+		g.revisionApplied(ctx, StageDowngrade, reverted[i], previous, revisionStart)
+	}
 	return
 }
 
 func (g *migrate) History() (revisions []Revision, err error) {
-	g.logger.Info("history...")
-	var output []byte
+	return g.HistoryContext(context.Background())
+}
+
+func (g *migrate) HistoryContext(ctx context.Context) (revisions []Revision, err error) {
+	g.logger.Info("history...", stageField(StageHistory))
 	defer func() {
-		g.logger.InfoWithFlag(err, "history", ", output:\n", string(output))
+		g.logger.InfoWithFlag(err, "history", stageField(StageHistory), Field{Key: "revisions", Value: len(revisions)})
 	}()
-	var deferFunc func()
-	deferFunc, err = g.prepare()
-	defer deferFunc()
+	ctx, cancel, err := g.stageContext(ctx, StageHistory)
+	defer func() { err = g.stageError(ctx, StageHistory, err); cancel() }()
 	if err != nil {
 		return
 	}
-	output, err = g.Command(g.flaskEnv(), "flask", "db", "history", "--verbose")
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	return g.history(ctx)
+}
+
+// history 执行 flask db history，调用前需已 prepare
+func (g *migrate) history(ctx context.Context) (revisions []Revision, err error) {
+	var output []byte
+	output, err = g.flask(ctx, "db", "history", "--verbose")
 	if err != nil {
 		return
 	}
 	return parseRevisions(string(output))
 }
+
+func (g *migrate) Plan() (plan Plan, err error) {
+	return g.PlanContext(context.Background())
+}
+
+// PlanContext 以 `flask db upgrade --sql current:head` 输出的离线 SQL 构建升级计划
+func (g *migrate) PlanContext(ctx context.Context) (plan Plan, err error) {
+	g.logger.Info("plan...", stageField(StagePlan))
+	defer func() {
+		g.logger.InfoWithFlag(err, "plan", stageField(StagePlan), Field{Key: "database", Value: plan.DatabaseRevision}, Field{Key: "head", Value: plan.Head}, Field{Key: "steps", Value: len(plan.Steps)}, Field{Key: "risk", Value: plan.Risk})
+	}()
+	ctx, cancel, err := g.stageContext(ctx, StagePlan)
+	defer func() { err = g.stageError(ctx, StagePlan, err); cancel() }()
+	if err != nil {
+		return
+	}
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	var (
+		graph         *RevisionGraph
+		current, head string
+	)
+	if graph, current, err = g.revisionState(ctx); err != nil {
+		return
+	}
+	if head, err = graph.Head(); err != nil {
+		return
+	}
+	var (
+		preamble []string
+		steps    []offlineStep
+	)
+	if head != "" && head != current {
+		if preamble, steps, err = g.upgradeSQL(ctx, current, head); err != nil {
+			return
+		}
+	}
+	return g.plan(ctx, nil, graph, current, head, preamble, steps)
+}
+
+func (g *migrate) Status() (status Status, err error) {
+	return g.StatusContext(context.Background())
+}
+
+func (g *migrate) StatusContext(ctx context.Context) (status Status, err error) {
+	g.logger.Info("status...", stageField(StageStatus))
+	defer func() {
+		g.logger.InfoWithFlag(err, "status", stageField(StageStatus), Field{Key: "head", Value: status.Head}, Field{Key: "database", Value: status.DatabaseRevision}, Field{Key: "state", Value: status.State})
+	}()
+	ctx, cancel, err := g.stageContext(ctx, StageStatus)
+	defer func() { err = g.stageError(ctx, StageStatus, err); cancel() }()
+	if err != nil {
+		return
+	}
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	var (
+		graph   *RevisionGraph
+		current string
+	)
+	if graph, current, err = g.revisionState(ctx); err != nil {
+		return
+	}
+	return g.status(ctx, nil, graph, current)
+}
+
+func (g *migrate) Stamp(revision string) (err error) {
+	return g.StampContext(context.Background(), revision)
+}
+
+// StampContext 以 `flask db stamp` 改写 alembic_version 中的版本号，不执行版本中的迁移
+func (g *migrate) StampContext(ctx context.Context, revision string) (err error) {
+	g.logger.Info("stamp...", stageField(StageStamp))
+	var (
+		output  []byte
+		current string
+		target  string
+		start   = time.Now()
+	)
+	defer func() {
+		g.logger.InfoWithFlag(err, "stamp", stageField(StageStamp), Field{Key: "target", Value: revision}, Field{Key: "from", Value: current}, revisionField(target), Field{Key: "output", Value: string(output)})
+		g.observeOperation(AuditStamp, start, err)
+		if err != nil || target != current {
+			g.audit(AuditStamp, start, current, target, err)
+		}
+	}()
+	ctx, cancel, err := g.stageContext(ctx, StageStamp)
+	defer func() { err = g.stageError(ctx, StageStamp, err); cancel() }()
+	if err != nil {
+		return
+	}
+	var unlock func()
+	if unlock, err = g.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	var graph *RevisionGraph
+	if graph, current, err = g.revisionState(ctx); err != nil {
+		return
+	}
+	if target, err = resolveStampTarget(graph, revision); err != nil || target == current {
+		return
+	}
+	stampTarget := target
+	if stampTarget == "" {
+		stampTarget = TargetBase
+	}
+	output, err = g.flask(ctx, "db", "stamp", stampTarget)
+	return
+}
+
+func (g *migrate) Baseline() (revision Revision, err error) {
+	return g.BaselineContext(context.Background())
+}
+
+// BaselineContext 以 `flask db revision` 生成空版本并填入数据库中现有表的 CREATE TABLE，
+// 以 `flask db upgrade --sql` 输出的离线 SQL 校验与数据库表结构一致后 `flask db stamp`，不执行 DDL
+func (g *migrate) BaselineContext(ctx context.Context) (revision Revision, err error) {
+	g.logger.Info("baseline...", stageField(StageBaseline))
+	var (
+		revisionId string
+		path       string
+		start      = time.Now()
+	)
+	defer func() {
+		g.logger.InfoWithFlag(err, "baseline", stageField(StageBaseline), revisionField(revisionId), Field{Key: "path", Value: path})
+		g.audit(AuditBaseline, start, "", revisionId, err)
+	}()
+	ctx, cancel, err := g.stageContext(ctx, StageBaseline)
+	defer func() { err = g.stageError(ctx, StageBaseline, err); cancel() }()
+	if err != nil {
+		return
+	}
+	// PostgreSQL、SQLite 没有 SHOW CREATE TABLE，无法导出现有的表结构，在加锁、生成版本之前拒绝
+	if err = g.requireMySQL("baseline"); err != nil {
+		return
+	}
+	var unlock func()
+	if unlock, err = g.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	var (
+		graph   *RevisionGraph
+		current string
+	)
+	if graph, current, err = g.revisionState(ctx); err != nil {
+		return
+	}
+	if err = checkUnversioned(graph, current); err != nil {
+		return
+	}
+	var db *sql.DB
+	if db, err = g.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var up, down []string
+	if up, down, err = g.baselineStatements(ctx, db); err != nil {
+		return
+	}
+	if revisionId, err = g.revisionId(); err != nil {
+		return
+	}
+	if err = os.MkdirAll(filepath.Join(g.migrationBuildDir(), migrationsVersionsDir), 0755); err != nil {
+		return
+	}
+	if _, err = g.flask(ctx, "db", "revision", "--message="+baselineMessage, "--rev-id="+revisionId); err != nil {
+		return
+	}
+	// 校验或标记失败时删除生成的版本，保持与执行之前一致
+	defer func() {
+		if err != nil && path != "" {
+			_ = os.Remove(path)
+		}
+	}()
+	if revision, err = g.ShowLocalRevisionContext(ctx, revisionId); err != nil {
+		return
+	}
+	if path = revision.Path; !filepath.IsAbs(path) {
+		path = filepath.Join(g.migrationBuildDir(), path)
+	}
+	var script []byte
+	if script, err = xos.FileGetContents(path); err != nil {
+		return
+	}
+	var filled string
+	if filled, err = fillAlembicRevision(string(script), up, down); err != nil {
+		return
+	}
+	if err = xos.FilePutContents(path, []byte(filled)); err != nil {
+		return
+	}
+	// 以 Alembic 渲染出的离线 SQL 校验，与之后 Upgrade 在新库上执行的语句一致
+	var output []byte
+	if output, err = g.flask(ctx, "db", "upgrade", "--sql"); err != nil {
+		return
+	}
+	preamble, steps := parseOfflineSQL(g.dialectName(), string(output))
+	statements := preamble
+	for _, step := range steps {
+		statements = append(statements, step.Statements...)
+	}
+	if err = g.checkBaseline(ctx, db, revisionId, statements); err != nil {
+		return
+	}
+	_, err = g.flask(ctx, "db", "stamp", revisionId)
+	return
+}
+
+func (g *migrate) Drift() (report DriftReport, err error) {
+	return g.DriftContext(context.Background())
+}
+
+// DriftContext 以 `flask db upgrade --sql` 输出的 base 到 head 的离线 SQL 推导期望的表结构，与数据库对比
+func (g *migrate) DriftContext(ctx context.Context) (report DriftReport, err error) {
+	g.logger.Info("drift...", stageField(StageDrift))
+	defer func() {
+		g.logger.InfoWithFlag(err, "drift", stageField(StageDrift), Field{Key: "head", Value: report.Head}, Field{Key: "database", Value: report.DatabaseRevision}, Field{Key: "items", Value: len(report.Items)})
+	}()
+	ctx, cancel, err := g.stageContext(ctx, StageDrift)
+	defer func() { err = g.stageError(ctx, StageDrift, err); cancel() }()
+	if err != nil {
+		return
+	}
+	if err = g.prepare(ctx); err != nil {
+		return
+	}
+	var graph *RevisionGraph
+	if graph, report.DatabaseRevision, err = g.revisionState(ctx); err != nil {
+		return
+	}
+	if report.Head, err = graph.Head(); err != nil {
+		return
+	}
+	var output []byte
+	if output, err = g.flask(ctx, "db", "upgrade", "--sql"); err != nil {
+		return
+	}
+	preamble, steps := parseOfflineSQL(g.dialectName(), string(output))
+	statements := preamble
+	for _, step := range steps {
+		statements = append(statements, step.Statements...)
+	}
+	report.Items, err = g.drift(ctx, nil, statements)
+	return
+}
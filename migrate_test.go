@@ -0,0 +1,110 @@
+package migration
+
+import (
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestShowDDLChecksPendingRevisions(t *testing.T) {
+	offline := renderOfflineSQL(true, []offlineStep{
+		{Upgrade: true, From: "", To: "a", Statements: []string{"DROP TABLE legacy", upgradeVersionStatement("", "a")}},
+		{Upgrade: true, From: "a", To: "b", Statements: []string{"CREATE TABLE user (id INT)", upgradeVersionStatement("a", "b")}},
+	})
+	history := Revision{RevisionId: "b", Parents: []string{"a"}, IsHead: true, Path: "b_.py", Doc: "b"}.LogEntry() + "\n" +
+		Revision{RevisionId: "a", Path: "a_.py", Doc: "a"}.LogEntry()
+	for _, tc := range []struct {
+		name    string
+		current Reply
+		wantErr error
+	}{
+		{name: "applied", current: Reply{Stdout: Revision{RevisionId: "a", Path: "a_.py", Doc: "a"}.LogEntry()}},
+		{name: "pending", current: Reply{}, wantErr: ErrDestructiveDDL},
+		{name: "unreachable", current: Reply{Stderr: "Can't connect to MySQL server on 'db'", ExitCode: 1}, wantErr: ErrDestructiveDDL},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			replay := NewReplayExecutor().
+				On("db init", Reply{}).
+				On("db upgrade --sql", Reply{Stdout: offline}).
+				On("db history --verbose", Reply{Stdout: history}).
+				On("db current --verbose", tc.current)
+			// 表信息读取失败时仅依据 SQL 判断
+			g := newTestMigrate(t, replay, WithDDLPolicy(DDLPolicyBlock), WithDsn("root@tcp(127.0.0.1:1)/app"))
+			script := "import os\napp.config['SQLALCHEMY_DATABASE_URI'] = os.environ.get('MIGRATION_DATABASE_URI') or 'mysql+pymysql://root@127.0.0.1:1/app'\n"
+			if err := os.WriteFile(g.scriptFile(), []byte(script), 0644); err != nil {
+				t.Fatal(err)
+			}
+			ddl, err := g.ShowDDL("", false)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("ShowDDL() = %v, want %v", err, tc.wantErr)
+			}
+			if ddl != offline {
+				t.Fatalf("ShowDDL() = %q, want all revisions", ddl)
+			}
+		})
+	}
+}
+
+func TestGenerateDatabase(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []GenerateConfOption
+		want DSN
+	}{
+		{name: "defaults", want: DSN{Dialect: DialectMySQL, Host: defaultHost, Port: defaultMySQLPort, User: defaultUser, DBName: defaultDBName}},
+		{name: "postgres default port", opts: []GenerateConfOption{WithDialect(DialectPostgres)},
+			want: DSN{Dialect: DialectPostgres, Host: defaultHost, Port: defaultPostgresPort, User: defaultUser, DBName: defaultDBName}},
+		{name: "postgres explicit 3306", opts: []GenerateConfOption{WithDialect(DialectPostgres), WithDbPort(3306)},
+			want: DSN{Dialect: DialectPostgres, Host: defaultHost, Port: 3306, User: defaultUser, DBName: defaultDBName}},
+		{name: "deprecated mysql options", opts: []GenerateConfOption{WithMysqlHost("db"), WithMysqlPort(3307), WithMysqlUser("u"), WithMysqlPassword("p"), WithMysqlDbName("app")},
+			want: DSN{Dialect: DialectMySQL, Host: "db", Port: 3307, User: "u", Password: "p", DBName: "app"}},
+		{name: "db options win", opts: []GenerateConfOption{WithMysqlHost("db"), WithMysqlDbName("app"), WithDbHost("pg"), WithDbName("svc"), WithDialect(DialectPostgres)},
+			want: DSN{Dialect: DialectPostgres, Host: "pg", Port: defaultPostgresPort, User: defaultUser, DBName: "svc"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			g := newTestMigrate(t, NewReplayExecutor())
+			got, err := g.generateDatabase(NewGenerateConf(tc.opts...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(*got, tc.want) {
+				t.Fatalf("generateDatabase() = %+v, want %+v", *got, tc.want)
+			}
+		})
+	}
+}
+
+func TestUpgradeWithoutDatabase(t *testing.T) {
+	offline := renderOfflineSQL(true, []offlineStep{
+		{Upgrade: true, From: "", To: "a", Statements: []string{"DROP TABLE legacy", upgradeVersionStatement("", "a")}},
+	})
+	replay := NewReplayExecutor().
+		On("db init", Reply{}).
+		On("db history --verbose", Reply{Stdout: Revision{RevisionId: "a", IsHead: true, Path: "a_.py", Doc: "a"}.LogEntry()}).
+		On("db current --verbose", Reply{}).
+		On("db upgrade --sql", Reply{Stdout: offline}).
+		On("db upgrade a", Reply{})
+	// 与 Baseline 相同只配置脚本目录，migration 脚本从配置对象读取数据库连接，锁、破坏性 DDL 检查及审计均为默认值
+	h := &recordHandler{}
+	g := New(nil, WithScriptRoot(t.TempDir()), WithCommitID("c1"), WithExecutor(replay), WithLogHandler(h)).(*migrate)
+	if err := os.WriteFile(g.scriptFile(), []byte("app.config.from_object(Config)\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Upgrade(); err != nil {
+		t.Fatalf("Upgrade() = %v, want nil", err)
+	}
+	records := replay.Records()
+	if args := records[len(records)-1].Cmd.Args; !reflect.DeepEqual(args, []string{"db", "upgrade", "a"}) {
+		t.Fatalf("last command args %q, want db upgrade a", args)
+	}
+	for _, stage := range []string{StageLock, StageUpgrade} {
+		found := false
+		for _, r := range h.records {
+			found = found || r.Level == LevelWarn && r.field(FieldStage) == stage
+		}
+		if !found {
+			t.Fatalf("records %+v, want a warning in stage %s", h.records, stage)
+		}
+	}
+}
@@ -0,0 +1,1152 @@
+package migration
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/sandwich-go/boost/xos"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// native 纯 Go 实现的迁移后端，不依赖 Python/Flask-Migrate 运行时
+// 版本文件位于 <ScriptRoot>/migrations/versions 目录下，每个版本由 <rev>_<slug>.up.sql 与 <rev>_<slug>.down.sql 组成，
+// 文件头部以注释记录 Revision ID/Revises/Create Date，数据库当前版本记录在与 Alembic 兼容的 alembic_version 表中
+// 目录中由 Alembic 生成的 .py 版本作为历史版本加载，数据库可从这些版本继续升级到之后的 .sql 版本，但 native 后端不执行 .py 版本
+type native struct {
+	*core
+}
+
+// nativeRevision 本地 .sql 版本文件，或由 Alembic 生成的 .py 版本文件
+type nativeRevision struct {
+	Message    string
+	RevisionId string
+	Revises    string
+	CreateDate time.Time
+	UpFile     string
+	DownFile   string
+	// Script Alembic 的 .py 版本文件，不为空时 UpFile/DownFile 为空
+	Script string
+}
+
+// offlineStep 离线 SQL 中的一个版本步骤
+type offlineStep struct {
+	Upgrade    bool
+	From       string
+	To         string
+	Statements []string
+}
+
+var revisionSlugRegexp = regexp.MustCompile(`\W+`)
+
+func (n *native) versionsDir() string {
+	return filepath.Join(n.migrationBuildDir(), migrationsVersionsDir)
+}
+
+func (n *native) Generate(opts ...GenerateConfOption) error {
+	return n.GenerateContext(context.Background(), opts...)
+}
+
+func (n *native) GenerateContext(ctx context.Context, _ ...GenerateConfOption) (err error) {
+	n.logger.Info("generate native migration versions directory...", stageField(StageGenerate))
+	dir := n.versionsDir()
+	defer func() {
+		n.logger.InfoWithFlag(err, "generate native migration versions directory", stageField(StageGenerate), Field{Key: "dir", Value: dir})
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageGenerate)
+	defer func() { err = n.stageError(ctx, StageGenerate, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	return os.MkdirAll(dir, 0755)
+}
+
+func (n *native) Migrate(submitComment string) (revision Revision, err error) {
+	return n.MigrateContext(context.Background(), submitComment)
+}
+
+func (n *native) MigrateContext(ctx context.Context, submitComment string) (revision Revision, err error) {
+	start := time.Now()
+	defer func() { n.auditMigrate(start, revision, err) }()
+	var unlock func()
+	if unlock, err = n.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+	// 创建远程版本库
+	err = n.createDatabaseIfNotExists(ctx)
+	if err != nil {
+		return
+	}
+	err = n.generateRevision(ctx, submitComment)
+	if err != nil && !noNewRevision(err) {
+		return
+	}
+	var headErr error
+	if revision, headErr = n.ShowLocalRevisionContext(ctx, ""); headErr != nil {
+		err = headErr
+	}
+	return
+}
+
+func (n *native) MigrateOnly(submitComment string) (err error) {
+	return n.MigrateOnlyContext(context.Background(), submitComment)
+}
+
+// MigrateOnlyContext native 后端无法自动对比 schema，只会以 CommitID 为版本号生成空的 up/down 版本文件，SQL 需手动编写
+func (n *native) MigrateOnlyContext(ctx context.Context, submitComment string) (err error) {
+	var unlock func()
+	if unlock, err = n.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+	return n.generateRevision(ctx, submitComment)
+}
+
+// generateRevision 生成新版本文件，调用方需已持有迁移锁
+func (n *native) generateRevision(ctx context.Context, submitComment string) (err error) {
+	n.logger.Info("generate native revision files...", stageField(StageGenerateRevision))
+	var upFile, downFile string
+	defer func() {
+		n.logger.InfoWithFlag(err, "generate native revision files", stageField(StageGenerateRevision), Field{Key: "up", Value: upFile}, Field{Key: "down", Value: downFile})
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageGenerateRevision)
+	defer func() { err = n.stageError(ctx, StageGenerateRevision, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var revisions []*nativeRevision
+	if revisions, err = n.loadRevisions(); err != nil {
+		return
+	}
+	var revisionId string
+	if revisionId, err = n.revisionId(); err != nil {
+		return
+	}
+	var parent string
+	for _, r := range revisions {
+		if r.RevisionId == revisionId {
+			err = fmt.Errorf("revision '%s' already exists: %w", revisionId, ErrNoSchemaChanges)
+			n.logger.WarnContext(ctx, err, stageField(StageGenerateRevision), revisionField(revisionId))
+			return
+		}
+		parent = r.RevisionId
+	}
+	upFile, downFile, err = n.writeRevisionFiles(revisionId, parent, n.revisionMessage(submitComment),
+		"-- upgrade SQL, statements separated by ';'\n", "-- downgrade SQL, statements separated by ';'\n")
+	return
+}
+
+// writeRevisionFiles 生成 up/down 版本文件，文件头记录版本号、父版本及创建时间
+func (n *native) writeRevisionFiles(revisionId, parent, message, up, down string) (upFile, downFile string, err error) {
+	if err = os.MkdirAll(n.versionsDir(), 0755); err != nil {
+		return
+	}
+	slug := strings.Trim(revisionSlugRegexp.ReplaceAllString(strings.ToLower(message), "_"), "_")
+	if len(slug) > 40 {
+		slug = slug[:40]
+	}
+	base := filepath.Join(n.versionsDir(), fmt.Sprintf("%s_%s", revisionId, slug))
+	upFile, downFile = base+nativeUpSuffix, base+nativeDownSuffix
+	header := fmt.Sprintf("-- %s\n--\n-- Revision ID: %s\n-- Revises: %s\n-- Create Date: %s\n\n",
+		message, revisionId, parent, time.Now().Format(revisionCreateDateLayout))
+	if err = xos.FilePutContents(upFile, []byte(header+up)); err != nil {
+		return
+	}
+	err = xos.FilePutContents(downFile, []byte(header+down))
+	return
+}
+
+func (n *native) ShowLocalRevision(version string) (revision Revision, err error) {
+	return n.ShowLocalRevisionContext(context.Background(), version)
+}
+
+func (n *native) ShowLocalRevisionContext(ctx context.Context, version string) (revision Revision, err error) {
+	n.logger.Info("show local revision...", stageField(StageShowLocalRevision))
+	defer func() {
+		n.logger.InfoWithFlag(err, "show local revision", stageField(StageShowLocalRevision), revisionField(revision.RevisionId))
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageShowLocalRevision)
+	defer func() { err = n.stageError(ctx, StageShowLocalRevision, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var revisions []*nativeRevision
+	if revisions, err = n.loadRevisions(); err != nil {
+		return
+	}
+	if len(revisions) == 0 {
+		return
+	}
+	head := revisions[len(revisions)-1]
+	if version == "" || version == "head" || version == "heads" {
+		return head.revision(true), nil
+	}
+	var found *nativeRevision
+	if found, err = findNativeRevision(revisions, version); err != nil {
+		return
+	}
+	return found.revision(found == head), nil
+}
+
+func (n *native) ShowDatabaseRevision() (revision Revision, err error) {
+	return n.ShowDatabaseRevisionContext(context.Background())
+}
+
+func (n *native) ShowDatabaseRevisionContext(ctx context.Context) (revision Revision, err error) {
+	n.logger.Info("show remote revision...", stageField(StageShowDatabaseRevision))
+	defer func() {
+		n.logger.InfoWithFlag(err, "show remote revision", stageField(StageShowDatabaseRevision), revisionField(revision.RevisionId))
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageShowDatabaseRevision)
+	defer func() { err = n.stageError(ctx, StageShowDatabaseRevision, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var db *sql.DB
+	if db, err = n.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var revisionId string
+	if revisionId, err = n.databaseRevisionId(ctx, db); err != nil || revisionId == "" {
+		return
+	}
+	var revisions []*nativeRevision
+	if revisions, err = n.loadRevisions(); err != nil {
+		return
+	}
+	for i, r := range revisions {
+		if r.RevisionId == revisionId {
+			return r.revision(i == len(revisions)-1), nil
+		}
+	}
+	// 数据库中的版本在本地不存在
+	return Revision{RevisionId: revisionId}, nil
+}
+
+func (n *native) ShowDDL(ddlFileName string, latest bool) (ddl string, err error) {
+	return n.ShowDDLContext(context.Background(), ddlFileName, latest)
+}
+
+// ShowDDLContext 输出与 `flask db upgrade --sql` 格式一致的离线 SQL，latest 为 true 时仅包含数据库当前版本之后的版本
+func (n *native) ShowDDLContext(ctx context.Context, ddlFileName string, latest bool) (ddl string, err error) {
+	n.logger.Info("show ddl...", stageField(StageShowDDL))
+	defer func() {
+		n.logger.InfoWithFlag(err, "show ddl", stageField(StageShowDDL), Field{Key: "output", Value: ddl})
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageShowDDL)
+	defer func() { err = n.stageError(ctx, StageShowDDL, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var revisions []*nativeRevision
+	if revisions, err = n.loadRevisions(); err != nil {
+		return
+	}
+	// 破坏性 DDL 只检查数据库当前版本之后的版本，latest 为 false 时数据库不可连接则检查所有版本
+	var current string
+	if latest || n.conf.GetDDLPolicy() != DDLPolicyOff {
+		if current, err = n.currentRevisionId(ctx); err != nil {
+			if latest || !errors.Is(err, ErrConnection) {
+				return
+			}
+			n.logger.WarnContext(ctx, "database revision unavailable, checking DDL of all revisions", stageField(StageShowDDL), Field{Key: FieldError, Value: err})
+			err = nil
+		}
+	}
+	from := current
+	if !latest {
+		from = ""
+	}
+	var pending, unapplied []*nativeRevision
+	if pending, err = pendingNativeRevisions(revisions, from); err != nil {
+		return
+	}
+	if unapplied, err = pendingNativeRevisions(revisions, current); err != nil {
+		return
+	}
+	check := make(map[string]bool, len(unapplied))
+	for _, r := range unapplied {
+		check[r.RevisionId] = true
+	}
+	var steps, checked []offlineStep
+	for _, r := range pending {
+		var stmts []string
+		if stmts, err = n.statements(r, true); err != nil {
+			return
+		}
+		step := offlineStep{Upgrade: true, From: r.Revises, To: r.RevisionId, Statements: stmts}
+		steps = append(steps, step)
+		if check[r.RevisionId] {
+			checked = append(checked, step)
+		}
+	}
+	ddl = renderOfflineSQL(from == "" && len(steps) > 0, steps)
+	// 破坏性 DDL 被 DDLPolicy 拒绝时仍返回 DDL，但不写入文件
+	if _, err = n.checkDDL(ctx, nil, checked); err != nil {
+		return
+	}
+	if len(ddlFileName) > 0 {
+		err = xos.FilePutContents(filepath.Join(n.migrationBuildDir(), ddlFileName), []byte(ddl))
+	}
+	return
+}
+
+func (n *native) Upgrade() (err error) {
+	return n.UpgradeContext(context.Background())
+}
+
+func (n *native) UpgradeContext(ctx context.Context) (err error) {
+	return n.UpgradeToContext(ctx, TargetHead)
+}
+
+func (n *native) UpgradeTo(target string) (err error) {
+	return n.UpgradeToContext(context.Background(), target)
+}
+
+func (n *native) UpgradeToContext(ctx context.Context, target string) (err error) {
+	n.logger.Info("upgrade...", stageField(StageUpgrade))
+	var (
+		current  string
+		revision string
+		applied  []string
+		start    = time.Now()
+	)
+	defer func() {
+		n.logger.InfoWithFlag(err, "upgrade", stageField(StageUpgrade), Field{Key: "target", Value: target}, Field{Key: "from", Value: current}, revisionField(revision), Field{Key: "applied", Value: applied})
+		n.observeOperation(AuditUpgrade, start, err)
+		if err != nil || revision != current {
+			n.audit(AuditUpgrade, start, current, revision, err)
+		}
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageUpgrade)
+	defer func() { err = n.stageError(ctx, StageUpgrade, err); cancel() }()
+	if err != nil {
+		return
+	}
+	var unlock func()
+	if unlock, err = n.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+
+	var db *sql.DB
+	if db, err = n.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var (
+		byId  map[string]*nativeRevision
+		graph *RevisionGraph
+	)
+	if byId, graph, current, err = n.revisionState(ctx, db); err != nil {
+		return
+	}
+	if revision, err = resolveTarget(graph, current, target, true); err != nil {
+		return
+	}
+	var path []string
+	if path, err = graph.Path(current, revision); err != nil || len(path) == 0 {
+		return
+	}
+	// 先读取所有待执行的版本并检查破坏性 DDL，再执行
+	steps := make([]offlineStep, 0, len(path))
+	for _, id := range path {
+		r := byId[id]
+		var stmts []string
+		if stmts, err = n.statements(r, true); err != nil {
+			return
+		}
+		steps = append(steps, offlineStep{Upgrade: true, From: r.Revises, To: r.RevisionId, Statements: stmts})
+	}
+	if _, err = n.checkDDL(ctx, db, steps); err != nil {
+		return
+	}
+	if _, err = db.ExecContext(ctx, strings.Replace(createAlembicVersionDDL, "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1)); err != nil {
+		return
+	}
+	for i, id := range path {
+		r, revisionStart := byId[id], time.Now()
+		if err = n.beforeRevision(ctx, StageUpgrade, r.Revises, r.RevisionId); err != nil {
+			return
+		}
+		stmts := append(steps[i].Statements, upgradeVersionStatement(r.Revises, r.RevisionId))
+		if err = n.execRevision(ctx, db, stmts, true); err != nil {
+			err = fmt.Errorf("upgrade %s -> %s failed: %w", r.Revises, r.RevisionId, err)
+			return
+		}
+		if err = n.applyDataMigrations(ctx, db, r.RevisionId); err != nil {
+			return
+		}
+		applied = append(applied, r.RevisionId)
+		n.revisionApplied(ctx, StageUpgrade, r.Revises, r.RevisionId, revisionStart)
+	}
+	return
+}
+
+func (n *native) Downgrade() (err error) {
+	return n.DowngradeContext(context.Background())
+}
+
+func (n *native) DowngradeContext(ctx context.Context) (err error) {
+	return n.DowngradeToContext(ctx, "-1")
+}
+
+func (n *native) DowngradeTo(target string) (err error) {
+	return n.DowngradeToContext(context.Background(), target)
+}
+
+func (n *native) DowngradeToContext(ctx context.Context, target string) (err error) {
+	n.logger.Info("downgrade...", stageField(StageDowngrade))
+	var (
+		current  string
+		revision string
+		reverted []string
+		start    = time.Now()
+	)
+	defer func() {
+		n.logger.InfoWithFlag(err, "downgrade", stageField(StageDowngrade), Field{Key: "target", Value: target}, Field{Key: "from", Value: current}, revisionField(revision), Field{Key: "reverted", Value: reverted})
+		n.observeOperation(AuditDowngrade, start, err)
+		if err != nil || revision != current {
+			n.audit(AuditDowngrade, start, current, revision, err)
+		}
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageDowngrade)
+	defer func() { err = n.stageError(ctx, StageDowngrade, err); cancel() }()
+	if err != nil {
+		return
+	}
+	var unlock func()
+	if unlock, err = n.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+
+	var db *sql.DB
+	if db, err = n.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var (
+		byId  map[string]*nativeRevision
+		graph *RevisionGraph
+	)
+	if byId, graph, current, err = n.revisionState(ctx, db); err != nil {
+		return
+	}
+	if revision, err = resolveTarget(graph, current, target, false); err != nil {
+		return
+	}
+	// native 后端的版本链是线性的，沿 Revises 逐个回退即可
+	for id := current; id != revision; id = byId[id].Revises {
+		r, revisionStart := byId[id], time.Now()
+		if err = n.beforeRevision(ctx, StageDowngrade, r.RevisionId, r.Revises); err != nil {
+			return
+		}
+		var stmts []string
+		if stmts, err = n.statements(r, false); err != nil {
+			return
+		}
+		if err = n.revertDataMigrations(ctx, db, r.RevisionId); err != nil {
+			return
+		}
+		stmts = append(stmts, downgradeVersionStatement(r.RevisionId, r.Revises))
+		if err = n.execRevision(ctx, db, stmts, false); err != nil {
+			err = fmt.Errorf("downgrade %s -> %s failed: %w", r.RevisionId, r.Revises, err)
+			return
+		}
+		reverted = append(reverted, r.RevisionId)
+		n.revisionApplied(ctx, StageDowngrade, r.RevisionId, r.Revises, revisionStart)
+	}
+	return
+}
+
+func (n *native) Plan() (plan Plan, err error) {
+	return n.PlanContext(context.Background())
+}
+
+// PlanContext 以数据库当前版本之后的 .up.sql 构建升级计划
+func (n *native) PlanContext(ctx context.Context) (plan Plan, err error) {
+	n.logger.Info("plan...", stageField(StagePlan))
+	defer func() {
+		n.logger.InfoWithFlag(err, "plan", stageField(StagePlan), Field{Key: "database", Value: plan.DatabaseRevision}, Field{Key: "head", Value: plan.Head}, Field{Key: "steps", Value: len(plan.Steps)}, Field{Key: "risk", Value: plan.Risk})
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StagePlan)
+	defer func() { err = n.stageError(ctx, StagePlan, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var db *sql.DB
+	if db, err = n.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var (
+		byId          map[string]*nativeRevision
+		graph         *RevisionGraph
+		current, head string
+	)
+	if byId, graph, current, err = n.revisionState(ctx, db); err != nil {
+		return
+	}
+	if head, err = graph.Head(); err != nil {
+		return
+	}
+	var path []string
+	if head != "" {
+		if path, err = graph.Path(current, head); err != nil {
+			return
+		}
+	}
+	var (
+		preamble []string
+		steps    []offlineStep
+	)
+	if current == "" && len(path) > 0 {
+		preamble = append(preamble, createAlembicVersionDDL)
+	}
+	for _, id := range path {
+		r := byId[id]
+		var stmts []string
+		if stmts, err = n.statements(r, true); err != nil {
+			return
+		}
+		stmts = append(stmts, upgradeVersionStatement(r.Revises, r.RevisionId))
+		steps = append(steps, offlineStep{Upgrade: true, From: r.Revises, To: r.RevisionId, Statements: stmts})
+	}
+	return n.plan(ctx, db, graph, current, head, preamble, steps)
+}
+
+func (n *native) Status() (status Status, err error) {
+	return n.StatusContext(context.Background())
+}
+
+func (n *native) StatusContext(ctx context.Context) (status Status, err error) {
+	n.logger.Info("status...", stageField(StageStatus))
+	defer func() {
+		n.logger.InfoWithFlag(err, "status", stageField(StageStatus), Field{Key: "head", Value: status.Head}, Field{Key: "database", Value: status.DatabaseRevision}, Field{Key: "state", Value: status.State})
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageStatus)
+	defer func() { err = n.stageError(ctx, StageStatus, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var db *sql.DB
+	if db, err = n.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var (
+		graph   *RevisionGraph
+		current string
+	)
+	if _, graph, current, err = n.revisionState(ctx, db); err != nil {
+		return
+	}
+	return n.status(ctx, db, graph, current)
+}
+
+func (n *native) Stamp(revision string) (err error) {
+	return n.StampContext(context.Background(), revision)
+}
+
+// StampContext 只改写 alembic_version 中的版本号，不执行版本文件中的 SQL
+func (n *native) StampContext(ctx context.Context, revision string) (err error) {
+	n.logger.Info("stamp...", stageField(StageStamp))
+	var (
+		current string
+		target  string
+		start   = time.Now()
+	)
+	defer func() {
+		n.logger.InfoWithFlag(err, "stamp", stageField(StageStamp), Field{Key: "target", Value: revision}, Field{Key: "from", Value: current}, revisionField(target))
+		n.observeOperation(AuditStamp, start, err)
+		if err != nil || target != current {
+			n.audit(AuditStamp, start, current, target, err)
+		}
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageStamp)
+	defer func() { err = n.stageError(ctx, StageStamp, err); cancel() }()
+	if err != nil {
+		return
+	}
+	var unlock func()
+	if unlock, err = n.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+
+	var db *sql.DB
+	if db, err = n.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var graph *RevisionGraph
+	if _, graph, current, err = n.revisionState(ctx, db); err != nil {
+		return
+	}
+	if target, err = resolveStampTarget(graph, revision); err != nil || target == current {
+		return
+	}
+	return stampDatabase(ctx, db, target)
+}
+
+func (n *native) Baseline() (revision Revision, err error) {
+	return n.BaselineContext(context.Background())
+}
+
+// BaselineContext 以数据库中现有的表生成初始的 up/down 版本文件，校验与数据库表结构一致后标记数据库，不执行 DDL
+func (n *native) BaselineContext(ctx context.Context) (revision Revision, err error) {
+	n.logger.Info("baseline...", stageField(StageBaseline))
+	var (
+		revisionId       string
+		upFile, downFile string
+		start            = time.Now()
+	)
+	defer func() {
+		n.logger.InfoWithFlag(err, "baseline", stageField(StageBaseline), revisionField(revisionId), Field{Key: "up", Value: upFile}, Field{Key: "down", Value: downFile})
+		n.audit(AuditBaseline, start, "", revisionId, err)
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageBaseline)
+	defer func() { err = n.stageError(ctx, StageBaseline, err); cancel() }()
+	if err != nil {
+		return
+	}
+	// PostgreSQL、SQLite 没有 SHOW CREATE TABLE，无法导出现有的表结构，在加锁、生成版本之前拒绝
+	if err = n.requireMySQL("baseline"); err != nil {
+		return
+	}
+	var unlock func()
+	if unlock, err = n.lock(ctx); err != nil {
+		return
+	}
+	defer unlock()
+
+	var db *sql.DB
+	if db, err = n.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var (
+		graph   *RevisionGraph
+		current string
+	)
+	if _, graph, current, err = n.revisionState(ctx, db); err != nil {
+		return
+	}
+	if err = checkUnversioned(graph, current); err != nil {
+		return
+	}
+	var up, down []string
+	if up, down, err = n.baselineStatements(ctx, db); err != nil {
+		return
+	}
+	if revisionId, err = n.revisionId(); err != nil {
+		return
+	}
+	// 校验或标记失败时删除生成的版本文件，保持与执行之前一致
+	defer func() {
+		if err != nil && upFile != "" {
+			_ = os.Remove(upFile)
+			_ = os.Remove(downFile)
+		}
+	}()
+	if upFile, downFile, err = n.writeRevisionFiles(revisionId, "", baselineMessage, joinStatements(up), joinStatements(down)); err != nil {
+		return
+	}
+	// 以写入文件后再读取的语句校验，与之后 Upgrade 在新库上执行的语句一致
+	var statements []string
+	if statements, err = n.readStatements(upFile); err != nil {
+		return
+	}
+	if err = n.checkBaseline(ctx, db, revisionId, statements); err != nil {
+		return
+	}
+	if err = stampDatabase(ctx, db, revisionId); err != nil {
+		return
+	}
+	var r *nativeRevision
+	if r, err = parseNativeRevisionFile(upFile); err != nil {
+		return
+	}
+	return r.revision(true), nil
+}
+
+func (n *native) Drift() (report DriftReport, err error) {
+	return n.DriftContext(context.Background())
+}
+
+// DriftContext 依次应用 base 到 head 的 .up.sql 推导期望的表结构，与数据库对比
+func (n *native) DriftContext(ctx context.Context) (report DriftReport, err error) {
+	n.logger.Info("drift...", stageField(StageDrift))
+	defer func() {
+		n.logger.InfoWithFlag(err, "drift", stageField(StageDrift), Field{Key: "head", Value: report.Head}, Field{Key: "database", Value: report.DatabaseRevision}, Field{Key: "items", Value: len(report.Items)})
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageDrift)
+	defer func() { err = n.stageError(ctx, StageDrift, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var db *sql.DB
+	if db, err = n.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var (
+		byId  map[string]*nativeRevision
+		graph *RevisionGraph
+	)
+	if byId, graph, report.DatabaseRevision, err = n.revisionState(ctx, db); err != nil {
+		return
+	}
+	if report.Head, err = graph.Head(); err != nil {
+		return
+	}
+	var statements []string
+	for _, id := range graph.Revisions() {
+		var stmts []string
+		if stmts, err = n.statements(byId[id], true); err != nil {
+			return
+		}
+		statements = append(statements, stmts...)
+	}
+	report.Items, err = n.drift(ctx, db, statements)
+	return
+}
+
+// revisionState 获取本地版本、版本图及数据库当前版本号
+func (n *native) revisionState(ctx context.Context, db *sql.DB) (byId map[string]*nativeRevision, graph *RevisionGraph, current string, err error) {
+	var revisions []*nativeRevision
+	if revisions, err = n.loadRevisions(); err != nil {
+		return
+	}
+	byId = make(map[string]*nativeRevision, len(revisions))
+	list := make([]Revision, 0, len(revisions))
+	for _, r := range revisions {
+		byId[r.RevisionId] = r
+		list = append(list, r.revision(false))
+	}
+	if graph, err = NewRevisionGraph(list); err != nil {
+		return
+	}
+	current, err = n.databaseRevisionId(ctx, db)
+	return
+}
+
+func (n *native) History() (revisions []Revision, err error) {
+	return n.HistoryContext(context.Background())
+}
+
+func (n *native) HistoryContext(ctx context.Context) (revisions []Revision, err error) {
+	n.logger.Info("history...", stageField(StageHistory))
+	defer func() {
+		n.logger.InfoWithFlag(err, "history", stageField(StageHistory), Field{Key: "revisions", Value: len(revisions)})
+	}()
+	ctx, cancel, err := n.stageContext(ctx, StageHistory)
+	defer func() { err = n.stageError(ctx, StageHistory, err); cancel() }()
+	if err != nil {
+		return
+	}
+
+	var local []*nativeRevision
+	if local, err = n.loadRevisions(); err != nil {
+		return
+	}
+	// 与 flask db history 一致，按 head -> base 的顺序输出
+	for i := len(local) - 1; i >= 0; i-- {
+		revisions = append(revisions, local[i].revision(i == len(local)-1))
+	}
+	return
+}
+
+// loadRevisions 加载本地版本文件，并按 base -> head 的顺序返回
+func (n *native) loadRevisions() (revisions []*nativeRevision, err error) {
+	dir := n.versionsDir()
+	var entries []os.DirEntry
+	if entries, err = os.ReadDir(dir); err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return
+	}
+	for _, e := range entries {
+		var r *nativeRevision
+		switch {
+		case e.IsDir():
+			continue
+		case strings.HasSuffix(e.Name(), nativeUpSuffix):
+			r, err = parseNativeRevisionFile(filepath.Join(dir, e.Name()))
+		case strings.HasSuffix(e.Name(), alembicScriptSuffix) && e.Name() != alembicPackageInit:
+			r, err = parseAlembicRevisionFile(filepath.Join(dir, e.Name()))
+		default:
+			continue
+		}
+		if err != nil {
+			return
+		}
+		revisions = append(revisions, r)
+	}
+	return orderNativeRevisions(revisions)
+}
+
+// revision 转换为与 flask db history --verbose 输出一致的 Revision，Doc 与 Alembic 生成的文档字符串格式一致
+func (r *nativeRevision) revision(head bool) Revision {
+	revision := Revision{
+		RevisionId: r.RevisionId,
+		IsHead:     head,
+		Message:    r.Message,
+		Doc: fmt.Sprintf("%s\n\nRevision ID: %s\nRevises: %s\nCreate Date: %s",
+			r.Message, r.RevisionId, r.Revises, formatRevisionCreateDate(r.CreateDate)),
+		Path:       r.UpFile,
+		CreateDate: r.CreateDate,
+	}
+	if r.Script != "" {
+		revision.Path = r.Script
+	}
+	if r.Revises != "" {
+		revision.Parents = []string{r.Revises}
+	}
+	return revision
+}
+
+func parseNativeRevisionFile(file string) (r *nativeRevision, err error) {
+	var content []byte
+	if content, err = xos.FileGetContents(file); err != nil {
+		return
+	}
+	r = &nativeRevision{UpFile: file, DownFile: strings.TrimSuffix(file, nativeUpSuffix) + nativeDownSuffix}
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			if line == "" {
+				continue
+			}
+			break
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "--"))
+		kv := strings.SplitN(line, ":", 2)
+		value := ""
+		if len(kv) == 2 {
+			value = strings.TrimSpace(kv[1])
+		}
+		switch kv[0] {
+		case "Revision ID":
+			r.RevisionId = value
+		case "Revises":
+			r.Revises = value
+		case "Create Date":
+			if r.CreateDate, err = time.Parse(revisionCreateDateLayout, value); err != nil {
+				err = fmt.Errorf("invalid 'Create Date' in revision file '%s': %w", file, err)
+				return
+			}
+		default:
+			if r.Message == "" && r.RevisionId == "" {
+				r.Message = line
+			}
+		}
+	}
+	if r.RevisionId == "" {
+		err = fmt.Errorf("invalid revision file '%s', not found 'Revision ID'", file)
+	}
+	return
+}
+
+// alembic 版本文件中的版本号及父版本，兼容带类型注解的写法，如 down_revision: Union[str, None] = 'abc'
+var (
+	alembicRevisionRegexp     = regexp.MustCompile(`(?m)^revision\s*(?::[^=\n]*)?=\s*['"]([^'"]+)['"]`)
+	alembicDownRevisionRegexp = regexp.MustCompile(`(?m)^down_revision\s*(?::[^=\n]*)?=\s*(.+)$`)
+	alembicDocRegexp          = regexp.MustCompile(`(?s)^\s*[rRuU]?("""|''')(.*?)("""|''')`)
+)
+
+// parseAlembicRevisionFile 解析 Alembic 生成的 .py 版本文件，作为 native 后端中只可升级经过、不可执行的历史版本
+func parseAlembicRevisionFile(file string) (r *nativeRevision, err error) {
+	var content []byte
+	if content, err = xos.FileGetContents(file); err != nil {
+		return
+	}
+	r = &nativeRevision{Script: file}
+	m := alembicRevisionRegexp.FindSubmatch(content)
+	if m == nil {
+		return nil, fmt.Errorf("invalid alembic revision file '%s', not found 'revision'", file)
+	}
+	r.RevisionId = string(m[1])
+	if m = alembicDownRevisionRegexp.FindSubmatch(content); m != nil {
+		down := splitRevisionList(strings.NewReplacer("'", "", `"`, "", "None", "").Replace(strings.TrimSpace(string(m[1]))))
+		if len(down) > 1 {
+			return nil, fmt.Errorf("merge revision '%s' in '%s' is not supported by native backend", r.RevisionId, file)
+		}
+		if len(down) == 1 {
+			r.Revises = down[0]
+		}
+	}
+	if m = alembicDocRegexp.FindSubmatch(content); m != nil {
+		for _, line := range strings.Split(string(m[2]), "\n") {
+			line = strings.TrimSpace(line)
+			switch {
+			case strings.HasPrefix(line, "Create Date:"):
+				if r.CreateDate, err = parseRevisionCreateDate(strings.TrimSpace(strings.TrimPrefix(line, "Create Date:"))); err != nil {
+					err = fmt.Errorf("invalid 'Create Date' in revision file '%s': %w", file, err)
+					return
+				}
+			case r.Message == "" && line != "":
+				r.Message = line
+			}
+		}
+	}
+	return
+}
+
+// orderNativeRevisions 按版本图的拓扑序排列版本，native 后端仅支持单一 head 且没有合并的线性版本链
+func orderNativeRevisions(revisions []*nativeRevision) (ordered []*nativeRevision, err error) {
+	byId := make(map[string]*nativeRevision, len(revisions))
+	list := make([]Revision, 0, len(revisions))
+	for _, r := range revisions {
+		byId[r.RevisionId] = r
+		list = append(list, r.revision(false))
+	}
+	var graph *RevisionGraph
+	if graph, err = NewRevisionGraph(list); err != nil {
+		return
+	}
+	if _, err = graph.Head(); err != nil {
+		return
+	}
+	if merges := graph.MergePoints(); len(merges) > 0 {
+		return nil, fmt.Errorf("merge revisions %v are not supported by native backend", merges)
+	}
+	for _, id := range graph.Revisions() {
+		ordered = append(ordered, byId[id])
+	}
+	return
+}
+
+// findNativeRevision 按完整版本号或唯一前缀查找版本
+func findNativeRevision(revisions []*nativeRevision, version string) (found *nativeRevision, err error) {
+	for _, r := range revisions {
+		if r.RevisionId == version {
+			return r, nil
+		}
+		if strings.HasPrefix(r.RevisionId, version) {
+			if found != nil {
+				return nil, fmt.Errorf("multiple revisions start with '%s'", version)
+			}
+			found = r
+		}
+	}
+	if found == nil {
+		err = &RevisionNotFoundError{Revision: version}
+	}
+	return
+}
+
+// pendingNativeRevisions 返回 current 之后待升级的版本
+func pendingNativeRevisions(revisions []*nativeRevision, current string) ([]*nativeRevision, error) {
+	if current == "" {
+		return revisions, nil
+	}
+	for i, r := range revisions {
+		if r.RevisionId == current {
+			return revisions[i+1:], nil
+		}
+	}
+	return nil, &RevisionNotFoundError{Revision: current, Reason: "database revision is not present in local revisions"}
+}
+
+func newRevisionId() (string, error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// currentRevisionId 连接数据库获取当前版本号
+func (n *native) currentRevisionId(ctx context.Context) (current string, err error) {
+	var db *sql.DB
+	if db, err = n.openDatabase(); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	return n.databaseRevisionId(ctx, db)
+}
+
+// statements 版本的 up 或 down 语句，Alembic 的 .py 版本无法执行
+func (n *native) statements(r *nativeRevision, upgrade bool) ([]string, error) {
+	if r.Script != "" {
+		return nil, fmt.Errorf("revision '%s' is an Alembic revision '%s', the native backend can't execute it, "+
+			"upgrade with the alembic backend or stamp the database first", r.RevisionId, r.Script)
+	}
+	if upgrade {
+		return n.readStatements(r.UpFile)
+	}
+	return n.readStatements(r.DownFile)
+}
+
+// readStatements 读取 .sql 文件并按当前方言拆分语句
+func (g *core) readStatements(file string) ([]string, error) {
+	content, err := xos.FileGetContents(file)
+	if err != nil {
+		return nil, err
+	}
+	return splitStatements(g.dialectName(), string(content)), nil
+}
+
+// execRevision 执行一个版本的语句及 alembic_version 的更新，DDL 支持事务的方言在同一个事务中执行，
+// 否则升级时以 execOnline 执行，MySQL 的 DDL 会隐式提交事务，失败时已执行的语句不会回滚
+func (n *native) execRevision(ctx context.Context, db *sql.DB, stmts []string, upgrade bool) error {
+	dialect, err := n.dialect()
+	if err != nil {
+		return err
+	}
+	switch {
+	case dialect.TransactionalDDL():
+		return execTransaction(ctx, db, stmts)
+	case upgrade:
+		return n.execOnline(ctx, db, stmts)
+	}
+	return execStatements(ctx, db, stmts)
+}
+
+// execTransaction 在同一个事务中依次执行语句，任一语句失败时回滚
+func execTransaction(ctx context.Context, db *sql.DB, stmts []string) (err error) {
+	var tx *sql.Tx
+	if tx, err = db.BeginTx(ctx, nil); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	for _, stmt := range stmts {
+		if _, err = tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("exec '%s': %w", stmt, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// execStatements 在同一个连接上依次执行语句
+func execStatements(ctx context.Context, db *sql.DB, stmts []string) (err error) {
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		return
+	}
+	defer func() { _ = conn.Close() }()
+	for _, stmt := range stmts {
+		if _, err = conn.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("exec '%s': %w", stmt, err)
+		}
+	}
+	return
+}
+
+// databaseRevisionId 查询 alembic_version 表中记录的版本号，表不存在时返回空
+func (g *core) databaseRevisionId(ctx context.Context, db *sql.DB) (revisionId string, err error) {
+	var dialect Dialect
+	if dialect, err = g.dialect(); err != nil {
+		return
+	}
+	var count int
+	if err = db.QueryRowContext(ctx, dialect.TableExistsQuery(), alembicVersionTable).Scan(&count); err != nil || count == 0 {
+		err = connectionError(err)
+		return
+	}
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, "SELECT version_num FROM "+alembicVersionTable); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return
+		}
+		ids = append(ids, id)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	if len(ids) > 1 {
+		return "", fmt.Errorf("multiple revisions %v found in %s", ids, alembicVersionTable)
+	}
+	if len(ids) == 1 {
+		revisionId = ids[0]
+	}
+	return
+}
+
+func upgradeVersionStatement(from, to string) string {
+	if from == "" {
+		return fmt.Sprintf(insertAlembicVersionDDL, to)
+	}
+	return fmt.Sprintf(updateAlembicVersionDDL, to, from)
+}
+
+func downgradeVersionStatement(from, to string) string {
+	if to == "" {
+		return fmt.Sprintf(deleteAlembicVersionDDL, from)
+	}
+	return fmt.Sprintf(updateAlembicVersionDDL, to, from)
+}
+
+// stampDatabase 将 alembic_version 中的版本号改写为 revision，revision 为空时清空
+func stampDatabase(ctx context.Context, db *sql.DB, revision string) error {
+	stmts := []string{
+		strings.Replace(createAlembicVersionDDL, "CREATE TABLE", "CREATE TABLE IF NOT EXISTS", 1),
+		"DELETE FROM alembic_version",
+	}
+	if revision != "" {
+		stmts = append(stmts, fmt.Sprintf(insertAlembicVersionDDL, revision))
+	}
+	return execTransaction(ctx, db, stmts)
+}
+
+// joinStatements 以 ';' 分隔语句，可由 splitStatements 拆分
+func joinStatements(stmts []string) string {
+	var b strings.Builder
+	for _, stmt := range stmts {
+		b.WriteString(stmt + ";\n\n")
+	}
+	return b.String()
+}
+
+// renderOfflineSQL 按 Alembic 离线模式的格式输出 SQL
+func renderOfflineSQL(createVersionTable bool, steps []offlineStep) string {
+	var b strings.Builder
+	if createVersionTable {
+		b.WriteString(createAlembicVersionDDL + ";\n\n")
+	}
+	for _, step := range steps {
+		if step.Upgrade {
+			b.WriteString(fmt.Sprintf(runningUpgradeComment, step.From, step.To) + "\n\n")
+		} else {
+			b.WriteString(fmt.Sprintf(runningDowngradeComment, step.From, step.To) + "\n\n")
+		}
+		for _, stmt := range step.Statements {
+			b.WriteString(stmt + ";\n\n")
+		}
+		if step.Upgrade {
+			b.WriteString(upgradeVersionStatement(step.From, step.To) + ";\n\n")
+		} else {
+			b.WriteString(downgradeVersionStatement(step.From, step.To) + ";\n\n")
+		}
+	}
+	return b.String()
+}
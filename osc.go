@@ -0,0 +1,594 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// oscThrottleInterval 复制延迟超过 OSCMaxReplicationLag 时的等待间隔
+	oscThrottleInterval = time.Second
+	// mysqlMaxIdentifierLength MySQL 表名、触发器名的最大长度
+	mysqlMaxIdentifierLength = 64
+)
+
+// OSCFilter 按语句选择是否以在线表结构变更执行 ALTER TABLE
+type OSCFilter func(table, statement string) bool
+
+// OSCProgress 在线表结构变更的复制进度
+type OSCProgress struct {
+	Table     string
+	Statement string
+	// Copied 已复制的行数，与触发器同步的行重复时不计入
+	Copied int64
+	// Total 开始复制时 information_schema 中估算的行数
+	Total   int64
+	Elapsed time.Duration
+	// Throttled 因复制延迟暂停的累计时间
+	Throttled time.Duration
+	// Done 复制是否已完成
+	Done bool
+}
+
+// Percent 估算的完成百分比
+func (p OSCProgress) Percent() float64 {
+	if p.Done {
+		return 100
+	}
+	if p.Total <= 0 {
+		return 0
+	}
+	if percent := float64(p.Copied) * 100 / float64(p.Total); percent < 100 {
+		return percent
+	}
+	return 99
+}
+
+func (p OSCProgress) String() string {
+	return fmt.Sprintf("%s %.1f%% (%d/~%d rows) elapsed %s, throttled %s",
+		p.Table, p.Percent(), p.Copied, p.Total, p.Elapsed.Round(time.Millisecond), p.Throttled.Round(time.Millisecond))
+}
+
+// OSCProgressFunc 在线表结构变更的进度回调，每复制一批调用一次
+type OSCProgressFunc func(OSCProgress)
+
+var alterTableRegexp = regexp.MustCompile("(?is)^\\s*ALTER\\s+TABLE\\s+((?:`[^`]+`|\\w+)(?:\\s*\\.\\s*(?:`[^`]+`|\\w+))?)\\s+(.+)$")
+
+// onlineAlter 可以在线执行的 ALTER TABLE
+type onlineAlter struct {
+	table     string
+	spec      string
+	statement string
+	// renames 重命名的列，key 为小写的旧列名
+	renames map[string]string
+}
+
+// parseOnlineAlter 解析 ALTER TABLE 语句，重命名表、分区及表空间操作不能通过影子表执行
+func parseOnlineAlter(stmt string) (*onlineAlter, bool) {
+	m := alterTableRegexp.FindStringSubmatch(stmt)
+	if m == nil {
+		return nil, false
+	}
+	// 表名保留大小写，忽略 schema 前缀
+	names := newDDLTokens(m[1]).tokens
+	a := &onlineAlter{
+		table:     names[len(names)-1],
+		spec:      strings.TrimSpace(m[2]),
+		statement: stmt,
+		renames:   make(map[string]string),
+	}
+	if strings.EqualFold(a.table, alembicVersionTable) {
+		return nil, false
+	}
+	for _, clause := range newDDLTokens(a.spec).list() {
+		for _, t := range clause.tokens {
+			switch strings.ToUpper(t) {
+			case "PARTITION", "PARTITIONING", "TABLESPACE":
+				return nil, false
+			}
+		}
+		switch {
+		case clause.accept("RENAME", "COLUMN"):
+			old := clause.ident()
+			clause.accept("TO")
+			a.renames[old] = clause.ident()
+		case clause.accept("RENAME"):
+			if clause.acceptAny("INDEX", "KEY") == "" {
+				return nil, false
+			}
+		case clause.accept("CHANGE"):
+			clause.accept("COLUMN")
+			old := clause.ident()
+			if column := clause.ident(); column != old {
+				a.renames[old] = column
+			}
+		}
+	}
+	return a, true
+}
+
+// oscEnabled 是否配置了在线表结构变更
+func (g *core) oscEnabled() bool {
+	return g.conf.GetOSCFilter() != nil || g.conf.GetOSCTableRows() > 0
+}
+
+// onlineAlterOf 语句需要以在线表结构变更执行时返回解析结果
+func (g *core) onlineAlterOf(ctx context.Context, db *sql.DB, stmt string) (a *onlineAlter, err error) {
+	if !g.oscEnabled() {
+		return
+	}
+	if err = g.requireMySQL("online schema change"); err != nil {
+		return
+	}
+	var ok bool
+	if a, ok = parseOnlineAlter(stmt); !ok {
+		return nil, nil
+	}
+	if filter := g.conf.GetOSCFilter(); filter != nil {
+		if filter(a.table, stmt) {
+			return a, nil
+		}
+		return nil, nil
+	}
+	var rows int64
+	err = db.QueryRowContext(ctx, "SELECT COALESCE(TABLE_ROWS, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", a.table).Scan(&rows)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil || rows < g.conf.GetOSCTableRows() {
+		return nil, err
+	}
+	return a, nil
+}
+
+// execOnline 依次执行语句，符合 OSCFilter/OSCTableRows 的 ALTER TABLE 以在线表结构变更执行，其他语句在同一个连接上执行
+func (g *core) execOnline(ctx context.Context, db *sql.DB, stmts []string) (err error) {
+	if !g.oscEnabled() {
+		return execStatements(ctx, db, stmts)
+	}
+	var plain []string
+	for _, stmt := range stmts {
+		var a *onlineAlter
+		if a, err = g.onlineAlterOf(ctx, db, stmt); err != nil {
+			return
+		}
+		if a == nil {
+			plain = append(plain, stmt)
+			continue
+		}
+		if err = execStatements(ctx, db, plain); err != nil {
+			return
+		}
+		plain = nil
+		if err = g.onlineSchemaChange(ctx, db, a); err != nil {
+			return
+		}
+	}
+	return execStatements(ctx, db, plain)
+}
+
+// oscRun 一次在线表结构变更：创建影子表并执行 ALTER，以触发器同步增量，按主键分批复制存量，最后以 RENAME 原子切换
+type oscRun struct {
+	*core
+	db       *sql.DB
+	alter    *onlineAlter
+	replicas []*sql.DB
+	shadow   string
+	old      string
+	triggers [3]string
+	// source/target 原表与影子表中对应的列
+	source []string
+	target []string
+	// primary 原表主键列，primaryTarget 为影子表中对应的列
+	primary       []string
+	primaryTarget []string
+	progress      OSCProgress
+	start         time.Time
+	lastLog       float64
+	// created 已创建影子表，triggersCreated 已创建的触发器数量，失败时只清理由本次变更创建的对象
+	created         bool
+	triggersCreated int
+}
+
+// oscName 以 _<table>_<suffix> 命名辅助表及触发器，超过 MySQL 标识符长度时截断表名
+func oscName(table, suffix string) string {
+	if max := mysqlMaxIdentifierLength - len(suffix) - 2; len(table) > max {
+		table = table[:max]
+	}
+	return "_" + table + "_" + suffix
+}
+
+func quoteIdent(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+func quoteIdents(prefix string, names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = prefix + quoteIdent(name)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func (g *core) onlineSchemaChange(ctx context.Context, db *sql.DB, a *onlineAlter) (err error) {
+	table := a.table
+	o := &oscRun{
+		core:     g,
+		db:       db,
+		alter:    a,
+		shadow:   oscName(table, "new"),
+		old:      oscName(table, "old"),
+		triggers: [3]string{oscName(table, "ins"), oscName(table, "upd"), oscName(table, "del")},
+		progress: OSCProgress{Table: table, Statement: a.statement},
+		start:    time.Now(),
+	}
+	g.logger.Info("online schema change...", stageField(StageUpgrade), Field{Key: "table", Value: table})
+	defer func() {
+		g.logger.InfoWithFlag(err, "online schema change", stageField(StageUpgrade), Field{Key: "table", Value: table}, Field{Key: "copied", Value: o.progress.Copied},
+			Field{Key: "elapsed", Value: time.Since(o.start).Round(time.Millisecond)}, Field{Key: "statement", Value: a.statement})
+	}()
+	if err = o.openReplicas(); err != nil {
+		return
+	}
+	defer o.closeReplicas()
+	if err = o.prepare(ctx); err != nil {
+		o.cleanup()
+		return
+	}
+	if err = o.copy(ctx); err != nil {
+		o.cleanup()
+		return
+	}
+	return o.swap(ctx)
+}
+
+func (o *oscRun) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	result, err := o.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("exec '%s': %w", query, err)
+	}
+	return result, nil
+}
+
+func (o *oscRun) openReplicas() (err error) {
+	for _, replica := range o.conf.GetOSCReplicas() {
+		var dsn string
+		if dsn, err = replica.FormatDSN(); err != nil {
+			return
+		}
+		var db *sql.DB
+		if db, err = sql.Open("mysql", dsn); err != nil {
+			return
+		}
+		o.replicas = append(o.replicas, db)
+	}
+	return
+}
+
+func (o *oscRun) closeReplicas() {
+	for _, db := range o.replicas {
+		_ = db.Close()
+	}
+}
+
+// prepare 创建影子表、执行 ALTER、确定复制的列及主键，并创建同步触发器
+func (o *oscRun) prepare(ctx context.Context) (err error) {
+	table := o.alter.table
+	var referenced int
+	if err = o.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM information_schema.KEY_COLUMN_USAGE
+WHERE REFERENCED_TABLE_SCHEMA = DATABASE() AND REFERENCED_TABLE_NAME = ?`, table).Scan(&referenced); err != nil {
+		return connectionError(err)
+	}
+	if referenced > 0 {
+		return fmt.Errorf("online schema change does not support table '%s' referenced by foreign keys", table)
+	}
+	for _, name := range []string{o.shadow, o.old} {
+		var exists int
+		if err = o.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?", name).Scan(&exists); err != nil {
+			return
+		}
+		if exists > 0 {
+			return fmt.Errorf("table '%s' already exists, it may be left by an interrupted online schema change, drop it first", name)
+		}
+	}
+	if _, err = o.exec(ctx, fmt.Sprintf("CREATE TABLE %s LIKE %s", quoteIdent(o.shadow), quoteIdent(table))); err != nil {
+		return
+	}
+	o.created = true
+	if _, err = o.exec(ctx, fmt.Sprintf("ALTER TABLE %s %s", quoteIdent(o.shadow), o.alter.spec)); err != nil {
+		return
+	}
+	if err = o.columns(ctx); err != nil {
+		return
+	}
+	return o.createTriggers(ctx)
+}
+
+// columns 原表与影子表共有的列(按重命名映射)，以及原表的主键
+func (o *oscRun) columns(ctx context.Context) (err error) {
+	var sourceColumns, targetColumns []string
+	if sourceColumns, err = tableColumns(ctx, o.db, o.alter.table); err != nil {
+		return
+	}
+	if targetColumns, err = tableColumns(ctx, o.db, o.shadow); err != nil {
+		return
+	}
+	targetByName := make(map[string]string, len(targetColumns))
+	for _, column := range targetColumns {
+		targetByName[strings.ToLower(column)] = column
+	}
+	mapping := make(map[string]string)
+	for _, column := range sourceColumns {
+		name := strings.ToLower(column)
+		if renamed, ok := o.alter.renames[name]; ok {
+			name = renamed
+		}
+		if target, ok := targetByName[name]; ok {
+			o.source = append(o.source, column)
+			o.target = append(o.target, target)
+			mapping[column] = target
+		}
+	}
+	if len(o.source) == 0 {
+		return fmt.Errorf("table '%s' has no column in common with the altered table", o.alter.table)
+	}
+	var rows *sql.Rows
+	if rows, err = o.db.QueryContext(ctx, `SELECT COLUMN_NAME FROM information_schema.STATISTICS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND INDEX_NAME = 'PRIMARY' ORDER BY SEQ_IN_INDEX`, o.alter.table); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return
+		}
+		target, ok := mapping[column]
+		if !ok {
+			return fmt.Errorf("online schema change can not drop primary key column '%s' of table '%s'", column, o.alter.table)
+		}
+		o.primary = append(o.primary, column)
+		o.primaryTarget = append(o.primaryTarget, target)
+	}
+	if err = rows.Err(); err != nil {
+		return
+	}
+	if len(o.primary) == 0 {
+		return fmt.Errorf("online schema change requires a primary key on table '%s'", o.alter.table)
+	}
+	return
+}
+
+// tableColumns 表中的非生成列，按定义顺序返回
+func tableColumns(ctx context.Context, db *sql.DB, table string) (columns []string, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, `SELECT COLUMN_NAME FROM information_schema.COLUMNS
+WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND EXTRA NOT LIKE '%GENERATED%' ORDER BY ORDINAL_POSITION`, table); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var column string
+		if err = rows.Scan(&column); err != nil {
+			return
+		}
+		columns = append(columns, column)
+	}
+	err = rows.Err()
+	return
+}
+
+// primaryMatch 影子表中主键等于原表行(OLD/NEW)主键的条件
+func (o *oscRun) primaryMatch(row string) string {
+	conditions := make([]string, len(o.primary))
+	for i := range o.primary {
+		conditions[i] = fmt.Sprintf("%s <=> %s.%s", quoteIdent(o.primaryTarget[i]), row, quoteIdent(o.primary[i]))
+	}
+	return strings.Join(conditions, " AND ")
+}
+
+func (o *oscRun) createTriggers(ctx context.Context) (err error) {
+	table, shadow := quoteIdent(o.alter.table), quoteIdent(o.shadow)
+	replace := fmt.Sprintf("REPLACE INTO %s (%s) VALUES (%s)", shadow, quoteIdents("", o.target), quoteIdents("NEW.", o.source))
+	changed := make([]string, len(o.primary))
+	for i, column := range o.primary {
+		changed[i] = fmt.Sprintf("NOT (OLD.%s <=> NEW.%s)", quoteIdent(column), quoteIdent(column))
+	}
+	triggers := []string{
+		fmt.Sprintf("CREATE TRIGGER %s AFTER INSERT ON %s FOR EACH ROW %s", quoteIdent(o.triggers[0]), table, replace),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER UPDATE ON %s FOR EACH ROW BEGIN DELETE IGNORE FROM %s WHERE (%s) AND %s; %s; END",
+			quoteIdent(o.triggers[1]), table, shadow, strings.Join(changed, " OR "), o.primaryMatch("OLD"), replace),
+		fmt.Sprintf("CREATE TRIGGER %s AFTER DELETE ON %s FOR EACH ROW DELETE IGNORE FROM %s WHERE %s",
+			quoteIdent(o.triggers[2]), table, shadow, o.primaryMatch("OLD")),
+	}
+	for _, trigger := range triggers {
+		if _, err = o.exec(ctx, trigger); err != nil {
+			return
+		}
+		o.triggersCreated++
+	}
+	return
+}
+
+// copy 按主键分批复制存量数据，每批之前检查从库复制延迟
+func (o *oscRun) copy(ctx context.Context) (err error) {
+	if err = o.db.QueryRowContext(ctx, "SELECT COALESCE(TABLE_ROWS, 0) FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ?",
+		o.alter.table).Scan(&o.progress.Total); err != nil {
+		return
+	}
+	chunkSize := o.conf.GetOSCChunkSize()
+	if chunkSize <= 0 {
+		chunkSize = 1000
+	}
+	table, primary := quoteIdent(o.alter.table), quoteIdents("", o.primary)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(o.primary)), ", ")
+	insert := fmt.Sprintf("INSERT LOW_PRIORITY IGNORE INTO %s (%s) SELECT %s FROM %s FORCE INDEX (PRIMARY) WHERE ",
+		quoteIdent(o.shadow), quoteIdents("", o.target), quoteIdents("", o.source), table)
+	var lower []interface{}
+	for {
+		if err = o.throttle(ctx); err != nil {
+			return
+		}
+		where, args := "1 = 1", []interface{}(nil)
+		if lower != nil {
+			where, args = fmt.Sprintf("(%s) > (%s)", primary, placeholders), lower
+		}
+		// 本批的上界为从 lower 开始第 chunkSize 行的主键，不存在时复制剩余的所有行
+		upper := make([]interface{}, len(o.primary))
+		pointers := make([]interface{}, len(upper))
+		for i := range upper {
+			pointers[i] = &upper[i]
+		}
+		err = o.db.QueryRowContext(ctx, fmt.Sprintf("SELECT %s FROM %s FORCE INDEX (PRIMARY) WHERE %s ORDER BY %s LIMIT 1 OFFSET %d",
+			primary, table, where, primary, chunkSize-1), args...).Scan(pointers...)
+		last := err == sql.ErrNoRows
+		if err != nil && !last {
+			return
+		}
+		query := insert + where
+		if !last {
+			query += fmt.Sprintf(" AND (%s) <= (%s)", primary, placeholders)
+			args = append(append([]interface{}(nil), args...), upper...)
+		}
+		var result sql.Result
+		if result, err = o.exec(ctx, query+" LOCK IN SHARE MODE", args...); err != nil {
+			return
+		}
+		if copied, e := result.RowsAffected(); e == nil {
+			o.progress.Copied += copied
+		}
+		o.progress.Done = last
+		o.report()
+		if last {
+			return nil
+		}
+		lower = upper
+	}
+}
+
+// report 调用 OSCProgress 回调，未设置时每完成 10% 输出一次日志
+func (o *oscRun) report() {
+	o.progress.Elapsed = time.Since(o.start)
+	if progress := o.conf.GetOSCProgress(); progress != nil {
+		progress(o.progress)
+		return
+	}
+	if percent := o.progress.Percent(); o.progress.Done || percent-o.lastLog >= 10 {
+		o.lastLog = percent
+		o.logger.Info("online schema change progress", stageField(StageUpgrade), Field{Key: "progress", Value: o.progress.String()})
+	}
+}
+
+// throttle 从库复制延迟超过 OSCMaxReplicationLag 时等待，复制未运行视为延迟未知，同样等待
+func (o *oscRun) throttle(ctx context.Context) error {
+	max := o.conf.GetOSCMaxReplicationLag()
+	if max <= 0 || len(o.replicas) == 0 {
+		return nil
+	}
+	for {
+		lag, known, err := o.replicationLag(ctx)
+		if err != nil {
+			return err
+		}
+		if known && lag <= max {
+			return nil
+		}
+		o.logger.WarnContext(ctx, "online schema change throttled", stageField(StageUpgrade), Field{Key: "table", Value: o.alter.table},
+			Field{Key: "replication_lag", Value: lag}, Field{Key: "known", Value: known}, Field{Key: "max", Value: max})
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(oscThrottleInterval):
+			o.progress.Throttled += oscThrottleInterval
+		}
+	}
+}
+
+// replicationLag 所有从库中最大的复制延迟，任一从库的复制未运行时 known 为 false
+func (o *oscRun) replicationLag(ctx context.Context) (lag time.Duration, known bool, err error) {
+	known = true
+	for _, db := range o.replicas {
+		var seconds sql.NullInt64
+		if seconds, err = secondsBehindSource(ctx, db); err != nil {
+			return
+		}
+		if !seconds.Valid {
+			known = false
+			continue
+		}
+		if d := time.Duration(seconds.Int64) * time.Second; d > lag {
+			lag = d
+		}
+	}
+	return
+}
+
+// secondsBehindSource 从 SHOW SLAVE STATUS 中读取 Seconds_Behind_Master(MySQL 8.0.22+ 为 Seconds_Behind_Source)
+func secondsBehindSource(ctx context.Context, db *sql.DB) (seconds sql.NullInt64, err error) {
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, "SHOW SLAVE STATUS"); err != nil {
+		return seconds, connectionError(err)
+	}
+	defer func() { _ = rows.Close() }()
+	var columns []string
+	if columns, err = rows.Columns(); err != nil {
+		return
+	}
+	if !rows.Next() {
+		return seconds, fmt.Errorf("replica status is empty, the server is not a replica")
+	}
+	values := make([]sql.NullString, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	if err = rows.Scan(pointers...); err != nil {
+		return
+	}
+	for i, column := range columns {
+		if column == "Seconds_Behind_Master" || column == "Seconds_Behind_Source" {
+			if values[i].Valid {
+				err = seconds.Scan(values[i].String)
+			}
+			return
+		}
+	}
+	return seconds, fmt.Errorf("replica status has no Seconds_Behind_Master column")
+}
+
+// swap 以 RENAME 原子地将影子表切换为原表，然后删除触发器及原表
+func (o *oscRun) swap(ctx context.Context) (err error) {
+	table := o.alter.table
+	if _, err = o.exec(ctx, fmt.Sprintf("RENAME TABLE %s TO %s, %s TO %s",
+		quoteIdent(table), quoteIdent(o.old), quoteIdent(o.shadow), quoteIdent(table))); err != nil {
+		o.cleanup()
+		return
+	}
+	for _, trigger := range o.triggers {
+		if _, err = o.exec(ctx, "DROP TRIGGER IF EXISTS "+quoteIdent(trigger)); err != nil {
+			return
+		}
+	}
+	_, err = o.exec(ctx, "DROP TABLE IF EXISTS "+quoteIdent(o.old))
+	return
+}
+
+// cleanup 失败时删除触发器及影子表，原表不受影响
+func (o *oscRun) cleanup() {
+	ctx := context.Background()
+	for _, trigger := range o.triggers[:o.triggersCreated] {
+		if _, err := o.exec(ctx, "DROP TRIGGER IF EXISTS "+quoteIdent(trigger)); err != nil {
+			o.logger.WarnWithFlag("online schema change cleanup failed", stageField(StageUpgrade), Field{Key: "table", Value: o.alter.table}, Field{Key: FieldError, Value: err})
+		}
+	}
+	if !o.created {
+		return
+	}
+	if _, err := o.exec(ctx, "DROP TABLE IF EXISTS "+quoteIdent(o.shadow)); err != nil {
+		o.logger.WarnWithFlag("online schema change cleanup failed", stageField(StageUpgrade), Field{Key: "table", Value: o.alter.table}, Field{Key: FieldError, Value: err})
+	}
+}
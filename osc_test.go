@@ -0,0 +1,246 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseOnlineAlter(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		stmt        string
+		wantOK      bool
+		wantTable   string
+		wantSpec    string
+		wantRenames map[string]string
+	}{
+		{name: "add column", stmt: "ALTER TABLE users ADD COLUMN age INT NOT NULL DEFAULT 0", wantOK: true, wantTable: "users", wantSpec: "ADD COLUMN age INT NOT NULL DEFAULT 0"},
+		{name: "schema prefix", stmt: "ALTER TABLE `app`.`Users` ADD INDEX ix_name (name), DROP COLUMN age", wantOK: true, wantTable: "Users", wantSpec: "ADD INDEX ix_name (name), DROP COLUMN age"},
+		{name: "multi-line", stmt: "alter table users\n  modify name varchar(64)", wantOK: true, wantTable: "users", wantSpec: "modify name varchar(64)"},
+		{name: "rename column", stmt: "ALTER TABLE users RENAME COLUMN name TO full_name", wantOK: true, wantTable: "users", wantSpec: "RENAME COLUMN name TO full_name",
+			wantRenames: map[string]string{"name": "full_name"}},
+		{name: "change column", stmt: "ALTER TABLE users CHANGE COLUMN Name full_name VARCHAR(64), CHANGE age age BIGINT", wantOK: true, wantTable: "users",
+			wantSpec: "CHANGE COLUMN Name full_name VARCHAR(64), CHANGE age age BIGINT", wantRenames: map[string]string{"name": "full_name"}},
+		{name: "rename index", stmt: "ALTER TABLE users RENAME INDEX ix_a TO ix_b", wantOK: true, wantTable: "users", wantSpec: "RENAME INDEX ix_a TO ix_b"},
+		{name: "rename table", stmt: "ALTER TABLE users RENAME TO people"},
+		{name: "rename table without to", stmt: "ALTER TABLE users RENAME people"},
+		{name: "partition", stmt: "ALTER TABLE users ADD PARTITION (PARTITION p1 VALUES LESS THAN (100))"},
+		{name: "remove partitioning", stmt: "ALTER TABLE users REMOVE PARTITIONING"},
+		{name: "tablespace", stmt: "ALTER TABLE users DISCARD TABLESPACE"},
+		{name: "alembic version", stmt: "ALTER TABLE alembic_version ADD COLUMN x INT"},
+		{name: "not alter table", stmt: "CREATE TABLE users (id INT)"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			a, ok := parseOnlineAlter(tc.stmt)
+			if ok != tc.wantOK {
+				t.Fatalf("parseOnlineAlter(%q) ok = %v, want %v", tc.stmt, ok, tc.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if tc.wantRenames == nil {
+				tc.wantRenames = map[string]string{}
+			}
+			if a.table != tc.wantTable || a.spec != tc.wantSpec || a.statement != tc.stmt || !reflect.DeepEqual(a.renames, tc.wantRenames) {
+				t.Fatalf("parseOnlineAlter(%q) = %+v, want table %q, spec %q, renames %v", tc.stmt, a, tc.wantTable, tc.wantSpec, tc.wantRenames)
+			}
+		})
+	}
+}
+
+// fakeSQL 记录执行的语句并按 query 返回查询结果的 database/sql 驱动，以 DSN 区分实例
+type fakeSQL struct {
+	mu sync.Mutex
+	// execs 依次执行的语句
+	execs []string
+	// query 返回查询的列及行，columns 为空时返回没有行的结果
+	query func(q string, args []driver.NamedValue) (columns []string, rows [][]driver.Value)
+	// fail 执行包含该片段的语句时返回错误
+	fail string
+}
+
+var fakeSQLs sync.Map
+
+func init() {
+	sql.Register("fakesql", fakeSQLDriver{})
+}
+
+// openFakeSQL 注册 f 并以 fakesql 驱动连接
+func openFakeSQL(t *testing.T, f *fakeSQL) *sql.DB {
+	t.Helper()
+	fakeSQLs.Store(t.Name(), f)
+	db, err := sql.Open("fakesql", t.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = db.Close(); fakeSQLs.Delete(t.Name()) })
+	return db
+}
+
+func (f *fakeSQL) statements() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.execs...)
+}
+
+type fakeSQLDriver struct{}
+
+func (fakeSQLDriver) Open(name string) (driver.Conn, error) {
+	f, ok := fakeSQLs.Load(name)
+	if !ok {
+		return nil, fmt.Errorf("fakesql '%s' not registered", name)
+	}
+	return &fakeSQLConn{f: f.(*fakeSQL)}, nil
+}
+
+type fakeSQLConn struct{ f *fakeSQL }
+
+func (c *fakeSQLConn) Prepare(string) (driver.Stmt, error) {
+	return nil, errors.New("prepare not supported")
+}
+func (c *fakeSQLConn) Close() error { return nil }
+func (c *fakeSQLConn) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions not supported")
+}
+
+func (c *fakeSQLConn) ExecContext(_ context.Context, query string, _ []driver.NamedValue) (driver.Result, error) {
+	c.f.mu.Lock()
+	defer c.f.mu.Unlock()
+	c.f.execs = append(c.f.execs, query)
+	if c.f.fail != "" && strings.Contains(query, c.f.fail) {
+		return nil, errors.New("injected failure")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (c *fakeSQLConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	columns, rows := c.f.query(query, args)
+	return &fakeSQLRows{columns: columns, rows: rows}, nil
+}
+
+type fakeSQLRows struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+func (r *fakeSQLRows) Columns() []string { return r.columns }
+func (r *fakeSQLRows) Close() error      { return nil }
+
+func (r *fakeSQLRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+// oscSchema 表 users(id, name) 有两行，影子表在 ALTER 之后增加了 age 列
+func oscSchema(q string, args []driver.NamedValue) ([]string, [][]driver.Value) {
+	arg := func(i int) interface{} {
+		if i < len(args) {
+			return args[i].Value
+		}
+		return nil
+	}
+	one := func(v driver.Value) ([]string, [][]driver.Value) { return []string{"v"}, [][]driver.Value{{v}} }
+	switch {
+	case strings.Contains(q, "KEY_COLUMN_USAGE"):
+		return one(int64(0))
+	case strings.Contains(q, "TABLE_ROWS"):
+		return one(int64(2))
+	case strings.Contains(q, "SELECT COUNT(*) FROM information_schema.TABLES"):
+		return one(int64(0))
+	case strings.Contains(q, "information_schema.COLUMNS") && arg(0) == "users":
+		return []string{"COLUMN_NAME"}, [][]driver.Value{{"id"}, {"name"}}
+	case strings.Contains(q, "information_schema.COLUMNS"):
+		return []string{"COLUMN_NAME"}, [][]driver.Value{{"id"}, {"name"}, {"age"}}
+	case strings.Contains(q, "information_schema.STATISTICS"):
+		return []string{"COLUMN_NAME"}, [][]driver.Value{{"id"}}
+	case strings.Contains(q, "LIMIT 1 OFFSET"):
+		// 每批一行，依次返回主键 1、2，之后没有更多的行
+		switch arg(0) {
+		case nil:
+			return one(int64(1))
+		case int64(1):
+			return one(int64(2))
+		}
+	}
+	return nil, nil
+}
+
+func TestOnlineSchemaChange(t *testing.T) {
+	triggers := []string{
+		"CREATE TRIGGER `_users_ins` AFTER INSERT ON `users` FOR EACH ROW REPLACE INTO `_users_new` (`id`, `name`) VALUES (NEW.`id`, NEW.`name`)",
+		"CREATE TRIGGER `_users_upd` AFTER UPDATE ON `users` FOR EACH ROW BEGIN DELETE IGNORE FROM `_users_new` WHERE (NOT (OLD.`id` <=> NEW.`id`)) AND `id` <=> OLD.`id`; " +
+			"REPLACE INTO `_users_new` (`id`, `name`) VALUES (NEW.`id`, NEW.`name`); END",
+		"CREATE TRIGGER `_users_del` AFTER DELETE ON `users` FOR EACH ROW DELETE IGNORE FROM `_users_new` WHERE `id` <=> OLD.`id`",
+	}
+	insert := "INSERT LOW_PRIORITY IGNORE INTO `_users_new` (`id`, `name`) SELECT `id`, `name` FROM `users` FORCE INDEX (PRIMARY) WHERE "
+	prepare := append([]string{"CREATE TABLE `_users_new` LIKE `users`", "ALTER TABLE `_users_new` ADD COLUMN age INT"}, triggers...)
+	copied := []string{
+		insert + "1 = 1 AND (`id`) <= (?) LOCK IN SHARE MODE",
+		insert + "(`id`) > (?) AND (`id`) <= (?) LOCK IN SHARE MODE",
+		insert + "(`id`) > (?) LOCK IN SHARE MODE",
+	}
+	swap := []string{
+		"RENAME TABLE `users` TO `_users_old`, `_users_new` TO `users`",
+		"DROP TRIGGER IF EXISTS `_users_ins`",
+		"DROP TRIGGER IF EXISTS `_users_upd`",
+		"DROP TRIGGER IF EXISTS `_users_del`",
+		"DROP TABLE IF EXISTS `_users_old`",
+	}
+	cleanup := func(triggers int) []string {
+		var stmts []string
+		for _, name := range []string{"ins", "upd", "del"}[:triggers] {
+			stmts = append(stmts, "DROP TRIGGER IF EXISTS `_users_"+name+"`")
+		}
+		return append(stmts, "DROP TABLE IF EXISTS `_users_new`")
+	}
+	concat := func(parts ...[]string) (out []string) {
+		for _, p := range parts {
+			out = append(out, p...)
+		}
+		return
+	}
+	for _, tc := range []struct {
+		name      string
+		fail      string
+		wantExecs []string
+	}{
+		{name: "success", wantExecs: concat(prepare, copied, swap)},
+		{name: "alter fails", fail: "ALTER TABLE", wantExecs: concat(prepare[:2], cleanup(0))},
+		{name: "trigger fails", fail: "_users_upd` AFTER", wantExecs: concat(prepare[:4], cleanup(1))},
+		{name: "copy fails", fail: "(`id`) > (?) AND", wantExecs: concat(prepare, copied[:2], cleanup(3))},
+		{name: "rename fails", fail: "RENAME TABLE", wantExecs: concat(prepare, copied, swap[:1], cleanup(3))},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := &fakeSQL{query: oscSchema, fail: tc.fail}
+			var progress []OSCProgress
+			g := newCore(nil, WithScriptRoot(t.TempDir()), WithLogHandler(&recordHandler{}), WithOSCChunkSize(1),
+				WithOSCProgress(func(p OSCProgress) { progress = append(progress, p) }))
+			a, ok := parseOnlineAlter("ALTER TABLE users ADD COLUMN age INT")
+			if !ok {
+				t.Fatal("parseOnlineAlter() not ok")
+			}
+			err := g.onlineSchemaChange(context.Background(), openFakeSQL(t, f), a)
+			if (err == nil) != (tc.fail == "") {
+				t.Fatalf("onlineSchemaChange() = %v", err)
+			}
+			if execs := f.statements(); !reflect.DeepEqual(execs, tc.wantExecs) {
+				t.Fatalf("executed\n%s\nwant\n%s", strings.Join(execs, "\n"), strings.Join(tc.wantExecs, "\n"))
+			}
+			if tc.fail == "" && (len(progress) != 3 || !progress[2].Done || progress[2].Total != 2) {
+				t.Fatalf("progress %+v, want 3 batches ending with done", progress)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PlanRisk 升级计划的风险等级
+type PlanRisk string
+
+const (
+	// PlanRiskLow 没有识别到破坏性 DDL
+	PlanRiskLow PlanRisk = "low"
+	// PlanRiskMedium 存在可能锁表或因已有数据失败的 DDL，如大表上建索引、新增没有默认值的 NOT NULL 列
+	PlanRiskMedium PlanRisk = "medium"
+	// PlanRiskHigh 存在会丢失数据的 DDL，如删除表、删除列、列类型收窄
+	PlanRiskHigh PlanRisk = "high"
+)
+
+var planRiskLevels = map[PlanRisk]int{PlanRiskLow: 0, PlanRiskMedium: 1, PlanRiskHigh: 2}
+
+// ddlKindRisks 破坏性 DDL 类型对应的风险等级
+var ddlKindRisks = map[DDLKind]PlanRisk{
+	DDLDropTable:             PlanRiskHigh,
+	DDLDropColumn:            PlanRiskHigh,
+	DDLNarrowingType:         PlanRiskHigh,
+	DDLNotNullWithoutDefault: PlanRiskMedium,
+	DDLIndexOnLargeTable:     PlanRiskMedium,
+}
+
+func maxRisk(a, b PlanRisk) PlanRisk {
+	if planRiskLevels[b] > planRiskLevels[a] {
+		return b
+	}
+	return a
+}
+
+// bookkeepingRegexp 匹配维护 alembic_version 表的语句
+var bookkeepingRegexp = regexp.MustCompile("(?is)^\\s*(?:CREATE\\s+TABLE(?:\\s+IF\\s+NOT\\s+EXISTS)?|INSERT\\s+INTO|UPDATE|DELETE\\s+FROM)\\s+`?" + alembicVersionTable + "`?(?:\\s|\\(|$)")
+
+func isBookkeeping(stmt string) bool { return bookkeepingRegexp.MatchString(stmt) }
+
+// PlanStep 升级计划中的一个版本
+type PlanStep struct {
+	Revision string `json:"revision"`
+	// From 父版本号，合并版本为逗号分隔的多个版本号，第一个版本时为空
+	From    string `json:"from,omitempty"`
+	Message string `json:"message,omitempty"`
+	// Statements 该版本的 SQL，不包含 alembic_version 的维护语句
+	Statements []string `json:"statements"`
+	// Bookkeeping 维护 alembic_version 的语句
+	Bookkeeping []string     `json:"bookkeeping"`
+	Risk        PlanRisk     `json:"risk"`
+	Findings    []DDLFinding `json:"findings,omitempty"`
+}
+
+// Plan 数据库当前版本到 head 的升级计划，Steps 按执行顺序排列
+type Plan struct {
+	DatabaseRevision string `json:"database_revision"`
+	Head             string `json:"head"`
+	// Bookkeeping 第一个版本之前的 alembic_version 维护语句，如从 base 升级时创建 alembic_version 表
+	Bookkeeping []string   `json:"bookkeeping,omitempty"`
+	Steps       []PlanStep `json:"steps"`
+	Risk        PlanRisk   `json:"risk"`
+}
+
+// UpToDate 数据库是否已是 head
+func (p Plan) UpToDate() bool { return len(p.Steps) == 0 }
+
+// Statements 计划中所有版本的 SQL 语句数量，不包含 alembic_version 的维护语句
+func (p Plan) Statements() int {
+	n := 0
+	for _, step := range p.Steps {
+		n += len(step.Statements)
+	}
+	return n
+}
+
+func (p Plan) String() string { return p.Text() }
+
+// Text 纯文本格式
+func (p Plan) Text() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "database revision: %s, head: %s\n", orNone(p.DatabaseRevision), orNone(p.Head))
+	if p.UpToDate() {
+		b.WriteString("database is up to date\n")
+		return b.String()
+	}
+	_, _ = fmt.Fprintf(&b, "%d revision(s), %d statement(s), risk: %s\n", len(p.Steps), p.Statements(), p.Risk)
+	for i, step := range p.Steps {
+		_, _ = fmt.Fprintf(&b, "\n[%d/%d] %s -> %s (risk: %s)", i+1, len(p.Steps), orBase(step.From), step.Revision, step.Risk)
+		if step.Message != "" {
+			b.WriteString(" " + step.Message)
+		}
+		b.WriteString("\n")
+		for _, f := range step.Findings {
+			b.WriteString("  ! " + f.String() + "\n")
+		}
+		for _, stmt := range step.Statements {
+			b.WriteString("  " + strings.ReplaceAll(stmt, "\n", "\n  ") + ";\n")
+		}
+	}
+	return b.String()
+}
+
+// Markdown Markdown 格式，便于作为 PR 评论
+func (p Plan) Markdown() string {
+	var b strings.Builder
+	b.WriteString("### Migration plan\n\n")
+	_, _ = fmt.Fprintf(&b, "Database revision: `%s`, head: `%s`\n\n", orNone(p.DatabaseRevision), orNone(p.Head))
+	if p.UpToDate() {
+		b.WriteString("Database is up to date.\n")
+		return b.String()
+	}
+	_, _ = fmt.Fprintf(&b, "**%d** revision(s), **%d** statement(s), risk: **%s**\n\n", len(p.Steps), p.Statements(), p.Risk)
+	b.WriteString("| # | Revision | Message | Statements | Risk |\n|---|---|---|---|---|\n")
+	for i, step := range p.Steps {
+		_, _ = fmt.Fprintf(&b, "| %d | `%s` | %s | %d | %s |\n", i+1, step.Revision,
+			strings.ReplaceAll(step.Message, "|", "\\|"), len(step.Statements), step.Risk)
+	}
+	for _, step := range p.Steps {
+		_, _ = fmt.Fprintf(&b, "\n#### `%s` -> `%s`", orBase(step.From), step.Revision)
+		if step.Message != "" {
+			b.WriteString(" " + step.Message)
+		}
+		b.WriteString("\n\n")
+		for _, f := range step.Findings {
+			_, _ = fmt.Fprintf(&b, "- :warning: %s\n", f.String())
+		}
+		if len(step.Findings) > 0 {
+			b.WriteString("\n")
+		}
+		b.WriteString("```sql\n")
+		for _, stmt := range step.Statements {
+			b.WriteString(stmt + ";\n")
+		}
+		b.WriteString("```\n")
+	}
+	return b.String()
+}
+
+// JSON JSON 格式
+func (p Plan) JSON() ([]byte, error) {
+	return json.MarshalIndent(p, "", "  ")
+}
+
+func orBase(revision string) string {
+	if revision == "" {
+		return "<base>"
+	}
+	return revision
+}
+
+// plan 由离线 SQL 的版本步骤构建升级计划，db 为空时自行连接数据库读取表信息，无法读取时仅依据 SQL 本身评估风险
+func (g *core) plan(ctx context.Context, db *sql.DB, graph *RevisionGraph, current, head string, preamble []string, steps []offlineStep) (plan Plan, err error) {
+	plan = Plan{DatabaseRevision: current, Head: head, Risk: PlanRiskLow}
+	for _, stmt := range preamble {
+		if isBookkeeping(stmt) {
+			plan.Bookkeeping = append(plan.Bookkeeping, stmt)
+		}
+	}
+	if len(steps) == 0 {
+		return
+	}
+	var statsErr error
+	if db == nil {
+		if db, statsErr = g.openDatabase(); statsErr == nil {
+			defer func() { _ = db.Close() }()
+		}
+	}
+	analyzer := &DDLAnalyzer{LargeTableRows: g.conf.GetDDLLargeTableRows(), Dialect: g.dialectName()}
+	if statsErr == nil {
+		analyzer.Tables, statsErr = g.tableStats(ctx, db)
+	}
+	if statsErr != nil {
+		g.logger.WarnContext(ctx, "load table stats for plan failed, assess risk without them", stageField(StagePlan), Field{Key: FieldError, Value: statsErr})
+	}
+	findings := make(map[string][]DDLFinding)
+	for _, f := range analyzer.analyze(steps) {
+		findings[f.Revision] = append(findings[f.Revision], f)
+	}
+	for _, s := range steps {
+		step := PlanStep{Revision: s.To, From: s.From, Statements: []string{}, Bookkeeping: []string{}, Risk: PlanRiskLow, Findings: findings[s.To]}
+		if r, ok := graph.Revision(s.To); ok {
+			step.Message = r.Message
+		}
+		for _, stmt := range s.Statements {
+			if isBookkeeping(stmt) {
+				step.Bookkeeping = append(step.Bookkeeping, stmt)
+			} else {
+				step.Statements = append(step.Statements, stmt)
+			}
+		}
+		for _, f := range step.Findings {
+			step.Risk = maxRisk(step.Risk, ddlKindRisks[f.Kind])
+		}
+		plan.Risk = maxRisk(plan.Risk, step.Risk)
+		plan.Steps = append(plan.Steps, step)
+	}
+	return
+}
@@ -0,0 +1,80 @@
+package migration
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	offline := renderOfflineSQL(true, []offlineStep{
+		{Upgrade: true, From: "", To: "a", Statements: []string{"CREATE TABLE users (id INT NOT NULL, PRIMARY KEY (id))"}},
+		// accounts 是已有的表，没有表信息时新增 NOT NULL 列视为中风险
+		{Upgrade: true, From: "a", To: "b", Statements: []string{"ALTER TABLE accounts ADD COLUMN age INT NOT NULL"}},
+		{Upgrade: true, From: "b", To: "c", Statements: []string{"DROP TABLE legacy", "ALTER TABLE users ADD COLUMN name VARCHAR(32)"}},
+	})
+	history := Revision{RevisionId: "c", Parents: []string{"b"}, IsHead: true, Path: "c_.py", Doc: "drop legacy"}.LogEntry() + "\n" +
+		Revision{RevisionId: "b", Parents: []string{"a"}, Path: "b_.py", Doc: "add age"}.LogEntry() + "\n" +
+		Revision{RevisionId: "a", Path: "a_.py", Doc: "create users"}.LogEntry()
+	replay := NewReplayExecutor().
+		On("db init", Reply{}).
+		On("db history --verbose", Reply{Stdout: history}).
+		On("db current --verbose", Reply{}).
+		On("db upgrade --sql", Reply{Stdout: offline})
+	// 没有数据库连接配置时仅依据 SQL 评估风险
+	g := newTestMigrate(t, replay, WithLogHandler(&recordHandler{}))
+	plan, err := g.Plan()
+	if err != nil {
+		t.Fatalf("Plan() = %v", err)
+	}
+	if plan.DatabaseRevision != "" || plan.Head != "c" || plan.Risk != PlanRiskHigh || plan.Statements() != 4 {
+		t.Fatalf("Plan() = %+v, want base -> c with 4 statements and high risk", plan)
+	}
+	if len(plan.Bookkeeping) != 1 || !strings.HasPrefix(plan.Bookkeeping[0], "CREATE TABLE alembic_version") {
+		t.Fatalf("plan bookkeeping %q, want the alembic_version table", plan.Bookkeeping)
+	}
+	for i, want := range []struct {
+		revision, from, message string
+		statements              []string
+		bookkeeping             string
+		risk                    PlanRisk
+		kinds                   []DDLKind
+	}{
+		{revision: "a", message: "create users", statements: []string{"CREATE TABLE users (id INT NOT NULL, PRIMARY KEY (id))"},
+			bookkeeping: upgradeVersionStatement("", "a"), risk: PlanRiskLow},
+		{revision: "b", from: "a", message: "add age", statements: []string{"ALTER TABLE accounts ADD COLUMN age INT NOT NULL"},
+			bookkeeping: upgradeVersionStatement("a", "b"), risk: PlanRiskMedium, kinds: []DDLKind{DDLNotNullWithoutDefault}},
+		{revision: "c", from: "b", message: "drop legacy", statements: []string{"DROP TABLE legacy", "ALTER TABLE users ADD COLUMN name VARCHAR(32)"},
+			bookkeeping: upgradeVersionStatement("b", "c"), risk: PlanRiskHigh, kinds: []DDLKind{DDLDropTable}},
+	} {
+		step := plan.Steps[i]
+		var kinds []DDLKind
+		for _, f := range step.Findings {
+			kinds = append(kinds, f.Kind)
+		}
+		if step.Revision != want.revision || step.From != want.from || step.Message != want.message || step.Risk != want.risk ||
+			!reflect.DeepEqual(step.Statements, want.statements) || !reflect.DeepEqual(step.Bookkeeping, []string{want.bookkeeping}) || !reflect.DeepEqual(kinds, want.kinds) {
+			t.Fatalf("step %d = %+v, want %+v", i, step, want)
+		}
+	}
+	for _, rendered := range []string{plan.Text(), plan.Markdown()} {
+		if !strings.Contains(rendered, "DROP TABLE legacy;") || strings.Contains(rendered, "alembic_version") {
+			t.Fatalf("rendered plan %q, want the statements without bookkeeping", rendered)
+		}
+	}
+}
+
+func TestPlanUpToDate(t *testing.T) {
+	head := Revision{RevisionId: "a", IsHead: true, Path: "a_.py", Doc: "a"}.LogEntry()
+	replay := NewReplayExecutor().
+		On("db init", Reply{}).
+		On("db history --verbose", Reply{Stdout: head}).
+		On("db current --verbose", Reply{Stdout: head})
+	plan, err := newTestMigrate(t, replay).Plan()
+	if err != nil || !plan.UpToDate() || plan.Risk != PlanRiskLow {
+		t.Fatalf("Plan() = %+v, %v, want up to date", plan, err)
+	}
+	if !strings.Contains(plan.Text(), "database is up to date") {
+		t.Fatalf("Text() = %q", plan.Text())
+	}
+}
@@ -0,0 +1,297 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultPostgresPort = 5432
+	// postgresMaintenanceDB 建库及迁移锁使用的库，库尚不存在时也可连接
+	postgresMaintenanceDB = "postgres"
+	// postgresLockPollInterval 等待迁移锁时的重试间隔
+	postgresLockPollInterval = 500 * time.Millisecond
+	// postgresDuplicateDatabase 并发建库时 CREATE DATABASE 返回的 SQLSTATE
+	postgresDuplicateDatabase = "42P04"
+)
+
+// postgresSchemes SQLAlchemy 及 Go 驱动中 PostgreSQL URL 的 scheme
+var postgresSchemes = map[string]bool{"postgres": true, "postgresql": true}
+
+// postgresKeywordRegexp 匹配 libpq 的 key=value 格式连接串
+var postgresKeywordRegexp = regexp.MustCompile(`^\s*[a-z_]+\s*=`)
+
+// postgresKeywordValueRegexp 匹配 key=value 格式中的一项，值可以单引号包裹
+var postgresKeywordValueRegexp = regexp.MustCompile(`([a-z_]+)\s*=\s*(?:'((?:[^'\\]|\\.)*)'|(\S*))`)
+
+// postgresDialect PostgreSQL 方言，连接串兼容 lib/pq 及 pgx，驱动由使用方导入并注册为 postgres
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string       { return DialectPostgres }
+func (postgresDialect) DriverName() string { return "postgres" }
+func (postgresDialect) DefaultPort() int   { return defaultPostgresPort }
+
+func (dialect postgresDialect) FormatDSN(d *DSN, withDB bool) (string, error) {
+	dbName := d.DBName
+	if !withDB {
+		dbName = postgresMaintenanceDB
+	}
+	return dialect.url(d, "postgres", dbName, false), nil
+}
+
+func (dialect postgresDialect) SQLAlchemyURL(d *DSN) (string, error) {
+	scheme := "postgresql"
+	if d.Driver != "" {
+		scheme += "+" + d.Driver
+	}
+	return dialect.url(d, scheme, d.DBName, true), nil
+}
+
+// url Go 驱动与 SQLAlchemy 使用相同的 URL 格式，unix socket 目录通过 host 参数指定，
+// libpq 不接受 search_path 参数，SQLAlchemy URL 中以 options=-csearch_path=... 传递
+func (dialect postgresDialect) url(d *DSN, scheme, dbName string, sqlAlchemy bool) string {
+	u := &url.URL{Scheme: scheme, Path: "/" + dbName}
+	if d.User != "" || d.Password != "" {
+		if d.Password != "" {
+			u.User = url.UserPassword(d.User, d.Password)
+		} else {
+			u.User = url.User(d.User)
+		}
+	}
+	query := url.Values{}
+	if d.Socket != "" {
+		query.Set("host", d.Socket)
+	} else {
+		u.Host = net.JoinHostPort(d.Host, strconv.Itoa(d.port()))
+	}
+	if d.TLS != "" {
+		query.Set("sslmode", d.TLS)
+	}
+	for k, v := range d.Params {
+		if sqlAlchemy && k == "search_path" {
+			k, v = "options", "-csearch_path="+v
+		}
+		query.Set(k, v)
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (postgresDialect) Placeholder(n int) string { return postgresPlaceholder(n) }
+
+func (postgresDialect) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// CreateDatabase 在维护库上创建库，search_path 参数中的第一个 schema 不存在时一并创建
+func (dialect postgresDialect) CreateDatabase(ctx context.Context, d *DSN) (err error) {
+	var db *sql.DB
+	if db, err = openDSN(dialect, d, false); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	var count int
+	if err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM pg_database WHERE datname = $1", d.DBName).Scan(&count); err != nil {
+		return connectionError(err)
+	}
+	if count == 0 {
+		if _, err = db.ExecContext(ctx, "CREATE DATABASE "+dialect.QuoteIdent(d.DBName)); err != nil && sqlState(err) != postgresDuplicateDatabase {
+			return connectionError(err)
+		}
+	}
+	schema := d.postgresSchema()
+	if schema == "" {
+		return nil
+	}
+	var sdb *sql.DB
+	if sdb, err = openDSN(dialect, d, true); err != nil {
+		return
+	}
+	defer func() { _ = sdb.Close() }()
+	_, err = sdb.ExecContext(ctx, "CREATE SCHEMA IF NOT EXISTS "+dialect.QuoteIdent(schema))
+	return connectionError(err)
+}
+
+func (postgresDialect) TableExistsQuery() string {
+	return "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = $1"
+}
+
+func (postgresDialect) TransactionalDDL() bool { return true }
+
+func (dialect postgresDialect) NewLocker(d *DSN) (Locker, error) {
+	return &postgresLocker{dialect: dialect, dsn: d}, nil
+}
+
+// postgresSchema search_path 参数中的第一个 schema，public 及 $user 视为已存在
+func (d *DSN) postgresSchema() string {
+	path := d.Params["search_path"]
+	schema := strings.Trim(strings.TrimSpace(strings.Split(path, ",")[0]), `"`)
+	if schema == "public" || schema == "$user" {
+		return ""
+	}
+	return schema
+}
+
+// parsePostgresURL 解析 postgres://、postgresql:// 及 postgresql+psycopg2:// 格式的 URL
+func parsePostgresURL(u *url.URL) (*DSN, error) {
+	d := &DSN{Dialect: DialectPostgres, Host: u.Hostname(), DBName: strings.TrimPrefix(u.Path, "/")}
+	if scheme := strings.SplitN(u.Scheme, "+", 2); len(scheme) == 2 {
+		d.Driver = scheme[1]
+	}
+	if u.User != nil {
+		d.User = u.User.Username()
+		d.Password, _ = u.User.Password()
+	}
+	if port := u.Port(); port != "" {
+		var err error
+		if d.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("invalid port '%s': %w", port, err)
+		}
+	}
+	for k := range u.Query() {
+		if err := d.setPostgresParam(k, u.Query().Get(k)); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// parsePostgresKeywords 解析 libpq 的 key=value 格式连接串，如 host=127.0.0.1 port=5432 dbname=app sslmode=disable
+func parsePostgresKeywords(s string) (*DSN, error) {
+	d := &DSN{Dialect: DialectPostgres}
+	for _, m := range postgresKeywordValueRegexp.FindAllStringSubmatch(s, -1) {
+		v := m[3]
+		if v == "" {
+			v = unquotePython(m[2])
+		}
+		var err error
+		switch m[1] {
+		case "user":
+			d.User = v
+		case "password":
+			d.Password = v
+		case "dbname":
+			d.DBName = v
+		case "port":
+			if d.Port, err = strconv.Atoi(v); err != nil {
+				return nil, fmt.Errorf("invalid port '%s': %w", v, err)
+			}
+		default:
+			err = d.setPostgresParam(m[1], v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// setPostgresParam host 为目录时视为 unix socket，sslmode 记录在 TLS 中
+func (d *DSN) setPostgresParam(k, v string) error {
+	switch k {
+	case "host":
+		if strings.HasPrefix(v, "/") {
+			d.Socket = v
+		} else {
+			d.Host = v
+		}
+	case "sslmode":
+		switch v {
+		case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+			d.TLS = v
+		default:
+			return fmt.Errorf("unsupported sslmode '%s'", v)
+		}
+	default:
+		if d.Params == nil {
+			d.Params = make(map[string]string)
+		}
+		d.Params[k] = v
+	}
+	return nil
+}
+
+// sqlState 驱动错误中的 SQLSTATE，lib/pq 与 pgx 的错误均实现了 SQLState() string
+func sqlState(err error) string {
+	var e interface{ SQLState() string }
+	if errors.As(err, &e) {
+		return e.SQLState()
+	}
+	return ""
+}
+
+// postgresLocker 基于 pg_try_advisory_lock 的 Locker，锁与维护库上的连接绑定，持有者记录在连接的 application_name 中
+type postgresLocker struct {
+	dialect postgresDialect
+	dsn     *DSN
+}
+
+func (l *postgresLocker) Lock(ctx context.Context, key string, owner string, wait time.Duration) (unlock func() error, err error) {
+	var db *sql.DB
+	if db, err = openDSN(l.dialect, l.dsn, false); err != nil {
+		return
+	}
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		_ = db.Close()
+		err = connectionError(err)
+		return
+	}
+	closeAll := func() {
+		_ = conn.Close()
+		_ = db.Close()
+	}
+	if _, err = conn.ExecContext(ctx, "SELECT set_config('application_name', $1, false)", owner); err != nil {
+		closeAll()
+		err = connectionError(err)
+		return
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		var got bool
+		if err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1))", key).Scan(&got); err != nil {
+			closeAll()
+			err = connectionError(err)
+			return
+		}
+		if got {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			holder, since := l.holder(ctx, conn, key)
+			closeAll()
+			return nil, &LockHeldError{Key: key, Holder: holder, Since: since, Wait: wait}
+		}
+		select {
+		case <-ctx.Done():
+			closeAll()
+			return nil, ctx.Err()
+		case <-time.After(postgresLockPollInterval):
+		}
+	}
+	unlock = func() error {
+		defer closeAll()
+		_, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1))", key)
+		return err
+	}
+	return
+}
+
+// holder 查询持有锁的连接的 application_name 及连接建立时间
+func (l *postgresLocker) holder(ctx context.Context, conn *sql.Conn, key string) (holder string, since time.Time) {
+	var name sql.NullString
+	var start sql.NullTime
+	_ = conn.QueryRowContext(ctx, `SELECT a.application_name, a.backend_start
+FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+AND l.classid = ((hashtext($1)::bigint >> 32) & 4294967295)::oid AND l.objid = (hashtext($1)::bigint & 4294967295)::oid`, key).Scan(&name, &start)
+	return name.String, start.Time
+}
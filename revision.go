@@ -0,0 +1,276 @@
+package migration
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// revisionCreateDateZoneLayout 配置了 timezone 的 Alembic 版本中带时区的 Create Date
+const revisionCreateDateZoneLayout = "2006-01-02 15:04:05.999999-07:00"
+
+// revisionDocIndent Alembic 输出中文档字符串每行的缩进
+const revisionDocIndent = "    "
+
+// revisionFlagRegexp Rev 行中版本号之后的标记，如 (head)、(branchpoint)
+var revisionFlagRegexp = regexp.MustCompile(`^\((\w+)\)$`)
+
+// Revision 版本，由 flask db history/show/current --verbose 的输出(Alembic Script.log_entry)解析
+type Revision struct {
+	RevisionId string
+	// Parents 父版本号，base 版本为空，合并版本有多个
+	Parents []string
+	// Dependencies Also depends on 中的依赖版本
+	Dependencies []string
+	// BranchesInto 分支点的子版本
+	BranchesInto []string
+	// BranchLabels 分支名
+	BranchLabels []string
+	IsHead       bool
+	// IsBranchPoint 有多个子版本
+	IsBranchPoint bool
+	// IsMergePoint 合并了多个父版本
+	IsMergePoint bool
+	// IsCurrent 数据库当前所在的版本，仅 flask db history --indicate-current 的输出中标记
+	IsCurrent bool
+	// Message 文档字符串的第一段，即生成版本时的 message
+	Message string
+	// Doc 完整的文档字符串，包含 Revision ID/Revises/Create Date
+	Doc string
+	// Path 版本文件路径
+	Path       string
+	CreateDate time.Time
+}
+
+// Revises 父版本号，合并版本以逗号分隔，base 版本为空
+func (r Revision) Revises() string { return strings.Join(r.Parents, ", ") }
+
+// String 版本号及标记，如 abc123 (head)
+func (r Revision) String() string {
+	s := r.RevisionId
+	for _, flag := range []struct {
+		set  bool
+		name string
+	}{{r.IsHead, "head"}, {r.IsBranchPoint, "branchpoint"}, {r.IsMergePoint, "mergepoint"}, {r.IsCurrent, "current"}} {
+		if flag.set {
+			s += " (" + flag.name + ")"
+		}
+	}
+	return s
+}
+
+// LogEntry 与 Alembic Script.log_entry 格式一致的文本，可由 parseRevisions 解析回 Revision
+func (r Revision) LogEntry() string {
+	var b strings.Builder
+	b.WriteString("Rev: " + r.String())
+	parents := r.Revises()
+	if parents == "" {
+		parents = "<base>"
+	}
+	if r.IsMergePoint {
+		b.WriteString("\nMerges: " + parents)
+	} else {
+		b.WriteString("\nParent: " + parents)
+	}
+	if len(r.Dependencies) > 0 {
+		b.WriteString("\nAlso depends on: " + strings.Join(r.Dependencies, ", "))
+	}
+	if r.IsBranchPoint {
+		b.WriteString("\nBranches into: " + strings.Join(r.BranchesInto, ", "))
+	}
+	if len(r.BranchLabels) > 0 {
+		b.WriteString("\nBranch names: " + strings.Join(r.BranchLabels, ", "))
+	}
+	b.WriteString("\nPath: " + r.Path + "\n\n")
+	for _, line := range strings.Split(r.Doc, "\n") {
+		b.WriteString(revisionDocIndent + line + "\n")
+	}
+	return b.String()
+}
+
+// revisionMessage 新版本的 message，submitComment 为空时使用 CommitID 加时间戳
+func (g *core) revisionMessage(submitComment string) string {
+	if message := strings.TrimSpace(submitComment); message != "" {
+		return message
+	}
+	return fmt.Sprintf("%s_%d", g.conf.GetCommitID(), time.Now().Unix())
+}
+
+// RevisionParseError flask db 输出中无法解析的行
+type RevisionParseError struct {
+	// Line 从1开始的行号
+	Line   int
+	Text   string
+	Reason string
+}
+
+func (e *RevisionParseError) Error() string {
+	return fmt.Sprintf("parse revisions: line %d: %s: %q", e.Line, e.Reason, e.Text)
+}
+
+// parseRevisions 解析 Alembic 输出的版本列表，第一个 Rev 行之前的内容(如 flask db current 的提示)被忽略
+func parseRevisions(s string) (out []Revision, err error) {
+	var (
+		r     *Revision
+		inDoc bool
+		doc   []string
+	)
+	finish := func() {
+		if r == nil {
+			return
+		}
+		r.Doc = strings.TrimSpace(strings.Join(doc, "\n"))
+		r.Message = strings.TrimSpace(strings.SplitN(r.Doc, "\n\n", 2)[0])
+		out = append(out, *r)
+	}
+	for i, line := range strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n") {
+		fail := func(reason string) error { return &RevisionParseError{Line: i + 1, Text: line, Reason: reason} }
+		if strings.HasPrefix(line, "Rev:") {
+			finish()
+			r, inDoc, doc = &Revision{}, false, nil
+			if err = parseRevisionRev(r, strings.TrimSpace(strings.TrimPrefix(line, "Rev:"))); err != nil {
+				return nil, fail(err.Error())
+			}
+			continue
+		}
+		if r == nil {
+			continue
+		}
+		if inDoc {
+			if strings.TrimSpace(line) == "" {
+				doc = append(doc, "")
+				continue
+			}
+			if !strings.HasPrefix(line, revisionDocIndent) {
+				return nil, fail("unexpected line after revision docstring")
+			}
+			text := strings.TrimPrefix(line, revisionDocIndent)
+			doc = append(doc, text)
+			if fieldErr := parseRevisionDocField(r, strings.TrimSpace(text)); fieldErr != nil {
+				return nil, fail(fieldErr.Error())
+			}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			if r.Path != "" {
+				inDoc = true
+			}
+			continue
+		}
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			return nil, fail("expected 'key: value'")
+		}
+		value := strings.TrimSpace(kv[1])
+		switch kv[0] {
+		case "Parent", "Merges":
+			if value != "<base>" {
+				r.Parents = splitRevisionList(value)
+			}
+		case "Also depends on":
+			r.Dependencies = splitRevisionList(value)
+		case "Branches into":
+			r.BranchesInto = splitRevisionList(value)
+		case "Branch names":
+			r.BranchLabels = splitRevisionList(value)
+		case "Path":
+			if value == "" {
+				return nil, fail("empty path")
+			}
+			r.Path = value
+		default:
+			return nil, fail(fmt.Sprintf("unknown field '%s'", kv[0]))
+		}
+	}
+	finish()
+	return out, nil
+}
+
+// parseRevisionRev 解析 Rev 行，如 "abc123 (head) (mergepoint)"
+func parseRevisionRev(r *Revision, value string) error {
+	fields := strings.Fields(value)
+	if len(fields) == 0 {
+		return fmt.Errorf("empty revision id")
+	}
+	r.RevisionId = fields[0]
+	for _, f := range fields[1:] {
+		m := revisionFlagRegexp.FindStringSubmatch(f)
+		if m == nil {
+			return fmt.Errorf("unknown revision flag '%s'", f)
+		}
+		switch m[1] {
+		case "head":
+			r.IsHead = true
+		case "branchpoint":
+			r.IsBranchPoint = true
+		case "mergepoint":
+			r.IsMergePoint = true
+		case "current":
+			r.IsCurrent = true
+		default:
+			return fmt.Errorf("unknown revision flag '%s'", f)
+		}
+	}
+	return nil
+}
+
+// parseRevisionDocField 解析文档字符串中的 Revision ID/Revises/Create Date，其他行只记录在 Doc 中
+func parseRevisionDocField(r *Revision, text string) error {
+	kv := strings.SplitN(text, ":", 2)
+	if len(kv) != 2 {
+		return nil
+	}
+	value := strings.TrimSpace(kv[1])
+	switch kv[0] {
+	case "Revision ID":
+		if value != r.RevisionId {
+			return fmt.Errorf("revision id '%s' does not match rev '%s'", value, r.RevisionId)
+		}
+	case "Revises":
+		if parents := splitRevisionList(value); len(r.Parents) == 0 {
+			r.Parents = parents
+		}
+	case "Create Date":
+		t, err := parseRevisionCreateDate(value)
+		if err != nil {
+			return err
+		}
+		r.CreateDate = t
+	}
+	return nil
+}
+
+// parseRevisionCreateDate 解析 Python str(datetime) 格式的时间，没有时区时为 UTC
+func parseRevisionCreateDate(value string) (time.Time, error) {
+	t, err := time.Parse(revisionCreateDateLayout, value)
+	if err == nil {
+		return t, nil
+	}
+	if t, zoneErr := time.Parse(revisionCreateDateZoneLayout, value); zoneErr == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid create date '%s': %w", value, err)
+}
+
+// formatRevisionCreateDate 与 Python str(datetime) 一致，微秒为0时省略小数部分
+func formatRevisionCreateDate(t time.Time) string {
+	layout := "2006-01-02 15:04:05"
+	if t.Nanosecond() != 0 {
+		layout += ".000000"
+	}
+	if t.Location() != time.UTC {
+		layout += "-07:00"
+	}
+	return t.Format(layout)
+}
+
+// splitRevisionList 解析逗号分隔的版本号列表，兼容旧版本 Alembic 输出的 (a, b) 格式
+func splitRevisionList(s string) (out []string) {
+	for _, p := range strings.Split(strings.Trim(s, "()"), ",") {
+		if p = strings.TrimSpace(p); p != "" && p != "<base>" {
+			out = append(out, p)
+		}
+	}
+	return
+}
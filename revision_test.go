@@ -0,0 +1,170 @@
+package migration
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sameRevisions 比较版本列表，CreateDate 以 time.Time.Equal 比较
+func sameRevisions(got, want []Revision) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		g, w := got[i], want[i]
+		if !g.CreateDate.Equal(w.CreateDate) {
+			return false
+		}
+		g.CreateDate, w.CreateDate = time.Time{}, time.Time{}
+		if !reflect.DeepEqual(g, w) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestParseRevisionsTestdata(t *testing.T) {
+	for _, tc := range []struct {
+		file string
+		want []Revision
+	}{
+		{
+			file: "history_verbose.txt",
+			want: []Revision{
+				{
+					RevisionId: "9f8e7d", Parents: []string{"5c6d7e", "7a8b9c"}, IsHead: true, IsMergePoint: true,
+					Message: "merge heads", Doc: "merge heads\n\nRevision ID: 9f8e7d\nRevises: 5c6d7e, 7a8b9c\nCreate Date: 2023-03-05 10:00:00.000001",
+					Path: "/app/migrations/versions/9f8e7d_merge_heads.py", CreateDate: time.Date(2023, 3, 5, 10, 0, 0, 1000, time.UTC),
+				},
+				{
+					RevisionId: "7a8b9c", Parents: []string{"1a2b3c"}, Dependencies: []string{"0d0e0f"}, BranchLabels: []string{"billing"},
+					Message: "add invoice", Doc: "add invoice\n\nRevision ID: 7a8b9c\nRevises: 1a2b3c\nCreate Date: 2023-03-04 09:00:00",
+					Path: "/app/migrations/versions/7a8b9c_add_invoice.py", CreateDate: time.Date(2023, 3, 4, 9, 0, 0, 0, time.UTC),
+				},
+				{
+					RevisionId: "5c6d7e", Parents: []string{"1a2b3c"},
+					Message: "add user email", Doc: "add user email\n\nRevision ID: 5c6d7e\nRevises: 1a2b3c\nCreate Date: 2023-03-03 08:00:00.123456",
+					Path: "/app/migrations/versions/5c6d7e_add_user_email.py", CreateDate: time.Date(2023, 3, 3, 8, 0, 0, 123456000, time.UTC),
+				},
+				{
+					RevisionId: "1a2b3c", BranchesInto: []string{"5c6d7e", "7a8b9c"}, IsBranchPoint: true,
+					Message: "init", Doc: "init\n\nRevision ID: 1a2b3c\nRevises: \nCreate Date: 2023-03-02 07:00:00.000000",
+					Path: "/app/migrations/versions/1a2b3c_init.py", CreateDate: time.Date(2023, 3, 2, 7, 0, 0, 0, time.UTC),
+				},
+			},
+		},
+		{
+			file: "show_timezone.txt",
+			want: []Revision{{
+				RevisionId: "4b5c6d", Parents: []string{"3a4b5c"}, IsHead: true, Message: "add orders",
+				Doc:  "add orders\n\ncreate the orders table with a\nmulti-line description\n\nRevision ID: 4b5c6d\nRevises: 3a4b5c\nCreate Date: 2023-06-07 12:34:56.789012+08:00",
+				Path: "/app/migrations/versions/4b5c6d_add_orders.py", CreateDate: time.Date(2023, 6, 7, 12, 34, 56, 789012000, time.FixedZone("", 8*3600)),
+			}},
+		},
+		{
+			file: "current_verbose.txt",
+			want: []Revision{{
+				RevisionId: "4b5c6d", Parents: []string{"3a4b5c"}, IsHead: true, Message: "add orders",
+				Doc:  "add orders\n\nRevision ID: 4b5c6d\nRevises: 3a4b5c\nCreate Date: 2023-06-07 12:34:56",
+				Path: "/app/migrations/versions/4b5c6d_add_orders.py", CreateDate: time.Date(2023, 6, 7, 12, 34, 56, 0, time.UTC),
+			}},
+		},
+		{
+			file: "history_legacy.txt",
+			want: []Revision{{
+				RevisionId: "c3d4e5", Parents: []string{"a1b2c3", "b2c3d4"}, IsHead: true, IsMergePoint: true, Message: "merge",
+				Doc:  "merge\n\nRevision ID: c3d4e5\nRevises: (a1b2c3, b2c3d4)\nCreate Date: 2016-01-02 03:04:05.000006",
+				Path: "/app/migrations/versions/c3d4e5_merge.py", CreateDate: time.Date(2016, 1, 2, 3, 4, 5, 6000, time.UTC),
+			}},
+		},
+	} {
+		t.Run(tc.file, func(t *testing.T) {
+			content, err := os.ReadFile(filepath.Join("testdata", tc.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseRevisions(string(content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameRevisions(got, tc.want) {
+				t.Fatalf("parseRevisions() = %+v, want %+v", got, tc.want)
+			}
+			// LogEntry 输出的文本可解析回相同的版本
+			var entries []string
+			for _, r := range got {
+				entries = append(entries, r.LogEntry())
+			}
+			again, err := parseRevisions(strings.Join(entries, "\n"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameRevisions(again, got) {
+				t.Fatalf("parseRevisions(LogEntry()) = %+v, want %+v", again, got)
+			}
+		})
+	}
+}
+
+func TestRevisionLogEntryRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		r    Revision
+	}{
+		{name: "base", r: Revision{RevisionId: "a", Path: "a_.py", Doc: "init", Message: "init"}},
+		{name: "current head", r: Revision{RevisionId: "b", Parents: []string{"a"}, IsHead: true, IsCurrent: true, Path: "b_.py", Doc: "b", Message: "b"}},
+		{name: "merge", r: Revision{RevisionId: "m", Parents: []string{"b", "c"}, IsMergePoint: true, Path: "m_.py", Doc: "merge", Message: "merge"}},
+		{name: "branchpoint with labels", r: Revision{RevisionId: "a", BranchesInto: []string{"b", "c"}, IsBranchPoint: true, BranchLabels: []string{"core", "billing"}, Path: "a_.py", Doc: "a", Message: "a"}},
+		{name: "dependency", r: Revision{RevisionId: "d", Parents: []string{"a"}, Dependencies: []string{"x", "y"}, Path: "d_.py", Doc: "d", Message: "d"}},
+		{
+			name: "create date",
+			r: Revision{RevisionId: "e", Parents: []string{"d"}, Path: "e_.py", Message: "add orders",
+				Doc:        "add orders\n\nRevision ID: e\nRevises: d\nCreate Date: 2023-06-07 12:34:56.789012+08:00",
+				CreateDate: time.Date(2023, 6, 7, 12, 34, 56, 789012000, time.FixedZone("", 8*3600))},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseRevisions(tc.r.LogEntry())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !sameRevisions(got, []Revision{tc.r}) {
+				t.Fatalf("parseRevisions(LogEntry()) = %+v, want %+v", got, tc.r)
+			}
+		})
+	}
+}
+
+func TestRevisionParseError(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		input  string
+		line   int
+		reason string
+	}{
+		{name: "empty rev", input: "Rev: \n", line: 1, reason: "empty revision id"},
+		{name: "unknown flag", input: "Rev: a (tail)\n", line: 1, reason: "unknown revision flag '(tail)'"},
+		{name: "unknown field", input: "Rev: a\nParent: <base>\nColor: red\n", line: 3, reason: "unknown field 'Color'"},
+		{name: "not key value", input: "Rev: a\nParent: <base>\ngarbage\n", line: 3, reason: "expected 'key: value'"},
+		{name: "empty path", input: "Rev: a\nParent: <base>\nPath: \n", line: 3, reason: "empty path"},
+		{name: "unindented doc", input: "Rev: a\nParent: <base>\nPath: a_.py\n\n    init\ntrailing\n", line: 6, reason: "unexpected line after revision docstring"},
+		{name: "revision id mismatch", input: "Rev: a\nParent: <base>\nPath: a_.py\n\n    init\n    Revision ID: b\n", line: 6, reason: "revision id 'b' does not match rev 'a'"},
+		{name: "bad create date", input: "Rev: a\nParent: <base>\nPath: a_.py\n\n    Create Date: yesterday\n", line: 5, reason: "invalid create date 'yesterday'"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := parseRevisions(tc.input)
+			var parseErr *RevisionParseError
+			if !errors.As(err, &parseErr) {
+				t.Fatalf("parseRevisions() = %v, want *RevisionParseError", err)
+			}
+			if parseErr.Line != tc.line || !strings.HasPrefix(parseErr.Reason, tc.reason) {
+				t.Fatalf("parseRevisions() = line %d %q, want line %d %q", parseErr.Line, parseErr.Reason, tc.line, tc.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,544 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// schema 表结构，由 DDL 推导或从 information_schema 读取，表名、列名、索引名均为小写
+type schema struct {
+	tables map[string]*schemaTable
+}
+
+type schemaTable struct {
+	name    string
+	columns []*schemaColumn
+	indexes []*schemaIndex
+}
+
+type schemaColumn struct {
+	name string
+	// typ 归一化后的列类型，见 normalizeColumnType
+	typ      string
+	nullable bool
+	// def 归一化后的默认值，nil 表示没有默认值
+	def *string
+}
+
+type schemaIndex struct {
+	name    string
+	columns []string
+	unique  bool
+}
+
+// primaryIndex 主键在 information_schema 中的索引名
+const primaryIndex = "primary"
+
+func newSchema() *schema {
+	return &schema{tables: make(map[string]*schemaTable)}
+}
+
+// isInternalTable 迁移工具自身使用的表，不参与表结构对比
+func isInternalTable(name string) bool {
+	return strings.EqualFold(name, alembicVersionTable) || strings.EqualFold(name, dataMigrationTable) || strings.EqualFold(name, auditTable)
+}
+
+// tableNames 按名称排序的表名
+func (s *schema) tableNames() []string {
+	names := make([]string, 0, len(s.tables))
+	for name := range s.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t *schemaTable) column(name string) (int, *schemaColumn) {
+	for i, c := range t.columns {
+		if c.name == name {
+			return i, c
+		}
+	}
+	return -1, nil
+}
+
+func (t *schemaTable) index(name string) (int, *schemaIndex) {
+	for i, idx := range t.indexes {
+		if idx.name == name {
+			return i, idx
+		}
+	}
+	return -1, nil
+}
+
+// addIndex 添加索引，未命名的索引与 MySQL 一致以第一列命名
+func (t *schemaTable) addIndex(idx *schemaIndex) {
+	if idx.name == "" && len(idx.columns) > 0 {
+		idx.name = idx.columns[0]
+		for i := 2; ; i++ {
+			if i, _ := t.index(idx.name); i < 0 {
+				break
+			}
+			idx.name = idx.columns[0] + "_" + strconv.Itoa(i)
+		}
+	}
+	if i, _ := t.index(idx.name); i >= 0 {
+		t.indexes[i] = idx
+		return
+	}
+	t.indexes = append(t.indexes, idx)
+}
+
+// addForeignKeyIndex 外键列上没有可用索引时，MySQL 会自动创建索引
+func (t *schemaTable) addForeignKeyIndex(name string, columns []string) {
+	for _, idx := range t.indexes {
+		if len(idx.columns) >= len(columns) && strings.Join(idx.columns[:len(columns)], ",") == strings.Join(columns, ",") {
+			return
+		}
+	}
+	t.addIndex(&schemaIndex{name: name, columns: columns})
+}
+
+func (t *schemaTable) setColumn(old string, c *schemaColumn) {
+	if i, _ := t.column(old); i >= 0 {
+		t.columns[i] = c
+	} else {
+		t.columns = append(t.columns, c)
+	}
+	if old != c.name {
+		for _, idx := range t.indexes {
+			for i := range idx.columns {
+				if idx.columns[i] == old {
+					idx.columns[i] = c.name
+				}
+			}
+		}
+	}
+}
+
+func (t *schemaTable) dropColumn(name string) {
+	if i, _ := t.column(name); i >= 0 {
+		t.columns = append(t.columns[:i], t.columns[i+1:]...)
+	}
+	// 与 MySQL 一致，删除列时从索引中移除该列，索引中没有列时删除索引
+	indexes := t.indexes[:0]
+	for _, idx := range t.indexes {
+		columns := idx.columns[:0]
+		for _, c := range idx.columns {
+			if c != name {
+				columns = append(columns, c)
+			}
+		}
+		if idx.columns = columns; len(columns) > 0 {
+			indexes = append(indexes, idx)
+		}
+	}
+	t.indexes = indexes
+}
+
+func (t *schemaTable) dropIndex(name string) {
+	if i, _ := t.index(name); i >= 0 {
+		t.indexes = append(t.indexes[:i], t.indexes[i+1:]...)
+	}
+}
+
+// newSchemaColumn 由解析得到的列定义构建 schemaColumn
+func newSchemaColumn(name string, c *ddlColumn) *schemaColumn {
+	column := &schemaColumn{name: name, typ: normalizeColumnType(c.typ), nullable: !c.notNull && !c.primary}
+	if c.hasDefault {
+		column.def = normalizeDefault(c.defaultValue)
+	}
+	return column
+}
+
+// apply 在表结构上执行一条 DDL 语句，无法识别的语句(如 DML)将被忽略
+func (s *schema) apply(stmt string) {
+	ts := newDDLTokens(stmt)
+	switch {
+	case ts.accept("CREATE", "TABLE"):
+		ts.accept("IF", "NOT", "EXISTS")
+		t := &schemaTable{name: ts.name()}
+		if ts.accept("(") {
+			for _, def := range ts.list() {
+				t.definition(def)
+			}
+		}
+		s.tables[t.name] = t
+	case ts.accept("CREATE"):
+		unique := ts.accept("UNIQUE")
+		ts.acceptAny("FULLTEXT", "SPATIAL")
+		if !ts.accept("INDEX") {
+			return
+		}
+		name := ts.name()
+		if !ts.accept("ON") {
+			return
+		}
+		if t := s.tables[ts.name()]; t != nil {
+			t.addIndex(&schemaIndex{name: name, unique: unique, columns: ts.indexColumns()})
+		}
+	case ts.accept("DROP", "TABLE"):
+		ts.accept("IF", "EXISTS")
+		for {
+			name := ts.name()
+			if name == "" {
+				break
+			}
+			delete(s.tables, name)
+			if !ts.accept(",") {
+				break
+			}
+		}
+	case ts.accept("DROP", "INDEX"):
+		name := ts.name()
+		if ts.accept("ON") {
+			if t := s.tables[ts.name()]; t != nil {
+				t.dropIndex(name)
+			}
+		}
+	case ts.accept("RENAME", "TABLE"):
+		for {
+			from := ts.name()
+			if from == "" || !ts.accept("TO") {
+				break
+			}
+			s.rename(from, ts.name())
+			if !ts.accept(",") {
+				break
+			}
+		}
+	case ts.accept("ALTER", "TABLE"):
+		name := ts.name()
+		t := s.tables[name]
+		if t == nil {
+			return
+		}
+		for _, clause := range ts.list() {
+			if to := t.alter(clause); to != "" {
+				s.rename(t.name, to)
+			}
+		}
+	}
+}
+
+func (s *schema) rename(from, to string) {
+	if t, ok := s.tables[from]; ok {
+		delete(s.tables, from)
+		t.name = to
+		s.tables[to] = t
+	}
+}
+
+// definition CREATE TABLE 括号中的一项，列定义或表级约束
+func (t *schemaTable) definition(ts *ddlTokens) {
+	if t.constraint(ts) {
+		return
+	}
+	name, c := ts.columnDefinition()
+	if c == nil {
+		return
+	}
+	t.setColumn(name, newSchemaColumn(name, c))
+	if c.primary {
+		t.addIndex(&schemaIndex{name: primaryIndex, unique: true, columns: []string{name}})
+	}
+	if c.unique {
+		t.addIndex(&schemaIndex{unique: true, columns: []string{name}})
+	}
+}
+
+// constraint 解析表级的主键、索引、唯一约束及外键，不是约束时返回 false 且不消耗 token
+func (t *schemaTable) constraint(ts *ddlTokens) bool {
+	start := ts.pos
+	var symbol string
+	if ts.accept("CONSTRAINT") {
+		if !strings.EqualFold(ts.peek(), "PRIMARY") && !strings.EqualFold(ts.peek(), "UNIQUE") &&
+			!strings.EqualFold(ts.peek(), "FOREIGN") && !strings.EqualFold(ts.peek(), "CHECK") {
+			symbol = ts.ident()
+		}
+	}
+	switch {
+	case ts.accept("PRIMARY", "KEY"):
+		columns := ts.indexColumns()
+		t.addIndex(&schemaIndex{name: primaryIndex, unique: true, columns: columns})
+		// 主键列隐式 NOT NULL
+		for _, name := range columns {
+			if _, c := t.column(name); c != nil {
+				c.nullable = false
+			}
+		}
+	case ts.accept("UNIQUE"):
+		ts.acceptAny("INDEX", "KEY")
+		name := symbol
+		if ts.peek() != "(" {
+			name = ts.ident()
+		}
+		t.addIndex(&schemaIndex{name: name, unique: true, columns: ts.indexColumns()})
+	case ts.acceptAny("INDEX", "KEY") != "", ts.acceptAny("FULLTEXT", "SPATIAL") != "":
+		ts.acceptAny("INDEX", "KEY")
+		var name string
+		if ts.peek() != "(" {
+			name = ts.ident()
+		}
+		t.addIndex(&schemaIndex{name: name, columns: ts.indexColumns()})
+	case ts.accept("FOREIGN", "KEY"):
+		name := symbol
+		if ts.peek() != "(" {
+			name = ts.ident()
+		}
+		columns := ts.indexColumns()
+		if name == "" && len(columns) > 0 {
+			name = columns[0]
+		}
+		t.addForeignKeyIndex(name, columns)
+	case ts.accept("CHECK"):
+	default:
+		ts.pos = start
+		return false
+	}
+	return true
+}
+
+// alter 执行 ALTER TABLE 的单个子句，重命名表时返回新表名
+func (t *schemaTable) alter(ts *ddlTokens) (renameTo string) {
+	switch {
+	case ts.accept("ADD"):
+		if t.constraint(ts) {
+			return
+		}
+		ts.accept("COLUMN")
+		name, c := ts.columnDefinition()
+		if c == nil {
+			return
+		}
+		t.setColumn(name, newSchemaColumn(name, c))
+		if c.primary {
+			t.addIndex(&schemaIndex{name: primaryIndex, unique: true, columns: []string{name}})
+		}
+		if c.unique {
+			t.addIndex(&schemaIndex{unique: true, columns: []string{name}})
+		}
+	case ts.accept("DROP", "PRIMARY", "KEY"):
+		t.dropIndex(primaryIndex)
+	case ts.accept("DROP", "FOREIGN", "KEY"), ts.accept("DROP", "CHECK"), ts.accept("DROP", "CONSTRAINT"):
+		// 删除外键时 MySQL 保留其自动创建的索引
+	case ts.accept("DROP", "INDEX"), ts.accept("DROP", "KEY"):
+		t.dropIndex(ts.ident())
+	case ts.accept("DROP"):
+		ts.accept("COLUMN")
+		t.dropColumn(ts.ident())
+	case ts.accept("MODIFY"):
+		ts.accept("COLUMN")
+		if name, c := ts.columnDefinition(); c != nil {
+			t.setColumn(name, t.keepPrimary(newSchemaColumn(name, c)))
+		}
+	case ts.accept("CHANGE"):
+		ts.accept("COLUMN")
+		old := ts.ident()
+		if name, c := ts.columnDefinition(); c != nil {
+			t.setColumn(old, t.keepPrimary(newSchemaColumn(name, c)))
+		}
+	case ts.accept("ALTER"):
+		ts.accept("COLUMN")
+		_, c := t.column(ts.ident())
+		switch {
+		case c == nil:
+		case ts.accept("SET", "DEFAULT"):
+			c.def = normalizeDefault(ts.expression())
+		case ts.accept("DROP", "DEFAULT"):
+			c.def = nil
+		}
+	case ts.accept("RENAME", "COLUMN"):
+		old := ts.ident()
+		if _, c := t.column(old); c != nil && ts.accept("TO") {
+			renamed := *c
+			renamed.name = ts.ident()
+			t.setColumn(old, &renamed)
+		}
+	case ts.accept("RENAME", "INDEX"), ts.accept("RENAME", "KEY"):
+		old := ts.ident()
+		if _, idx := t.index(old); idx != nil && ts.accept("TO") {
+			idx.name = ts.ident()
+		}
+	case ts.accept("RENAME"):
+		ts.acceptAny("TO", "AS")
+		return ts.name()
+	}
+	return
+}
+
+// keepPrimary 主键列修改定义后仍为 NOT NULL
+func (t *schemaTable) keepPrimary(c *schemaColumn) *schemaColumn {
+	if _, idx := t.index(primaryIndex); idx != nil {
+		for _, name := range idx.columns {
+			if name == c.name {
+				c.nullable = false
+			}
+		}
+	}
+	return c
+}
+
+// indexColumns 读取索引列列表，如 (a, b(10) DESC)，忽略前缀长度及排序
+func (ts *ddlTokens) indexColumns() (columns []string) {
+	if !ts.accept("(") {
+		return
+	}
+	for _, item := range ts.list() {
+		if name := item.ident(); name != "" {
+			columns = append(columns, name)
+		}
+	}
+	return
+}
+
+var typeAliases = map[string]string{
+	"integer":   "int",
+	"numeric":   "decimal",
+	"dec":       "decimal",
+	"fixed":     "decimal",
+	"real":      "double",
+	"bool":      "tinyint",
+	"boolean":   "tinyint",
+	"character": "char",
+	"nchar":     "char",
+	"nvarchar":  "varchar",
+}
+
+// normalizeColumnType 将列类型归一化为 information_schema.COLUMNS.COLUMN_TYPE 的格式，以便与数据库对比
+// 整数类型的显示宽度在 MySQL 8.0.19 之后不再输出，因此统一忽略(tinyint(1) 除外)
+func normalizeColumnType(c columnType) string {
+	bool1 := c.name == "bool" || c.name == "boolean"
+	if alias, ok := typeAliases[c.name]; ok {
+		c.name = alias
+	}
+	switch c.name {
+	case "tinyint", "smallint", "mediumint", "int", "bigint":
+		if bool1 || (c.name == "tinyint" && len(c.args) == 1 && c.args[0] == "1") {
+			c.args = []string{"1"}
+		} else {
+			c.args = nil
+		}
+	case "decimal":
+		switch len(c.args) {
+		case 0:
+			c.args = []string{"10", "0"}
+		case 1:
+			c.args = append(c.args, "0")
+		}
+	case "char", "binary", "bit":
+		if len(c.args) == 0 {
+			c.args = []string{"1"}
+		}
+	case "datetime", "timestamp", "time":
+		if len(c.args) == 1 && c.args[0] == "0" {
+			c.args = nil
+		}
+	}
+	return strings.ToLower(c.String())
+}
+
+// normalizeDefault 归一化默认值：去掉引号，将 now() 等统一为 CURRENT_TIMESTAMP，NULL 视为没有默认值
+func normalizeDefault(v string) *string {
+	v = strings.TrimSpace(v)
+	for len(v) >= 2 && v[0] == '(' && v[len(v)-1] == ')' {
+		v = strings.TrimSpace(v[1 : len(v)-1])
+	}
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		v = strings.NewReplacer("''", "'", `\'`, "'", `\\`, `\`).Replace(v[1 : len(v)-1])
+		return &v
+	}
+	switch lower := strings.ToLower(v); {
+	case lower == "null":
+		return nil
+	case lower == "true":
+		v = "1"
+	case lower == "false":
+		v = "0"
+	case lower == "now()" || lower == "localtimestamp" || lower == "localtimestamp()" ||
+		strings.HasPrefix(lower, "current_timestamp"):
+		v = "CURRENT_TIMESTAMP"
+	}
+	return &v
+}
+
+// loadSchema 从 information_schema 读取当前库的表结构
+func loadSchema(ctx context.Context, db *sql.DB) (s *schema, err error) {
+	s = newSchema()
+	var rows *sql.Rows
+	if rows, err = db.QueryContext(ctx, "SELECT TABLE_NAME FROM information_schema.TABLES WHERE TABLE_SCHEMA = DATABASE() AND TABLE_TYPE = 'BASE TABLE'"); err != nil {
+		return nil, connectionError(err)
+	}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			_ = rows.Close()
+			return
+		}
+		name = strings.ToLower(name)
+		s.tables[name] = &schemaTable{name: name}
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if rows, err = db.QueryContext(ctx, `SELECT TABLE_NAME, COLUMN_NAME, COLUMN_TYPE, IS_NULLABLE, COLUMN_DEFAULT
+FROM information_schema.COLUMNS WHERE TABLE_SCHEMA = DATABASE() ORDER BY TABLE_NAME, ORDINAL_POSITION`); err != nil {
+		return
+	}
+	for rows.Next() {
+		var table, name, typ, nullable string
+		var def sql.NullString
+		if err = rows.Scan(&table, &name, &typ, &nullable, &def); err != nil {
+			_ = rows.Close()
+			return
+		}
+		t := s.tables[strings.ToLower(table)]
+		if t == nil {
+			continue
+		}
+		c := &schemaColumn{name: strings.ToLower(name), typ: normalizeColumnType(parseColumnType(newDDLTokens(typ))), nullable: nullable == "YES"}
+		if def.Valid {
+			c.def = normalizeDefault(def.String)
+		}
+		t.columns = append(t.columns, c)
+	}
+	_ = rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	if rows, err = db.QueryContext(ctx, `SELECT TABLE_NAME, INDEX_NAME, NON_UNIQUE, COLUMN_NAME
+FROM information_schema.STATISTICS WHERE TABLE_SCHEMA = DATABASE() ORDER BY TABLE_NAME, INDEX_NAME, SEQ_IN_INDEX`); err != nil {
+		return
+	}
+	defer func() { _ = rows.Close() }()
+	for rows.Next() {
+		var table, name string
+		var column sql.NullString
+		var nonUnique int
+		if err = rows.Scan(&table, &name, &nonUnique, &column); err != nil {
+			return
+		}
+		t := s.tables[strings.ToLower(table)]
+		if t == nil {
+			continue
+		}
+		name = strings.ToLower(name)
+		_, idx := t.index(name)
+		if idx == nil {
+			idx = &schemaIndex{name: name, unique: nonUnique == 0}
+			t.indexes = append(t.indexes, idx)
+		}
+		// 函数索引没有列名
+		if column.Valid {
+			idx.columns = append(idx.columns, strings.ToLower(column.String))
+		}
+	}
+	err = rows.Err()
+	return
+}
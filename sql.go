@@ -0,0 +1,171 @@
+package migration
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// sqlSyntax 拆分语句时与方言相关的词法规则
+type sqlSyntax struct {
+	// hashComment # 开头的行注释，MySQL
+	hashComment bool
+	// backslashEscape 字符串中的反斜杠转义，MySQL 的所有字符串，PostgreSQL 仅 E'...'
+	backslashEscape bool
+	// dollarQuote $$...$$ 或 $tag$...$tag$ 包裹的字符串，PostgreSQL 的函数体、DO 块
+	dollarQuote bool
+	// triggerBody CREATE TRIGGER ... BEGIN ... END 中的分号不作为语句结束，SQLite
+	triggerBody bool
+}
+
+// createTriggerRegexp SQLite 的 CREATE TRIGGER 语句
+var createTriggerRegexp = regexp.MustCompile(`(?is)^CREATE\s+(TEMP\s+|TEMPORARY\s+)?TRIGGER\b`)
+
+// triggerEndRegexp 以 END 结尾的触发器
+var triggerEndRegexp = regexp.MustCompile(`(?i)\bEND$`)
+
+// sqlSyntaxOf 方言的词法规则，未知的方言按 MySQL 处理
+func sqlSyntaxOf(dialect string) sqlSyntax {
+	switch dialect {
+	case DialectPostgres:
+		return sqlSyntax{dollarQuote: true}
+	case DialectSQLite:
+		return sqlSyntax{triggerBody: true}
+	}
+	return sqlSyntax{hashComment: true, backslashEscape: true}
+}
+
+// splitStatements 将 SQL 文本按 ';' 拆分为独立的语句，忽略引号内的分号以及注释，引号及注释的规则由方言决定
+func splitStatements(dialect, content string) []string {
+	var (
+		out     []string
+		current strings.Builder
+		quote   rune
+		escape  bool
+		dollar  string
+		runes   = []rune(content)
+		syntax  = sqlSyntaxOf(dialect)
+	)
+	flush := func() {
+		if stmt := strings.TrimSpace(current.String()); stmt != "" {
+			out = append(out, stmt)
+		}
+		current.Reset()
+	}
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		if dollar != "" {
+			if strings.HasPrefix(string(runes[i:]), dollar) {
+				current.WriteString(dollar)
+				i += len([]rune(dollar)) - 1
+				dollar = ""
+			} else {
+				current.WriteRune(c)
+			}
+			continue
+		}
+		if quote != 0 {
+			current.WriteRune(c)
+			if c == '\\' && escape && i+1 < len(runes) {
+				i++
+				current.WriteRune(runes[i])
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			// PostgreSQL 中只有 E'...' 支持反斜杠转义
+			escape = syntax.backslashEscape && c != '`' ||
+				syntax.dollarQuote && c == '\'' && i > 0 && (runes[i-1] == 'E' || runes[i-1] == 'e')
+			current.WriteRune(c)
+		case c == '$' && syntax.dollarQuote && (i == 0 || !isIdentRune(runes[i-1])):
+			if tag := dollarQuoteTag(runes[i:]); tag != "" {
+				dollar = tag
+				current.WriteString(tag)
+				i += len([]rune(tag)) - 1
+			} else {
+				current.WriteRune(c)
+			}
+		case c == '#' && syntax.hashComment || (c == '-' && i+1 < len(runes) && runes[i+1] == '-' && (i+2 == len(runes) || isSpace(runes[i+2]))):
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			current.WriteRune('\n')
+		case c == '/' && i+1 < len(runes) && runes[i+1] == '*':
+			i += 2
+			for i+1 < len(runes) && !(runes[i] == '*' && runes[i+1] == '/') {
+				i++
+			}
+			i++
+			current.WriteRune(' ')
+		case c == ';':
+			stmt := strings.TrimSpace(current.String())
+			if syntax.triggerBody && createTriggerRegexp.MatchString(stmt) && !triggerEndRegexp.MatchString(stmt) {
+				current.WriteRune(c)
+				continue
+			}
+			flush()
+		default:
+			current.WriteRune(c)
+		}
+	}
+	flush()
+	return out
+}
+
+// dollarQuoteTag 以 $ 开头的 PostgreSQL dollar quote 标记，如 $$、$body$，不是标记时返回空(如 $1 占位符)
+func dollarQuoteTag(runes []rune) string {
+	for i := 1; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case c == '$':
+			return string(runes[:i+1])
+		case c == '_' || unicode.IsLetter(c) || (i > 1 && unicode.IsDigit(c)):
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+func isIdentRune(c rune) bool {
+	return c == '_' || c == '$' || unicode.IsLetter(c) || unicode.IsDigit(c)
+}
+
+func isSpace(c rune) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+var runningStepRegexp = regexp.MustCompile(`^-- Running (upgrade|downgrade) (.*?)\s*-> (.*)$`)
+
+// parseOfflineSQL 按 `-- Running upgrade a -> b` 标记将离线 SQL 拆分为版本步骤，第一个标记之前的语句作为 preamble 返回
+func parseOfflineSQL(dialect, content string) (preamble []string, steps []offlineStep) {
+	var (
+		current *offlineStep
+		body    strings.Builder
+	)
+	flush := func() {
+		stmts := splitStatements(dialect, body.String())
+		body.Reset()
+		if current == nil {
+			preamble = append(preamble, stmts...)
+			return
+		}
+		current.Statements = stmts
+		steps = append(steps, *current)
+	}
+	for _, line := range strings.Split(content, "\n") {
+		m := runningStepRegexp.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			body.WriteString(line + "\n")
+			continue
+		}
+		flush()
+		current = &offlineStep{Upgrade: m[1] == "upgrade", From: strings.TrimSpace(m[2]), To: strings.TrimSpace(m[3])}
+	}
+	flush()
+	return
+}
@@ -0,0 +1,64 @@
+package migration
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		dialect string
+		content string
+		want    []string
+	}{
+		{
+			name:    "mysql hash comment",
+			dialect: DialectMySQL,
+			content: "# comment;\nCREATE TABLE t (id INT); -- other;\nINSERT INTO t VALUES (1);",
+			want:    []string{"CREATE TABLE t (id INT)", "INSERT INTO t VALUES (1)"},
+		},
+		{
+			name:    "mysql backslash escape",
+			dialect: DialectMySQL,
+			content: `INSERT INTO t VALUES ('a\';b'); SELECT 1;`,
+			want:    []string{`INSERT INTO t VALUES ('a\';b')`, "SELECT 1"},
+		},
+		{
+			name:    "postgres hash is not a comment",
+			dialect: DialectPostgres,
+			content: "SELECT '{1}'::jsonb #> '{a}'; SELECT 2;",
+			want:    []string{"SELECT '{1}'::jsonb #> '{a}'", "SELECT 2"},
+		},
+		{
+			name:    "postgres dollar quote",
+			dialect: DialectPostgres,
+			content: "CREATE FUNCTION f() RETURNS trigger AS $body$ BEGIN NEW.a := 1; RETURN NEW; END; $body$ LANGUAGE plpgsql;\nSELECT $$a;b$$;",
+			want: []string{
+				"CREATE FUNCTION f() RETURNS trigger AS $body$ BEGIN NEW.a := 1; RETURN NEW; END; $body$ LANGUAGE plpgsql",
+				"SELECT $$a;b$$",
+			},
+		},
+		{
+			name:    "postgres standard string",
+			dialect: DialectPostgres,
+			content: `SELECT 'a\'; SELECT E'b\';c';`,
+			want:    []string{`SELECT 'a\'`, `SELECT E'b\';c'`},
+		},
+		{
+			name:    "sqlite trigger",
+			dialect: DialectSQLite,
+			content: "CREATE TRIGGER tr AFTER INSERT ON t BEGIN UPDATE t SET a = 1; DELETE FROM u; END;\nSELECT 1;",
+			want: []string{
+				"CREATE TRIGGER tr AFTER INSERT ON t BEGIN UPDATE t SET a = 1; DELETE FROM u; END",
+				"SELECT 1",
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := splitStatements(tc.dialect, tc.content); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("splitStatements() = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,333 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// sqliteMemory DSN.DBName 为该值或为空时为进程内的内存库
+	sqliteMemory = ":memory:"
+	// sqliteLockSuffix 迁移锁文件的后缀，锁文件与库文件位于同一目录
+	sqliteLockSuffix = ".migration.lock"
+	// sqliteLockPollInterval 等待迁移锁时的重试间隔
+	sqliteLockPollInterval = 100 * time.Millisecond
+)
+
+// sqliteDrivers 按优先级排列的 SQLite 驱动名，mattn/go-sqlite3 注册为 sqlite3，modernc.org/sqlite 注册为 sqlite
+var sqliteDrivers = []string{"sqlite3", "sqlite"}
+
+// sqliteDialect SQLite 方言，用于本地开发及测试，驱动由使用方导入
+// DBName 为库文件路径，相对路径以进程的工作目录解析；:memory: 或 mode=memory 参数为内存库，
+// 内存库在 Migration.Close 之前一直保持打开，只能用于 native 后端，migration 脚本所在的 flask 进程无法访问
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return DialectSQLite }
+
+// DriverName 已注册的 SQLite 驱动，均未注册时返回 sqlite3，打开时报错提示导入驱动
+func (sqliteDialect) DriverName() string {
+	registered := make(map[string]bool)
+	for _, name := range sql.Drivers() {
+		registered[name] = true
+	}
+	for _, name := range sqliteDrivers {
+		if registered[name] {
+			return name
+		}
+	}
+	return sqliteDrivers[0]
+}
+
+func (sqliteDialect) DefaultPort() int { return 0 }
+
+// FormatDSN SQLite URI 格式的文件名，如 file:/path/to/app.db?_foreign_keys=1，内存库以 cache=shared 在连接之间共享
+func (sqliteDialect) FormatDSN(d *DSN, _ bool) (string, error) {
+	query := url.Values{}
+	for k, v := range d.Params {
+		query.Set(k, v)
+	}
+	var path string
+	if d.sqliteMemory() {
+		path = d.DBName
+		if path == "" {
+			path = sqliteMemory
+		} else if path != sqliteMemory {
+			query.Set("mode", "memory")
+		}
+		query.Set("cache", "shared")
+	} else {
+		var err error
+		if path, err = filepath.Abs(d.DBName); err != nil {
+			return "", err
+		}
+		path = (&url.URL{Path: filepath.ToSlash(path)}).EscapedPath()
+	}
+	s := "file:" + path
+	if len(query) > 0 {
+		s += "?" + query.Encode()
+	}
+	return s, nil
+}
+
+// SQLAlchemyURL 库文件的绝对路径，如 sqlite:////path/to/app.db，Params 为 Go 驱动的参数，不写入 URL
+func (sqliteDialect) SQLAlchemyURL(d *DSN) (string, error) {
+	scheme := DialectSQLite
+	if d.Driver != "" {
+		scheme += "+" + d.Driver
+	}
+	if d.sqliteMemory() {
+		return scheme + "://", nil
+	}
+	path, err := filepath.Abs(d.DBName)
+	if err != nil {
+		return "", err
+	}
+	return scheme + ":///" + filepath.ToSlash(path), nil
+}
+
+func (sqliteDialect) Placeholder(int) string { return "?" }
+
+func (sqliteDialect) QuoteIdent(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// CreateDatabase 创建库文件所在的目录并打开库，库文件不存在时由驱动创建
+func (dialect sqliteDialect) CreateDatabase(ctx context.Context, d *DSN) (err error) {
+	if !d.sqliteMemory() {
+		var path string
+		if path, err = filepath.Abs(d.DBName); err != nil {
+			return
+		}
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return
+		}
+	}
+	var db *sql.DB
+	if db, err = dialect.Open(d, true); err != nil {
+		return
+	}
+	defer func() { _ = db.Close() }()
+	return connectionError(db.PingContext(ctx))
+}
+
+func (sqliteDialect) TableExistsQuery() string {
+	return "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+}
+
+func (sqliteDialect) TransactionalDDL() bool { return true }
+
+// NewLocker 库文件以同目录下的锁文件加锁，内存库只在进程内加锁
+func (dialect sqliteDialect) NewLocker(d *DSN) (Locker, error) {
+	if d.sqliteMemory() {
+		return processLocker, nil
+	}
+	path, err := filepath.Abs(d.DBName)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteLocker{dialect: dialect, dsn: &DSN{Dialect: DialectSQLite, DBName: path + sqliteLockSuffix}}, nil
+}
+
+var (
+	sqliteMemoryMu sync.Mutex
+	// sqliteMemoryDBs 保持内存库打开的连接，最后一个连接关闭时 SQLite 会释放内存库，由 Migration.Close 关闭
+	sqliteMemoryDBs = make(map[string]*sql.DB)
+)
+
+// Open 打开库，内存库首次打开时额外保持一个连接直到 releaseSQLiteMemory，使多次操作之间的数据得以保留
+func (dialect sqliteDialect) Open(d *DSN, withDB bool) (db *sql.DB, err error) {
+	var dsn string
+	if dsn, err = dialect.FormatDSN(d, withDB); err != nil {
+		return
+	}
+	if d.sqliteMemory() {
+		sqliteMemoryMu.Lock()
+		defer sqliteMemoryMu.Unlock()
+		if sqliteMemoryDBs[dsn] == nil {
+			var keep *sql.DB
+			if keep, err = sql.Open(dialect.DriverName(), dsn); err != nil {
+				return
+			}
+			if err = keep.Ping(); err != nil {
+				_ = keep.Close()
+				return
+			}
+			keep.SetMaxIdleConns(1)
+			sqliteMemoryDBs[dsn] = keep
+		}
+	}
+	return sql.Open(dialect.DriverName(), dsn)
+}
+
+// releaseSQLiteMemory 关闭内存库保持的连接，内存库中的数据随之释放
+func releaseSQLiteMemory(d *DSN) error {
+	dsn, err := sqliteDialect{}.FormatDSN(d, true)
+	if err != nil {
+		return err
+	}
+	sqliteMemoryMu.Lock()
+	keep := sqliteMemoryDBs[dsn]
+	delete(sqliteMemoryDBs, dsn)
+	sqliteMemoryMu.Unlock()
+	if keep == nil {
+		return nil
+	}
+	return keep.Close()
+}
+
+// sqliteMemory 是否为内存库
+func (d *DSN) sqliteMemory() bool {
+	return d.Dialect == DialectSQLite && (d.DBName == "" || d.DBName == sqliteMemory || d.Params["mode"] == "memory")
+}
+
+// parseSQLiteURL 解析 SQLAlchemy 格式的 URL，sqlite:///app.db 为相对路径，sqlite:////path/to/app.db 为绝对路径，sqlite:// 为内存库
+func parseSQLiteURL(u *url.URL) (*DSN, error) {
+	d := &DSN{Dialect: DialectSQLite, DBName: strings.TrimPrefix(u.Path, "/")}
+	if scheme := strings.SplitN(u.Scheme, "+", 2); len(scheme) == 2 {
+		d.Driver = scheme[1]
+	}
+	if u.Host != "" {
+		return nil, fmt.Errorf("invalid sqlite url, unexpected host '%s'", u.Host)
+	}
+	d.setSQLiteParams(u.Query())
+	return d, nil
+}
+
+// parseSQLiteFileURI 解析 Go 驱动使用的 URI 格式文件名，如 file:app.db?_foreign_keys=1、file::memory:?cache=shared
+func parseSQLiteFileURI(s string) (*DSN, error) {
+	s = strings.TrimPrefix(s, "file:")
+	var rawQuery string
+	if i := strings.IndexByte(s, '?'); i >= 0 {
+		s, rawQuery = s[:i], s[i+1:]
+	}
+	path, err := url.PathUnescape(s)
+	if err != nil {
+		return nil, err
+	}
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return nil, err
+	}
+	d := &DSN{Dialect: DialectSQLite, DBName: path}
+	d.setSQLiteParams(query)
+	return d, nil
+}
+
+// setSQLiteParams cache 参数由 FormatDSN 决定，不记录在 Params 中
+func (d *DSN) setSQLiteParams(query url.Values) {
+	for k := range query {
+		if k == "cache" {
+			continue
+		}
+		if d.Params == nil {
+			d.Params = make(map[string]string)
+		}
+		d.Params[k] = query.Get(k)
+	}
+}
+
+// sqliteLocker 以 BEGIN IMMEDIATE 持有锁文件的写锁，进程退出时由操作系统释放，不会残留过期的锁
+type sqliteLocker struct {
+	dialect sqliteDialect
+	dsn     *DSN
+}
+
+func (l *sqliteLocker) Lock(ctx context.Context, key string, owner string, wait time.Duration) (unlock func() error, err error) {
+	// 迁移锁先于建库获取，库文件所在的目录可能尚不存在
+	if err = os.MkdirAll(filepath.Dir(l.dsn.DBName), 0755); err != nil {
+		return
+	}
+	var db *sql.DB
+	if db, err = l.dialect.Open(l.dsn, true); err != nil {
+		return
+	}
+	var conn *sql.Conn
+	if conn, err = db.Conn(ctx); err != nil {
+		_ = db.Close()
+		err = connectionError(err)
+		return
+	}
+	closeAll := func() {
+		_ = conn.Close()
+		_ = db.Close()
+	}
+	// 锁被占用时立即返回 SQLITE_BUSY，由下面的循环重试
+	if _, err = conn.ExecContext(ctx, "PRAGMA busy_timeout = 0"); err != nil {
+		closeAll()
+		return
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		if _, err = conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err == nil {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			closeAll()
+			return nil, &LockHeldError{Key: key, Wait: wait}
+		}
+		select {
+		case <-ctx.Done():
+			closeAll()
+			return nil, ctx.Err()
+		case <-time.After(sqliteLockPollInterval):
+		}
+	}
+	unlock = func() error {
+		defer closeAll()
+		_, err := conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+	return
+}
+
+// processLocker 进程内的迁移锁
+var processLocker = &memoryLocker{held: make(map[string]*memoryLock)}
+
+type memoryLock struct {
+	owner string
+	since time.Time
+	done  chan struct{}
+}
+
+type memoryLocker struct {
+	mu   sync.Mutex
+	held map[string]*memoryLock
+}
+
+func (l *memoryLocker) Lock(ctx context.Context, key string, owner string, wait time.Duration) (unlock func() error, err error) {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		l.mu.Lock()
+		current := l.held[key]
+		if current == nil {
+			lock := &memoryLock{owner: owner, since: time.Now(), done: make(chan struct{})}
+			l.held[key] = lock
+			l.mu.Unlock()
+			return func() error {
+				l.mu.Lock()
+				defer l.mu.Unlock()
+				if l.held[key] == lock {
+					delete(l.held, key)
+					close(lock.done)
+				}
+				return nil
+			}, nil
+		}
+		l.mu.Unlock()
+		select {
+		case <-current.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+			return nil, &LockHeldError{Key: key, Holder: current.owner, Since: current.since, Wait: wait}
+		}
+	}
+}
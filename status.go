@@ -0,0 +1,176 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// StatusState 数据库版本相对于本地 head 的状态
+type StatusState string
+
+const (
+	// StatusUpToDate 数据库已是 head
+	StatusUpToDate StatusState = "up_to_date"
+	// StatusBehind 数据库版本是 head 的祖先，Pending 为待升级的版本
+	StatusBehind StatusState = "behind"
+	// StatusAhead 数据库版本在本地不存在，审计记录显示其由本地 head 升级而来，本地的版本文件落后于数据库
+	StatusAhead StatusState = "ahead"
+	// StatusDiverged 数据库版本在本地不存在，审计记录显示其由 head 之前的版本升级而来，与本地分叉
+	StatusDiverged StatusState = "diverged"
+	// StatusUnknownRevision 数据库版本在本地不存在，且无法由审计记录追溯来源
+	StatusUnknownRevision StatusState = "unknown_revision"
+)
+
+// Status 本地 head 与数据库版本的对比
+type Status struct {
+	Head             string      `json:"head"`
+	DatabaseRevision string      `json:"database_revision"`
+	State            StatusState `json:"state"`
+	// Pending 待升级的版本，按执行顺序排列
+	Pending []string `json:"pending"`
+	// Ancestor ahead/diverged 时数据库版本在本地存在的最近祖先，base 为空
+	Ancestor string `json:"ancestor,omitempty"`
+	// Git 版本目录是否位于 git 仓库中，为 false 时 Uncommitted 始终为空
+	Git bool `json:"git"`
+	// Uncommitted 版本目录中尚未提交到 git 的版本文件，路径相对于 git 仓库根目录
+	Uncommitted []string `json:"uncommitted"`
+}
+
+// UpToDate 数据库是否已是 head
+func (s Status) UpToDate() bool { return s.State == StatusUpToDate }
+
+// Clean 版本目录中是否没有未提交的版本文件
+func (s Status) Clean() bool { return len(s.Uncommitted) == 0 }
+
+func (s Status) String() string { return s.Table() }
+
+// Table 表格格式
+func (s Status) Table() string {
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	row := func(k, v string) { _, _ = fmt.Fprintf(w, "%s\t%s\n", k, v) }
+	row("head", orNone(s.Head))
+	row("database revision", orNone(s.DatabaseRevision))
+	row("state", string(s.State))
+	if s.State == StatusAhead || s.State == StatusDiverged {
+		row("ancestor", orBase(s.Ancestor))
+	}
+	pending := strconv.Itoa(len(s.Pending))
+	if len(s.Pending) > 0 {
+		pending += " (" + strings.Join(s.Pending, ", ") + ")"
+	}
+	row("pending", pending)
+	switch {
+	case !s.Git:
+		row("uncommitted", "unknown (not a git repository)")
+	case s.Clean():
+		row("uncommitted", "none")
+	default:
+		row("uncommitted", strings.Join(s.Uncommitted, ", "))
+	}
+	_ = w.Flush()
+	return b.String()
+}
+
+// JSON JSON 格式
+func (s Status) JSON() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// status 以版本图及数据库当前版本号计算状态，数据库版本在本地不存在时以 db 中的审计记录回溯，db 为空时此时才连接数据库
+func (g *core) status(ctx context.Context, db *sql.DB, graph *RevisionGraph, current string) (status Status, err error) {
+	status = Status{DatabaseRevision: current, Pending: []string{}, Uncommitted: []string{}}
+	if status.Head, err = graph.Head(); err != nil {
+		return
+	}
+	status.Uncommitted, status.Git = g.uncommittedRevisions(ctx)
+	_, known := graph.Revision(current)
+	switch {
+	case current == status.Head:
+		status.State = StatusUpToDate
+	case current == "" || known:
+		status.State = StatusBehind
+		if status.Pending, err = graph.Path(current, status.Head); err != nil {
+			return
+		}
+	default:
+		if db == nil {
+			if db, err = g.openDatabase(); err != nil {
+				return
+			}
+			defer func() { _ = db.Close() }()
+		}
+		var entries []AuditEntry
+		if entries, err = g.auditLog(ctx, db, 0); err != nil {
+			return
+		}
+		var found bool
+		status.Ancestor, found = auditAncestor(entries, graph, current)
+		switch {
+		case !found:
+			status.State = StatusUnknownRevision
+		case status.Ancestor == status.Head:
+			status.State = StatusAhead
+		default:
+			status.State = StatusDiverged
+		}
+	}
+	return
+}
+
+// auditAncestor 以成功的 Migrate/Upgrade/Stamp 审计记录回溯 revision 由哪个版本升级而来，返回第一个本地存在的版本(base 为空)，
+// found 为 false 时无法回溯到本地存在的版本
+func auditAncestor(entries []AuditEntry, graph *RevisionGraph, revision string) (ancestor string, found bool) {
+	seen := map[string]bool{revision: true}
+	for cur := revision; ; {
+		from, ok := "", false
+		// entries 按时间倒序排列，使用最近一次升级到 cur 的记录
+		for _, e := range entries {
+			upgraded := e.Operation == AuditUpgrade || e.Operation == AuditMigrate || e.Operation == AuditStamp
+			if upgraded && e.Outcome == AuditSuccess && e.ToRevision == cur && e.FromRevision != cur {
+				from, ok = e.FromRevision, true
+				break
+			}
+		}
+		if !ok || seen[from] {
+			return "", false
+		}
+		if _, known := graph.Revision(from); known || from == "" {
+			return from, true
+		}
+		seen[from] = true
+		cur = from
+	}
+}
+
+// uncommittedRevisions 版本目录中尚未提交到 git 的版本文件，git 不可用或不在 git 仓库中时 inGit 为 false
+func (g *core) uncommittedRevisions(ctx context.Context) (files []string, inGit bool) {
+	files = []string{}
+	output, _, err := g.exec(ctx, g.migrationBuildDir(), nil, "git", "status", "--porcelain", "--untracked-files=all", "--", migrationsVersionsDir)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if len(line) < 4 {
+			continue
+		}
+		// 格式为 XY PATH 或 XY ORIG -> PATH，包含特殊字符的路径以双引号包裹
+		file := line[3:]
+		if i := strings.LastIndex(file, " -> "); i >= 0 {
+			file = file[i+4:]
+		}
+		if unquoted, err := strconv.Unquote(file); err == nil {
+			file = unquoted
+		}
+		if ext := filepath.Ext(file); ext == ".py" || ext == ".sql" {
+			files = append(files, file)
+		}
+	}
+	return files, true
+}
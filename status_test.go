@@ -0,0 +1,95 @@
+package migration
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testGraph 线性版本图 a <- b，head 为 b
+func testGraph(t *testing.T) *RevisionGraph {
+	t.Helper()
+	graph, err := NewRevisionGraph([]Revision{{RevisionId: "b", Parents: []string{"a"}}, {RevisionId: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return graph
+}
+
+func upgraded(from, to string) AuditEntry {
+	return AuditEntry{Operation: AuditUpgrade, FromRevision: from, ToRevision: to, Outcome: AuditSuccess}
+}
+
+func TestAuditAncestor(t *testing.T) {
+	failed := upgraded("b", "c")
+	failed.Outcome = AuditFailure
+	for _, tc := range []struct {
+		name         string
+		entries      []AuditEntry
+		revision     string
+		wantAncestor string
+		wantFound    bool
+	}{
+		{name: "ahead", entries: []AuditEntry{upgraded("b", "c")}, revision: "c", wantAncestor: "b", wantFound: true},
+		{name: "diverged", entries: []AuditEntry{upgraded("a", "x")}, revision: "x", wantAncestor: "a", wantFound: true},
+		{name: "chain", entries: []AuditEntry{upgraded("c", "d"), upgraded("b", "c")}, revision: "d", wantAncestor: "b", wantFound: true},
+		{name: "from base", entries: []AuditEntry{upgraded("", "z")}, revision: "z", wantAncestor: "", wantFound: true},
+		{name: "latest entry wins", entries: []AuditEntry{upgraded("a", "c"), upgraded("b", "c")}, revision: "c", wantAncestor: "a", wantFound: true},
+		{name: "stamp", entries: []AuditEntry{{Operation: AuditStamp, FromRevision: "b", ToRevision: "c", Outcome: AuditSuccess}}, revision: "c", wantAncestor: "b", wantFound: true},
+		{name: "failure ignored", entries: []AuditEntry{failed}, revision: "c"},
+		{name: "downgrade ignored", entries: []AuditEntry{{Operation: AuditDowngrade, FromRevision: "b", ToRevision: "c", Outcome: AuditSuccess}}, revision: "c"},
+		{name: "no entries", revision: "c"},
+		{name: "cycle", entries: []AuditEntry{upgraded("c", "d"), upgraded("d", "c")}, revision: "d"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ancestor, found := auditAncestor(tc.entries, testGraph(t), tc.revision)
+			if ancestor != tc.wantAncestor || found != tc.wantFound {
+				t.Fatalf("auditAncestor() = %q, %v, want %q, %v", ancestor, found, tc.wantAncestor, tc.wantFound)
+			}
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		current string
+		// audit 不为空时写入内存 SQLite 的审计表，为空时不配置数据库，版本在本地存在时不应连接数据库
+		audit        []AuditEntry
+		wantState    StatusState
+		wantPending  []string
+		wantAncestor string
+	}{
+		{name: "up to date", current: "b", wantState: StatusUpToDate, wantPending: []string{}},
+		{name: "behind base", current: "", wantState: StatusBehind, wantPending: []string{"a", "b"}},
+		{name: "behind", current: "a", wantState: StatusBehind, wantPending: []string{"b"}},
+		{name: "ahead", current: "c", audit: []AuditEntry{upgraded("b", "c")}, wantState: StatusAhead, wantPending: []string{}, wantAncestor: "b"},
+		{name: "diverged", current: "x", audit: []AuditEntry{upgraded("a", "x")}, wantState: StatusDiverged, wantPending: []string{}, wantAncestor: "a"},
+		{name: "unknown", current: "y", audit: []AuditEntry{upgraded("a", "x")}, wantState: StatusUnknownRevision, wantPending: []string{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := []ConfOption{WithScriptRoot(t.TempDir())}
+			if tc.audit != nil {
+				opts = append(opts, WithDsn("file:status_"+string(tc.wantState)+"?mode=memory"))
+			}
+			g := newCore(nil, opts...)
+			defer func() { _ = g.Close() }()
+			start := time.Now()
+			for i, e := range tc.audit {
+				// 审计记录按时间倒序读取，先写入较早的记录
+				e.StartedAt = start.Add(-time.Duration(len(tc.audit)-i) * time.Minute)
+				if err := g.writeAudit(e); err != nil {
+					t.Fatal(err)
+				}
+			}
+			status, err := g.status(context.Background(), nil, testGraph(t), tc.current)
+			if err != nil {
+				t.Fatalf("status() = %v", err)
+			}
+			if status.Head != "b" || status.State != tc.wantState || !reflect.DeepEqual(status.Pending, tc.wantPending) || status.Ancestor != tc.wantAncestor {
+				t.Fatalf("status() = %+v, want state %s, pending %v, ancestor %q", status, tc.wantState, tc.wantPending, tc.wantAncestor)
+			}
+		})
+	}
+}
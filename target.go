@@ -0,0 +1,98 @@
+package migration
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// 升级/降级的目标版本标识
+const (
+	TargetHead  = "head"
+	TargetHeads = "heads"
+	TargetBase  = "base"
+)
+
+// resolveTarget 依据版本图与数据库当前版本 current 将 target 解析为具体的版本号，返回空表示 base
+// target 支持版本号(或唯一前缀)、相对步数(+N 用于升级，-N 用于降级)、head/heads、base
+func resolveTarget(graph *RevisionGraph, current, target string, upgrade bool) (revision string, err error) {
+	if current != "" {
+		if _, ok := graph.Revision(current); !ok {
+			return "", &RevisionNotFoundError{Revision: current, Reason: "database revision is not present in local revisions"}
+		}
+	}
+	target = strings.TrimSpace(target)
+	switch {
+	case target == "" || target == TargetHead || target == TargetHeads:
+		if revision, err = graph.Head(); err != nil {
+			return
+		}
+	case target == TargetBase:
+		revision = ""
+	case strings.HasPrefix(target, "+") || strings.HasPrefix(target, "-"):
+		var steps int
+		if steps, err = strconv.Atoi(target); err != nil || steps == 0 {
+			return "", fmt.Errorf("invalid relative revision '%s'", target)
+		}
+		if upgrade != (steps > 0) {
+			return "", fmt.Errorf("relative revision '%s' is not valid for %s", target, direction(upgrade))
+		}
+		if revision, err = relativeRevision(graph, current, steps); err != nil {
+			return
+		}
+	default:
+		if revision, err = graph.Find(target); err != nil {
+			return
+		}
+	}
+	if revision == current {
+		return
+	}
+	if upgrade && (revision == "" || (current != "" && !graph.IsAncestor(current, revision))) {
+		return "", fmt.Errorf("target revision '%s' is not ahead of database revision '%s', use downgrade instead", target, current)
+	}
+	if !upgrade && (current == "" || (revision != "" && !graph.IsAncestor(revision, current))) {
+		return "", fmt.Errorf("target revision '%s' is not behind database revision '%s', use upgrade instead", target, current)
+	}
+	return
+}
+
+// relativeRevision 从 current 出发移动 steps 步后的版本
+func relativeRevision(graph *RevisionGraph, current string, steps int) (string, error) {
+	if steps > 0 {
+		head, err := graph.Head()
+		if err != nil {
+			return "", err
+		}
+		path, err := graph.Path(current, head)
+		if err != nil {
+			return "", err
+		}
+		if len(path) < steps {
+			return "", fmt.Errorf("relative revision +%d didn't produce %d migrations", steps, steps)
+		}
+		return path[steps-1], nil
+	}
+	revision := current
+	for i := 0; i < -steps; i++ {
+		if revision == "" {
+			return "", fmt.Errorf("relative revision %d didn't produce %d migrations", steps, -steps)
+		}
+		parents := graph.Parents(revision)
+		if len(parents) > 1 {
+			return "", fmt.Errorf("relative revision %d is ambiguous at merge revision '%s'", steps, revision)
+		}
+		revision = ""
+		if len(parents) == 1 {
+			revision = parents[0]
+		}
+	}
+	return revision, nil
+}
+
+func direction(upgrade bool) string {
+	if upgrade {
+		return "upgrade"
+	}
+	return "downgrade"
+}
@@ -0,0 +1,76 @@
+package migration
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestResolveTarget(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		current string
+		target  string
+		upgrade bool
+		want    string
+		wantErr string
+	}{
+		{name: "head from base", target: TargetHead, upgrade: true, want: "e"},
+		{name: "heads", current: "b", target: TargetHeads, upgrade: true, want: "e"},
+		{name: "empty target", current: "a", upgrade: true, want: "e"},
+		{name: "already at head", current: "e", target: TargetHead, upgrade: true, want: "e"},
+		{name: "revision", current: "a", target: "c1", upgrade: true, want: "c1"},
+		{name: "prefix", current: "a", target: " d ", upgrade: true, want: "d"},
+		{name: "ambiguous prefix", current: "a", target: "c", upgrade: true, wantErr: "multiple revisions start with 'c'"},
+		{name: "unknown target", current: "a", target: "z", upgrade: true, wantErr: "can't locate revision identified by 'z'"},
+		{name: "unknown current", current: "z", target: TargetHead, upgrade: true, wantErr: "database revision is not present in local revisions"},
+		{name: "+1 from base", target: "+1", upgrade: true, want: "a"},
+		{name: "+3 across branches", current: "b", target: "+3", upgrade: true, want: "d"},
+		{name: "+N past head", current: "d", target: "+2", upgrade: true, wantErr: "relative revision +2 didn't produce 2 migrations"},
+		{name: "invalid relative", current: "a", target: "+x", upgrade: true, wantErr: "invalid relative revision '+x'"},
+		{name: "zero relative", current: "a", target: "+0", upgrade: true, wantErr: "invalid relative revision '+0'"},
+		{name: "upgrade with -N", current: "d", target: "-1", upgrade: true, wantErr: "relative revision '-1' is not valid for upgrade"},
+		{name: "upgrade to ancestor", current: "d", target: "b", upgrade: true, wantErr: "target revision 'b' is not ahead of database revision 'd', use downgrade instead"},
+		{name: "upgrade to other branch", current: "c1", target: "c2", upgrade: true, wantErr: "use downgrade instead"},
+		{name: "upgrade to base", current: "b", target: TargetBase, upgrade: true, wantErr: "use downgrade instead"},
+
+		{name: "-1", current: "e", target: "-1", want: "d"},
+		{name: "-N to base", current: "b", target: "-2", want: ""},
+		{name: "-N past base", current: "b", target: "-3", wantErr: "relative revision -3 didn't produce 3 migrations"},
+		{name: "-N across merge", current: "e", target: "-2", wantErr: "relative revision -2 is ambiguous at merge revision 'd'"},
+		{name: "base", current: "e", target: TargetBase, want: ""},
+		{name: "downgrade to branch", current: "e", target: "c1", want: "c1"},
+		{name: "downgrade with +N", current: "e", target: "+1", wantErr: "relative revision '+1' is not valid for downgrade"},
+		{name: "downgrade to descendant", current: "b", target: "e", wantErr: "target revision 'e' is not behind database revision 'b', use upgrade instead"},
+		{name: "downgrade from base", target: "a", wantErr: "use upgrade instead"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := resolveTarget(mergeGraph(t), tc.current, tc.target, tc.upgrade)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("resolveTarget() = %q, %v, want error %q", got, err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tc.want {
+				t.Fatalf("resolveTarget() = %q, %v, want %q", got, err, tc.want)
+			}
+		})
+	}
+}
+
+func TestResolveTargetMultipleHeads(t *testing.T) {
+	graph, err := NewRevisionGraph([]Revision{{RevisionId: "c", Parents: []string{"a"}}, {RevisionId: "b", Parents: []string{"a"}}, {RevisionId: "a"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{TargetHead, "+1"} {
+		if _, err = resolveTarget(graph, "a", target, true); !errors.Is(err, ErrMultipleHeads) {
+			t.Errorf("resolveTarget(%q) = %v, want ErrMultipleHeads", target, err)
+		}
+	}
+	// 指定版本时不受多个 head 影响
+	if got, err := resolveTarget(graph, "a", "b", true); got != "b" || err != nil {
+		t.Errorf("resolveTarget(b) = %q, %v, want b", got, err)
+	}
+}
@@ -0,0 +1,110 @@
+package migration
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 迁移过程中的各个阶段，可用于 WithStageTimeouts 按阶段配置超时时间
+const (
+	StageGenerate             = "generate"
+	StagePrepare              = "prepare"
+	StageCreateDatabase       = "create_database_if_not_exists"
+	StageGenerateRevision     = "generate_revision_script"
+	StageShowLocalRevision    = "show_local_revision"
+	StageShowDatabaseRevision = "show_database_revision"
+	StageShowDDL              = "show_ddl"
+	StageUpgrade              = "upgrade"
+	StageDowngrade            = "downgrade"
+	StageHistory              = "history"
+	StageDrift                = "drift"
+	StagePlan                 = "plan"
+	StageAuditLog             = "audit_log"
+	StageStatus               = "status"
+	StageStamp                = "stamp"
+	StageBaseline             = "baseline"
+	// StageLock 获取及释放迁移锁，仅用于日志字段，等待时间由 LockWaitTimeout 控制
+	StageLock = "lock"
+)
+
+// TimeoutError 阶段执行超时错误，Stage 为超时的阶段名
+type TimeoutError struct {
+	Stage   string
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("migration stage '%s' timed out after %s: %v", e.Stage, e.Timeout, e.Err)
+	}
+	return fmt.Sprintf("migration stage '%s' timed out: %v", e.Stage, e.Err)
+}
+
+func (e *TimeoutError) Unwrap() error { return e.Err }
+
+func (e *TimeoutError) Is(target error) bool { return target == ErrTimeout }
+
+// stageTimeout 获取阶段的超时时间，StageTimeouts 中的配置优先于 Timeout
+func stageTimeout(conf ConfVisitor, stage string) time.Duration {
+	if timeout, ok := conf.GetStageTimeouts()[stage]; ok {
+		return timeout
+	}
+	return conf.GetTimeout()
+}
+
+// stageStartKey 阶段开始时间在 context 中的 key
+type stageStartKey struct{}
+
+// stageKey 当前阶段名在 context 中的 key
+type stageKey struct{}
+
+// contextStage context 中的当前阶段名，不在任何阶段中时为空
+func contextStage(ctx context.Context) string {
+	stage, _ := ctx.Value(stageKey{}).(string)
+	return stage
+}
+
+// stageContext 为阶段创建带超时的 context，超时时间不大于0时仅可被取消，并通知 Hook 阶段开始，Hook 否决时返回 *VetoError
+func (g *core) stageContext(ctx context.Context, stage string) (context.Context, context.CancelFunc, error) {
+	ctx = context.WithValue(context.WithValue(ctx, stageStartKey{}, time.Now()), stageKey{}, stage)
+	var cancel context.CancelFunc
+	if timeout := stageTimeout(g.conf, stage); timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	return ctx, cancel, g.emit(ctx, Event{Type: EventStageStart, Stage: stage})
+}
+
+// stageError 若阶段因 context 超时失败，则转换为 TimeoutError，并通知 Hook 阶段结束
+func (g *core) stageError(ctx context.Context, stage string, err error) error {
+	err = g.timeoutError(ctx, stage, err)
+	var duration time.Duration
+	if start, ok := ctx.Value(stageStartKey{}).(time.Time); ok {
+		duration = time.Since(start)
+	}
+	_ = g.emit(ctx, Event{Type: EventStageEnd, Stage: stage, Duration: duration, Err: err})
+	if err != nil {
+		_ = g.emit(ctx, Event{Type: EventError, Stage: stage, Duration: duration, Err: err})
+	}
+	return err
+}
+
+func (g *core) timeoutError(ctx context.Context, stage string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var te *TimeoutError
+	if errors.As(err, &te) {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		timeout := stageTimeout(g.conf, stage)
+		g.logger.Log(LevelError, "stage timeout", Field{Key: FieldStage, Value: stage}, Field{Key: "timeout", Value: timeout}, Field{Key: FieldError, Value: err})
+		return &TimeoutError{Stage: stage, Timeout: timeout, Err: err}
+	}
+	return err
+}